@@ -0,0 +1,348 @@
+package main
+
+import "math"
+
+// Radii are clamped so each tangent uses at most this share of its line, which
+// leaves a little line in the middle (same rule as MakeCurve in route.js)
+const halfLineRatio float64 = 0.45
+
+// A route vertex and the fillet arc built on it
+type Corner struct {
+	Pt          LatLng  `json:"pt"`
+	Rad         float64 `json:"rad"`        // radius after clamping, 0 for a stop or an end point
+	AngChange   float64 `json:"ang_change"` // change of heading at the vertex, +ve is clockwise
+	TangentDist float64 `json:"tangent_dist"`
+	Tan1        LatLng  `json:"tan1"` // where the arc leaves the incoming line
+	Tan2        LatLng  `json:"tan2"` // where the arc joins the outgoing line
+	ArcCtre     LatLng  `json:"arc_ctre"`
+	ArcAng1     float64 `json:"arc_ang1"` // heading from the arc centre to SC
+	Spiral      float64 `json:"spiral"`   // clothoid length either side of the arc after clamping
+	Cant        float64 `json:"cant"`     // degrees as given, see Alignment.CantAt
+	SC          LatLng  `json:"sc"`       // where the arc starts, Tan1 without a spiral
+	CS          LatLng  `json:"cs"`       // where the arc ends, Tan2 without a spiral
+	Stop        bool    `json:"stop"`
+	Station     string  `json:"station,omitempty"`
+	Dwell       float64 `json:"dwell,omitempty"` // s at a station
+}
+
+// A straight line, clothoid or circular arc of the horizontal alignment
+type Element struct {
+	Kind    string  `json:"kind"` // "line", "spiral" or "arc"
+	Start   LatLng  `json:"start"`
+	End     LatLng  `json:"end"`
+	Length  float64 `json:"length"`
+	Station float64 `json:"station"` // distance from the start of the route
+	Vertex  int     `json:"vertex"`  // index of the route vertex the element runs into
+	Stop    bool    `json:"stop"`    // line ends at a stop
+
+	// Arcs only
+	Center LatLng  `json:"center,omitempty"`
+	Radius float64 `json:"radius,omitempty"`
+	Angle  float64 `json:"angle,omitempty"` // swept angle in degrees, +ve is clockwise
+	ArcAng float64 `json:"arc_ang,omitempty"`
+
+	// Spirals only. Radius is that of the arc and Angle the heading change.
+	// Heading is along the line at the straight end, pointing towards the arc
+	Heading float64 `json:"heading,omitempty"`
+	Entry   bool    `json:"entry,omitempty"` // the spiral runs from the line into the arc
+
+	geo Geodesic
+}
+
+type Alignment struct {
+	Corners  []Corner  `json:"corners"`
+	Elements []Element `json:"elements"`
+	Length   float64   `json:"length"`
+	AutoCant bool      `json:"auto_cant"`
+
+	geo Geodesic
+}
+
+// The route travelled from its last vertex to its first. The construction type
+// moves with its leg and the stations with their vertices
+func reverseRoute(route Route) Route {
+
+	n := len(route.Segments)
+	rev := route
+	rev.Segments = make([]Segment, n)
+	for i, s := range route.Segments {
+		s.Type = ""
+		if i > 0 {
+			s.Type = route.Segments[i-1].Type
+		}
+		rev.Segments[n-1-i] = s
+	}
+
+	rev.Stations = make([]Station, len(route.Stations))
+	for i, s := range route.Stations {
+		s.Vertex = n - 1 - s.Vertex
+		rev.Stations[i] = s
+	}
+	return rev
+}
+
+// Builds the tangent lines and fillet arcs described by the route vertices and
+// their Segment.Rad, the same way CalculateCorners and MakeRoute do in route.js.
+// Lengths, headings and tangent points are worked out with geo
+func makeAlignment(route Route, geo Geodesic) Alignment {
+
+	al := Alignment{geo: geo, AutoCant: route.AutoCant}
+
+	n := len(route.Segments)
+	if n == 0 {
+		return al
+	}
+
+	al.Corners = make([]Corner, n)
+	for i, s := range route.Segments {
+		al.Corners[i] = Corner{Pt: s.LatLng(), Tan1: s.LatLng(), Tan2: s.LatLng(), SC: s.LatLng(), CS: s.LatLng()}
+	}
+	al.Corners[0].Stop = true
+	al.Corners[n-1].Stop = true
+
+	for _, s := range route.Stations {
+		al.Corners[s.Vertex].Station = s.Name
+		al.Corners[s.Vertex].Dwell = s.Dwell
+	}
+
+	for a := 1; a < n-1; a++ {
+		rad := route.Segments[a].Rad
+		if al.Corners[a].Station != "" {
+			rad = 0
+		}
+		spiral := route.Segments[a].Spiral
+		if spiral == 0 && route.MaxJerk > 0 && rad > 0 {
+			spiral = jerkSpiral(rad, route.MaxJerk)
+		}
+		calcCorner(al.Corners, a, rad, spiral, geo)
+		if al.Corners[a].Rad > 0 {
+			al.Corners[a].Cant = route.Segments[a].Cant
+		}
+	}
+
+	for a := 1; a < n; a++ {
+		prev := al.Corners[a-1]
+		cnr := al.Corners[a]
+
+		al.addElement(Element{
+			Kind:   "line",
+			Start:  prev.Tan2,
+			End:    cnr.Tan1,
+			Length: geo.Distance(prev.Tan2, cnr.Tan1),
+			Vertex: a,
+			Stop:   cnr.Stop,
+		})
+
+		if cnr.Rad == 0 {
+			continue
+		}
+
+		spiralAng := cnr.spiralAngle()
+		if cnr.Spiral > 0 {
+			al.addElement(Element{
+				Kind:    "spiral",
+				Start:   cnr.Tan1,
+				End:     cnr.SC,
+				Length:  cnr.Spiral,
+				Vertex:  a,
+				Radius:  cnr.Rad,
+				Angle:   spiralAng,
+				Heading: geo.Heading(cnr.Tan1, cnr.Pt),
+				Entry:   true,
+			})
+		}
+		al.addElement(Element{
+			Kind:   "arc",
+			Start:  cnr.SC,
+			End:    cnr.CS,
+			Length: math.Abs(2 * math.Pi * cnr.Rad * (cnr.AngChange - 2*spiralAng) / 360),
+			Vertex: a,
+			Center: cnr.ArcCtre,
+			Radius: cnr.Rad,
+			Angle:  cnr.AngChange - 2*spiralAng,
+			ArcAng: cnr.ArcAng1,
+		})
+		if cnr.Spiral > 0 {
+			al.addElement(Element{
+				Kind:    "spiral",
+				Start:   cnr.CS,
+				End:     cnr.Tan2,
+				Length:  cnr.Spiral,
+				Vertex:  a,
+				Radius:  cnr.Rad,
+				Angle:   spiralAng,
+				Heading: geo.Heading(cnr.Tan2, cnr.Pt),
+			})
+		}
+	}
+
+	return al
+}
+
+// Distance along the route at which each vertex is passed, the middle of its
+// arc where it has one
+func (al *Alignment) VertexStations() []float64 {
+
+	st := make([]float64, len(al.Corners))
+	for _, e := range al.Elements {
+		if e.Kind == "arc" {
+			st[e.Vertex] = e.Station + e.Length/2
+		} else if e.Kind == "line" {
+			st[e.Vertex] = e.Station + e.Length
+		}
+	}
+	return st
+}
+
+// A curve as listed in an evaluation
+type CurveReport struct {
+	Vertex   int     `json:"vertex"`
+	Distance float64 `json:"distance"` // m from the start of the route to the vertex
+	Radius   float64 `json:"radius"`
+	Spiral   float64 `json:"spiral"` // m of clothoid each side
+	Cant     float64 `json:"cant"`   // degrees, chosen for the pod when the route has auto_cant
+	Angle    float64 `json:"angle"`  // degrees, +ve is clockwise
+	Length   float64 `json:"length"` // arc and clothoids
+}
+
+func (al *Alignment) Curves(pod Pod) []CurveReport {
+
+	st := al.VertexStations()
+	var curves []CurveReport
+	for i, c := range al.Corners {
+		if c.Rad == 0 {
+			continue
+		}
+		length := 2 * c.Spiral
+		for _, e := range al.Elements {
+			if e.Vertex == i && e.Kind == "arc" {
+				length += e.Length
+			}
+		}
+		curves = append(curves, CurveReport{i, st[i], c.Rad, c.Spiral, al.CantAt(i, pod), c.AngChange, length})
+	}
+	return curves
+}
+
+func (al *Alignment) addElement(e Element) {
+	e.Station = al.Length
+	e.geo = al.geo
+	al.Elements = append(al.Elements, e)
+	al.Length += e.Length
+}
+
+// Heading change over each of the corner's clothoids, +ve is clockwise
+func (c Corner) spiralAngle() float64 {
+	if c.Rad == 0 {
+		return 0
+	}
+	ang := radDeg(c.Spiral / (2 * c.Rad))
+	if c.AngChange < 0 {
+		return -ang
+	}
+	return ang
+}
+
+// Works out the fillet at vertex a with a clothoid of length spiral at either
+// end, clamping the radius and clothoids to fit the lines either side
+func calcCorner(cnrs []Corner, a int, rad float64, spiral float64, geo Geodesic) {
+
+	cnr := &cnrs[a]
+
+	// headings at the vertex itself, arriving and leaving
+	angBack := geo.Heading(cnr.Pt, cnrs[a-1].Pt)
+	angIn := wrapAngle(angBack + 180)
+	angOut := geo.Heading(cnr.Pt, cnrs[a+1].Pt)
+	cnr.AngChange = wrapAngle(angOut - angIn)
+
+	if rad <= 0 {
+		cnr.Stop = true
+		return
+	}
+	if cnr.AngChange == 0 || cnr.AngChange == -180 {
+		return
+	}
+
+	var bisAng float64
+	if cnr.AngChange >= 0 {
+		bisAng = angIn + 90 + cnr.AngChange/2
+	} else {
+		bisAng = angIn - 90 + cnr.AngChange/2
+	}
+
+	halfLineBefore := halfLineRatio * geo.Distance(cnrs[a-1].Pt, cnr.Pt)
+	halfLineAfter := halfLineRatio * geo.Distance(cnr.Pt, cnrs[a+1].Pt)
+	maxTangLength := math.Min(halfLineBefore, halfLineAfter)
+
+	// the clothoids between them turn through spiral/rad, which may not be more
+	// than the whole corner
+	delta := degRad(math.Abs(cnr.AngChange))
+	spiral = math.Min(spiral, rad*delta)
+
+	tangentDist := clothoidTangent(rad, spiral, delta)
+	if tangentDist > maxTangLength {
+		rad, spiral = rad*maxTangLength/tangentDist, spiral*maxTangLength/tangentDist
+		tangentDist = clothoidTangent(rad, spiral, delta)
+	}
+	shift, _ := clothoidShift(rad, spiral)
+
+	side := 1.0
+	if cnr.AngChange < 0 {
+		side = -1
+	}
+
+	cnr.Rad = rad
+	cnr.Spiral = spiral
+	cnr.TangentDist = tangentDist
+	cnr.Tan1 = geo.Offset(cnr.Pt, tangentDist, angBack)
+	cnr.Tan2 = geo.Offset(cnr.Pt, tangentDist, angOut)
+	cnr.ArcCtre = geo.Offset(cnr.Pt, (rad+shift)/math.Cos(delta/2), bisAng)
+	cnr.SC = spiralPoint(geo, cnr.Tan1, geo.Heading(cnr.Tan1, cnr.Pt), side, rad, spiral, spiral)
+	cnr.CS = spiralPoint(geo, cnr.Tan2, geo.Heading(cnr.Tan2, cnr.Pt), -side, rad, spiral, spiral)
+	cnr.ArcAng1 = geo.Heading(cnr.ArcCtre, cnr.SC)
+}
+
+// Point at distance d from the start of the element
+func (e Element) PointAt(d float64) LatLng {
+
+	if e.Kind == "spiral" {
+		side := 1.0
+		if e.Angle < 0 {
+			side = -1
+		}
+		if e.Entry {
+			return spiralPoint(e.geo, e.Start, e.Heading, side, e.Radius, e.Length, d)
+		}
+		// run back from the line end, where the curve turns the other way
+		return spiralPoint(e.geo, e.End, e.Heading, -side, e.Radius, e.Length, e.Length-d)
+	}
+	if e.Kind == "arc" {
+		swept := radDeg(d / e.Radius)
+		if e.Angle < 0 {
+			swept = -swept
+		}
+		return e.geo.Offset(e.Center, e.Radius, e.ArcAng+swept)
+	}
+	if e.Length == 0 {
+		return e.Start
+	}
+	return e.geo.Offset(e.Start, d, e.geo.Heading(e.Start, e.End))
+}
+
+// Point at a distance along the whole alignment
+func (al Alignment) PointAt(station float64) LatLng {
+
+	if len(al.Elements) == 0 {
+		if len(al.Corners) > 0 {
+			return al.Corners[0].Pt
+		}
+		return LatLng{}
+	}
+	for _, e := range al.Elements {
+		if station <= e.Station+e.Length {
+			return e.PointAt(math.Max(0, station-e.Station))
+		}
+	}
+	last := al.Elements[len(al.Elements)-1]
+	return last.End
+}
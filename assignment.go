@@ -0,0 +1,319 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Service units a day between stations, row from and column to. Stations are node
+// ids on a network and station names on a route
+type ODMatrix struct {
+	Stations []string    `json:"stations"`
+	Demand   [][]float64 `json:"demand"`
+}
+
+// Reads a matrix as JSON, or as CSV with the station names along the top row
+// and down the first column
+func parseODMatrix(body []byte) (ODMatrix, error) {
+
+	var m ODMatrix
+
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 && body[0] == '{' {
+		if err := json.Unmarshal(body, &m); err != nil {
+			return m, err
+		}
+	} else {
+		rows, err := csv.NewReader(bytes.NewReader(body)).ReadAll()
+		if err != nil {
+			return m, err
+		}
+		if len(rows) < 2 {
+			return m, fmt.Errorf("the matrix needs a header row and a row per station")
+		}
+		for _, name := range rows[0][1:] {
+			m.Stations = append(m.Stations, strings.TrimSpace(name))
+		}
+		for _, row := range rows[1:] {
+			if len(m.Demand) == len(m.Stations) {
+				return m, fmt.Errorf("the matrix has more rows than stations")
+			}
+			if strings.TrimSpace(row[0]) != m.Stations[len(m.Demand)] {
+				return m, fmt.Errorf("row %q is not in the order of the header", row[0])
+			}
+			var demand []float64
+			for _, f := range row[1:] {
+				x, err := strconv.ParseFloat(strings.TrimSpace(f), 64)
+				if err != nil {
+					return m, fmt.Errorf("invalid demand %q from %s", f, row[0])
+				}
+				demand = append(demand, x)
+			}
+			m.Demand = append(m.Demand, demand)
+		}
+	}
+
+	if len(m.Demand) != len(m.Stations) {
+		return m, fmt.Errorf("the matrix has %d stations and %d rows", len(m.Stations), len(m.Demand))
+	}
+	for i, row := range m.Demand {
+		if len(row) != len(m.Stations) {
+			return m, fmt.Errorf("row %s has %d values, there are %d stations", m.Stations[i], len(row), len(m.Stations))
+		}
+		for _, x := range row {
+			if x < 0 || math.IsNaN(x) || math.IsInf(x, 0) {
+				return m, fmt.Errorf("invalid demand from %s", m.Stations[i])
+			}
+		}
+	}
+	return m, nil
+}
+
+// Demand on an edge in one direction, units a day
+type LinkFlow struct {
+	Edge         string  `json:"edge"`
+	From         string  `json:"from"`
+	To           string  `json:"to"`
+	Flow         float64 `json:"flow"`
+	PeakHourFlow float64 `json:"peak_hour_flow"`
+}
+
+type ODPath struct {
+	From   string   `json:"from"`
+	To     string   `json:"to"`
+	Demand float64  `json:"demand"`
+	Line   string   `json:"line"`  // the line the path lies on, empty when none does
+	Edges  []string `json:"edges"` // nil when To cannot be reached
+	Time   float64  `json:"time"`  // s
+}
+
+type LineFleet struct {
+	Line        string  `json:"line"` // empty for pods running across lines
+	Demand      float64 `json:"demand"`
+	Pods        int     `json:"pods"` // enough for the peak hour
+	AveragePods float64 `json:"average_pods"`
+}
+
+type Assignment struct {
+	Demand      float64     `json:"demand"` // units a day
+	Unreachable float64     `json:"unreachable"`
+	Links       []LinkFlow  `json:"links"`
+	PeakLink    LinkFlow    `json:"peak_link"` // its flow is the throughput to size the line for
+	Pairs       []ODPath    `json:"pairs"`
+	Lines       []LineFleet `json:"lines"`
+}
+
+// The network of a route's stations, one edge between each and one line over
+// them all. Ends with no station become stations called start and end
+func routeNetwork(route Route) (Network, error) {
+
+	nw := Network{ID: route.ID, Name: route.Name}
+	n := len(route.Segments)
+	if n < 2 {
+		return nw, fmt.Errorf("the route needs at least two vertices, it has %d", n)
+	}
+	if err := checkRoute(route); err != nil {
+		return nw, err
+	}
+
+	stops := []Station{{Name: "start", Berths: 1}}
+	if s, ok := route.stationAt(0); ok {
+		stops[0] = s
+	}
+	for _, s := range route.Stations {
+		if s.Vertex > 0 && s.Vertex < n-1 {
+			stops = append(stops, s)
+		}
+	}
+	sort.Slice(stops[1:], func(i, j int) bool { return stops[1+i].Vertex < stops[1+j].Vertex })
+	end := Station{Name: "end", Vertex: n - 1, Berths: 1}
+	if s, ok := route.stationAt(n - 1); ok {
+		end = s
+	}
+	stops = append(stops, end)
+
+	line := NetworkLine{Name: route.Name}
+	if line.Name == "" {
+		line.Name = "route"
+	}
+	for i, s := range stops {
+		seg := route.Segments[s.Vertex]
+		nw.Nodes = append(nw.Nodes, NetworkNode{ID: s.Name, Kind: nodeStation, Name: s.Name, Lat: seg.Lat, Lng: seg.Lng,
+			Dwell: s.Dwell, Berths: s.Berths, Cost: s.Cost})
+		if i == 0 {
+			continue
+		}
+		prev := stops[i-1]
+		segs := append([]Segment(nil), route.Segments[prev.Vertex:s.Vertex+1]...)
+		id := prev.Name + "-" + s.Name
+		nw.Edges = append(nw.Edges, NetworkEdge{ID: id, From: prev.Name, To: s.Name, Segments: segs})
+		line.Edges = append(line.Edges, id)
+	}
+	nw.Lines = []NetworkLine{line}
+	return nw, nil
+}
+
+// Whether the edges follow on along the line, either way
+func lineServes(l NetworkLine, edges []string) bool {
+
+	fits := func(line []string) bool {
+		for i := 0; i+len(edges) <= len(line); i++ {
+			k := 0
+			for k < len(edges) && line[i+k] == edges[k] {
+				k++
+			}
+			if k == len(edges) {
+				return true
+			}
+		}
+		return false
+	}
+
+	rev := make([]string, len(l.Edges))
+	for i, id := range l.Edges {
+		rev[len(l.Edges)-1-i] = id
+	}
+	return fits(l.Edges) || fits(rev)
+}
+
+// Puts the demand of each pair on its fastest path, all or nothing, and sizes
+// the fleet of each line for the pairs it serves. A pod takes its load from
+// origin to destination and comes back empty
+func assignDemand(nw Network, m ODMatrix, params EvalParams, geo Geodesic) (Assignment, error) {
+
+	var a Assignment
+
+	for _, id := range m.Stations {
+		if n, ok := nw.node(id); !ok || n.Kind != nodeStation {
+			return a, fmt.Errorf("%q is not a station", id)
+		}
+	}
+
+	peakShare := 1.0 / 24
+	if len(params.Demand) > 0 {
+		peakShare = params.Demand[params.Demand.Peak()]
+	}
+	loading := func(id string) float64 {
+		if n, _ := nw.node(id); n.Dwell > 0 {
+			return n.Dwell / 60
+		}
+		return params.LoadingTime
+	}
+
+	hops := makeHops(nw, params.Pod, geo)
+	flows := make(map[int]float64)
+	podMinutes := make(map[string]float64) // per line, pod minutes a day
+	demand := make(map[string]float64)
+
+	for i, from := range m.Stations {
+		for j, to := range m.Stations {
+			d := m.Demand[i][j]
+			if i == j || d == 0 {
+				continue
+			}
+			a.Demand += d
+
+			pair := ODPath{From: from, To: to, Demand: d}
+			path := findNetworkPath(nw, hops, from, to, params.Pod, geo, true)
+			if path == nil {
+				a.Unreachable += d
+				a.Pairs = append(a.Pairs, pair)
+				continue
+			}
+			p := networkPath(nw, hops, path, params, geo)
+			pair.Edges, pair.Time = p.Edges, p.Time
+			for _, l := range nw.Lines {
+				if lineServes(l, p.Edges) {
+					pair.Line = l.Name
+					break
+				}
+			}
+			a.Pairs = append(a.Pairs, pair)
+
+			for _, h := range path {
+				flows[h] += d
+			}
+			roundTrip := 2*p.Time/60 + loading(from) + loading(to)
+			podMinutes[pair.Line] += roundTrip * params.Service.podTrips(d, params.Pod)
+			demand[pair.Line] += d
+		}
+	}
+
+	for i, h := range hops {
+		if flows[i] == 0 {
+			continue
+		}
+		link := LinkFlow{h.edge.ID, h.from, h.to, flows[i], flows[i] * peakShare}
+		a.Links = append(a.Links, link)
+		if link.Flow > a.PeakLink.Flow {
+			a.PeakLink = link
+		}
+	}
+
+	lines := []string{}
+	for _, l := range nw.Lines {
+		lines = append(lines, l.Name)
+	}
+	if _, ok := demand[""]; ok {
+		lines = append(lines, "")
+	}
+	for _, name := range lines {
+		a.Lines = append(a.Lines, LineFleet{
+			Line:        name,
+			Demand:      demand[name],
+			Pods:        int(math.Ceil(podMinutes[name] * peakShare / 60)),
+			AveragePods: podMinutes[name] / (24 * 60),
+		})
+	}
+	return a, nil
+}
+
+// POST /routes/{id}/assignment or /networks/{id}/assignment with an O-D
+// matrix as the body and the settings panel values as query parameters
+func assignmentHandler(w http.ResponseWriter, r *http.Request, nw Network) {
+
+	params, err := evalParamsFromQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	geo, err := parseGeodesic(params.Geodesic)
+	if err != nil {
+		geo = ellipsoidal
+	}
+
+	body, _ := ioutil.ReadAll(r.Body)
+	m, err := parseODMatrix(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	a, err := assignDemand(nw, m, params, geo)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	writeJSON(w, a)
+}
+
+func routeAssignmentHandler(w http.ResponseWriter, r *http.Request, route Route) {
+
+	nw, err := routeNetwork(route)
+	if err == nil {
+		err = checkNetwork(nw, ellipsoidal)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	assignmentHandler(w, r, nw)
+}
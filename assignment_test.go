@@ -0,0 +1,90 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestRouteNetworkChecksRoute(t *testing.T) {
+
+	tests := []struct {
+		name  string
+		route Route
+	}{
+		{"empty", Route{}},
+		{"one vertex", Route{Segments: []Segment{{Lat: 52, Lng: 4}}}},
+		{"station past the end", Route{Segments: []Segment{{Lat: 52, Lng: 4}, {Lat: 52.1, Lng: 4}},
+			Stations: []Station{{Name: "x", Vertex: 2, Berths: 1}}}},
+	}
+	for _, tt := range tests {
+		if _, err := routeNetwork(tt.route); err == nil {
+			t.Errorf("%s: no error", tt.name)
+		}
+	}
+}
+
+func TestParseODMatrix(t *testing.T) {
+
+	csv := "from,a,b\na,0,10\nb,5,0\n"
+	m, err := parseODMatrix([]byte(csv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Stations) != 2 || m.Demand[0][1] != 10 || m.Demand[1][0] != 5 {
+		t.Errorf("matrix %+v", m)
+	}
+
+	for _, bad := range []string{
+		"from,a,b\na,0,10\n",
+		"from,a,b\nb,0,10\na,5,0\n",
+		`{"stations": ["a"], "demand": [[-1]]}`,
+		"from,a,b\na,0,NaN\nb,5,0\n",
+		"from,a,b\na,0,10\nb,Inf,0\n",
+	} {
+		if _, err := parseODMatrix([]byte(bad)); err == nil {
+			t.Errorf("%q: no error", bad)
+		}
+	}
+}
+
+func TestAssignDemandOnRoute(t *testing.T) {
+
+	route := Route{
+		Name:     "line",
+		Segments: []Segment{{Lat: 52, Lng: 4}, {Lat: 52.1, Lng: 4.1}, {Lat: 52.2, Lng: 4.3}},
+		Stations: []Station{{Name: "mid", Vertex: 1, Dwell: 30, Berths: 1}},
+	}
+	nw, err := routeNetwork(route)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := checkNetwork(nw, ellipsoidal); err != nil {
+		t.Fatal(err)
+	}
+
+	m := ODMatrix{Stations: []string{"start", "mid", "end"}, Demand: [][]float64{{0, 10, 20}, {0, 0, 0}, {30, 0, 0}}}
+	a, err := assignDemand(nw, m, defaultEvalParams(), ellipsoidal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Demand != 60 || a.Unreachable != 0 {
+		t.Errorf("demand %v unreachable %v", a.Demand, a.Unreachable)
+	}
+	// start to mid carries 10 + 20, end to mid the 30 coming back
+	flows := map[string]float64{}
+	for _, l := range a.Links {
+		flows[l.From+">"+l.To] = l.Flow
+	}
+	want := map[string]float64{"start>mid": 30, "mid>end": 20, "end>mid": 30, "mid>start": 30}
+	for k, v := range want {
+		if math.Abs(flows[k]-v) > 1e-9 {
+			t.Errorf("flow %s %v, want %v", k, flows[k], v)
+		}
+	}
+	if a.PeakLink.Flow != 30 {
+		t.Errorf("peak link %+v", a.PeakLink)
+	}
+	if len(a.Lines) != 1 || a.Lines[0].Line != "line" || a.Lines[0].Pods < 1 {
+		t.Errorf("lines %+v", a.Lines)
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import "math"
+
+var maxCant float64 = 15.0 // degrees, the most a tube is banked, given or worked out
+
+// Cant that takes out all the lateral acceleration of a pod at speed v on
+// radius rad, up to maxCant
+func optimalCant(v float64, rad float64) float64 {
+	return math.Min(maxCant, radDeg(math.Atan(v*v/(gravity*rad))))
+}
+
+// Cant through the curve at vertex v, as given or, with AutoCant and none
+// given, the optimal one for the pod's top speed
+func (al *Alignment) CantAt(v int, pod Pod) float64 {
+	c := al.Corners[v]
+	if c.Rad == 0 {
+		return 0
+	}
+	if c.Cant == 0 && al.AutoCant {
+		return optimalCant(pod.MaxSpeed, c.Rad)
+	}
+	return c.Cant
+}
+
+// Fastest a pod can take radius rad banked at cant degrees, with the lateral
+// acceleration left over in the pod held to MaxCornerMss
+func cornerSpeed(rad float64, cant float64, pod Pod) float64 {
+	c := degRad(cant)
+	return math.Sqrt(rad * (pod.MaxCornerMss + gravity*math.Sin(c)) / math.Cos(c))
+}
+
+// Smallest radius a pod takes at speed, banked at cant degrees
+func minCurveRadius(speed float64, cant float64, pod Pod) float64 {
+	c := degRad(cant)
+	return speed * speed * math.Cos(c) / (pod.MaxCornerMss + gravity*math.Sin(c))
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestCornerSpeed(t *testing.T) {
+
+	pod := podPresets[1]
+	for _, cant := range []float64{0, 5, 12} {
+		for _, rad := range []float64{500, 5000, 20000} {
+			v := cornerSpeed(rad, cant, pod)
+			if got := minCurveRadius(v, cant, pod); math.Abs(got-rad) > 1e-6*rad {
+				t.Errorf("cant %v: %v m/s on %v m, but %v m is the smallest radius for it", cant, v, rad, got)
+			}
+		}
+	}
+	if flat := math.Sqrt(5000 * pod.MaxCornerMss); math.Abs(cornerSpeed(5000, 0, pod)-flat) > 1e-9 || cornerSpeed(5000, 8, pod) <= flat {
+		t.Errorf("%v m/s flat, %v m/s banked", cornerSpeed(5000, 0, pod), cornerSpeed(5000, 8, pod))
+	}
+}
+
+func TestOptimalCant(t *testing.T) {
+
+	// no lateral acceleration left in the pod at the speed the cant is for
+	v, rad := 60.0, 3000.0
+	c := degRad(optimalCant(v, rad))
+	if lateral := v*v/rad*math.Cos(c) - gravity*math.Sin(c); math.Abs(lateral) > 1e-9 {
+		t.Errorf("%v m/s2 left at the optimal cant", lateral)
+	}
+	if got := optimalCant(1000/3.6, 2000); got != maxCant {
+		t.Errorf("cant %v, want it held to %v", got, maxCant)
+	}
+
+	route := Route{Segments: []Segment{{Lat: 52, Lng: 4}, {Lat: 52.1, Lng: 4, Rad: 8000}, {Lat: 52.1, Lng: 4.15, Rad: 6000, Cant: 4}, {Lat: 52.2, Lng: 4.15}}}
+	pod := podPresets[1]
+	al := makeAlignment(route, ellipsoidal)
+	if al.CantAt(1, pod) != 0 || al.CantAt(2, pod) != 4 || al.CantAt(3, pod) != 0 {
+		t.Errorf("cants %v %v %v without auto cant", al.CantAt(1, pod), al.CantAt(2, pod), al.CantAt(3, pod))
+	}
+	flat := simulateSpeed(al, pod, nil)
+
+	route.AutoCant = true
+	al = makeAlignment(route, ellipsoidal)
+	if got, want := al.CantAt(1, pod), optimalCant(pod.MaxSpeed, 8000); got != want || al.CantAt(2, pod) != 4 {
+		t.Errorf("cants %v and %v with auto cant, want %v and 4", got, al.CantAt(2, pod), want)
+	}
+	if banked := simulateSpeed(al, pod, nil); banked.Time >= flat.Time {
+		t.Errorf("%v s with the curves banked, %v s without", banked.Time, flat.Time)
+	}
+}
+
+func TestCheckRouteCant(t *testing.T) {
+
+	for _, tt := range []struct {
+		cant float64
+		ok   bool
+	}{{0, true}, {4, true}, {maxCant, true}, {-1, false}, {maxCant + 0.5, false}, {45, false}} {
+		route := Route{Segments: []Segment{{Lat: 52, Lng: 4}, {Lat: 52.1, Lng: 4, Rad: 8000, Cant: tt.cant}, {Lat: 52.2, Lng: 4.1}}}
+		if err := checkRoute(route); (err == nil) != tt.ok {
+			t.Errorf("cant %v: error %v, want ok %v", tt.cant, err, tt.ok)
+		}
+	}
+}
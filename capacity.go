@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+var podLength float64 = 30.0     // m
+var headwayMargin float64 = 50.0 // m kept clear between pods on top of the braking distance
+var reactionTime float64 = 1.0   // s before the pod behind starts braking
+
+// Where the headway is longest, which sets the capacity of the line
+type Bottleneck struct {
+	Direction string  `json:"direction"` // "out" or "return", empty for a line with no route
+	Distance  float64 `json:"distance"`  // m from the start of that run
+	Speed     float64 `json:"speed"`     // m/s
+	Station   string  `json:"station,omitempty"`
+	Headway   float64 `json:"headway"` // s
+}
+
+type LineCapacity struct {
+	Bottleneck    Bottleneck `json:"bottleneck"`
+	PodsPerHour   float64    `json:"pods_per_hour"`
+	MaxThroughput float64    `json:"max_throughput"` // units a day at the service load factor
+	Demand        float64    `json:"demand"`
+	Unit          string     `json:"unit"`
+	Exceeded      bool       `json:"exceeded"`
+}
+
+// Shortest safe time between two pods passing a point at speed v, so the one
+// behind can stop short of the one in front should it stop dead
+func safeHeadway(v float64, pod Pod) float64 {
+	v = math.Max(v, 1)
+	return reactionTime + (podLength+headwayMargin+v*v/(2*pod.EmergencyMss))/v
+}
+
+// Headway at a stop: the dwell, then the time for the pod to pull clear
+func stopHeadway(dwell float64, pod Pod) float64 {
+	return reactionTime + dwell + math.Sqrt(2*(podLength+headwayMargin)/pod.MaxAccelMss)
+}
+
+// Finds the longest headway over the speed profiles of both directions
+func lineCapacity(pod Pod, service ServiceMode, demand float64, runs map[string]SpeedProfile) LineCapacity {
+
+	c := LineCapacity{Demand: demand, Unit: service.label()}
+
+	for dir, prof := range runs {
+		for _, s := range prof.Segments {
+			h := safeHeadway(s.Speed, pod)
+			if s.SpeedLimit == 0 {
+				h = stopHeadway(s.Time, pod)
+			}
+			if h > c.Bottleneck.Headway || (h == c.Bottleneck.Headway && dir < c.Bottleneck.Direction) {
+				c.Bottleneck = Bottleneck{dir, s.Distance, s.Speed, s.Station, h}
+			}
+		}
+	}
+
+	if c.Bottleneck.Headway > 0 {
+		c.PodsPerHour = 3600 / c.Bottleneck.Headway
+	}
+	c.MaxThroughput = c.PodsPerHour * 24 * service.perPod(pod)
+	c.Exceeded = demand > c.MaxThroughput
+	return c
+}
+
+// Capacity of a line run flat out at the pod's top speed, for when there is no
+// route to simulate
+func cruiseCapacity(pod Pod, service ServiceMode, demand float64) LineCapacity {
+	cruise := SpeedProfile{Segments: []SpeedSegment{{Speed: pod.MaxSpeed, SpeedLimit: pod.MaxSpeed}}}
+	return lineCapacity(pod, service, demand, map[string]SpeedProfile{"": cruise})
+}
+
+func (c LineCapacity) Warning() string {
+	if !c.Exceeded {
+		return ""
+	}
+	where := fmt.Sprintf("at %s km %s", formatNumber(c.Bottleneck.Distance/1000), c.Bottleneck.Direction)
+	if c.Bottleneck.Station != "" {
+		where = "at " + c.Bottleneck.Station
+	} else if c.Bottleneck.Direction == "" {
+		where = "at top speed"
+	}
+	return fmt.Sprintf("throughput of %s %s a day is more than the line can carry (%s), the %s s headway %s is the bottleneck",
+		formatNumber(c.Demand), c.Unit, formatNumber(math.Floor(c.MaxThroughput)), formatNumber(c.Bottleneck.Headway), where)
+}
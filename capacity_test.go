@@ -0,0 +1,61 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestSafeHeadway(t *testing.T) {
+
+	pod := podPresets[0]
+	v := 100.0
+	want := reactionTime + (podLength+headwayMargin)/v + v/(2*pod.EmergencyMss)
+	if got := safeHeadway(v, pod); math.Abs(got-want) > 1e-12 {
+		t.Errorf("headway %v at %v m/s, want %v", got, v, want)
+	}
+
+	// shortest where the gap and braking terms are equal
+	best := math.Sqrt(2 * pod.EmergencyMss * (podLength + headwayMargin))
+	for _, v := range []float64{best / 2, best * 2} {
+		if safeHeadway(v, pod) <= safeHeadway(best, pod) {
+			t.Errorf("headway at %v m/s is no longer than at %v m/s", v, best)
+		}
+	}
+	if h := safeHeadway(0, pod); math.IsInf(h, 0) || math.IsNaN(h) {
+		t.Errorf("headway %v for a pod at rest", h)
+	}
+
+	if got := stopHeadway(30, pod); got <= 30+reactionTime {
+		t.Errorf("stop headway %v with 30 s dwell", got)
+	}
+}
+
+func TestLineCapacity(t *testing.T) {
+
+	pod := podPresets[0]
+	service := defaultServiceMode()
+	out := SpeedProfile{Segments: []SpeedSegment{
+		{Distance: 1000, Speed: 50, SpeedLimit: 100},
+		{Distance: 2000, Speed: 0, SpeedLimit: 0, Time: 60, Station: "Mid"},
+		{Distance: 3000, Speed: 130, SpeedLimit: 130},
+	}}
+	back := SpeedProfile{Segments: []SpeedSegment{{Distance: 500, Speed: 120, SpeedLimit: 120}}}
+
+	c := lineCapacity(pod, service, 1000, map[string]SpeedProfile{"out": out, "return": back})
+	want := stopHeadway(60, pod)
+	if c.Bottleneck.Station != "Mid" || c.Bottleneck.Direction != "out" || c.Bottleneck.Headway != want {
+		t.Errorf("bottleneck %+v, want the stop at Mid with %v s", c.Bottleneck, want)
+	}
+	if math.Abs(c.MaxThroughput-3600/want*24*service.perPod(pod)) > 1e-9 || c.Exceeded {
+		t.Errorf("max throughput %v, exceeded %v", c.MaxThroughput, c.Exceeded)
+	}
+	if c.Warning() != "" {
+		t.Errorf("warning %q under capacity", c.Warning())
+	}
+
+	c = cruiseCapacity(pod, service, 1e6)
+	if !c.Exceeded || !strings.Contains(c.Warning(), "at top speed") {
+		t.Errorf("cruise capacity %v, warning %q", c.MaxThroughput, c.Warning())
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import "math"
+
+var designLateralMss float64 = gravity * 0.5 // m/s2 through the arc when clothoids are sized by jerk
+
+// Clothoid long enough that the lateral acceleration builds up at no more than
+// jerk, for a pod taking the arc at designLateralMss
+func jerkSpiral(rad float64, jerk float64) float64 {
+	v := math.Sqrt(rad * designLateralMss)
+	return v * v * v / (jerk * rad)
+}
+
+// Offsets of the point u along a clothoid of length ls that ends at radius rad,
+// along and square to the tangent at its start (series to the fifth order)
+func clothoidXY(rad float64, ls float64, u float64) (float64, float64) {
+	if u == 0 {
+		return 0, 0
+	}
+	t := u * u / (2 * rad * ls)
+	x := u * (1 - t*t/10 + math.Pow(t, 4)/216)
+	y := u * (t/3 - math.Pow(t, 3)/42 + math.Pow(t, 5)/1320)
+	return x, y
+}
+
+// How far a clothoid pushes the arc in from the line, and how far along the line
+// from its start the arc centre is
+func clothoidShift(rad float64, ls float64) (float64, float64) {
+	if ls == 0 {
+		return 0, 0
+	}
+	t := ls / (2 * rad)
+	x, y := clothoidXY(rad, ls, ls)
+	return y - rad*(1-math.Cos(t)), x - rad*math.Sin(t)
+}
+
+// Distance from the vertex to where the clothoid leaves the line, for a turn
+// of delta radians
+func clothoidTangent(rad float64, ls float64, delta float64) float64 {
+	p, k := clothoidShift(rad, ls)
+	return (rad+p)*math.Tan(delta/2) + k
+}
+
+// Point u along a clothoid that starts at origin heading along heading and
+// turns clockwise when side is 1, anticlockwise when -1
+func spiralPoint(geo Geodesic, origin LatLng, heading float64, side float64, rad float64, ls float64, u float64) LatLng {
+	x, y := clothoidXY(rad, ls, u)
+	if x == 0 && y == 0 {
+		return origin
+	}
+	return geo.Offset(origin, math.Hypot(x, y), heading+side*radDeg(math.Atan2(y, x)))
+}
+
+// Where the tangents at the two ends of a clothoid meet, from its straight end
+func spiralLongTangent(rad float64, ls float64) float64 {
+	x, y := clothoidXY(rad, ls, ls)
+	return x - y/math.Tan(ls/(2*rad))
+}
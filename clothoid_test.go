@@ -0,0 +1,80 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// The clothoid end point by integrating its heading, Simpson's rule
+func integrateClothoid(rad float64, ls float64) (float64, float64) {
+	const n = 10000
+	h := ls / n
+	x, y := 0.0, 0.0
+	for i := 0; i <= n; i++ {
+		s := float64(i) * h
+		w := 2.0
+		if i == 0 || i == n {
+			w = 1
+		} else if i%2 == 1 {
+			w = 4
+		}
+		theta := s * s / (2 * rad * ls)
+		x += w * math.Cos(theta)
+		y += w * math.Sin(theta)
+	}
+	return x * h / 3, y * h / 3
+}
+
+func TestClothoidEndPoint(t *testing.T) {
+
+	// the series holds while the clothoid turns through a fraction of a radian
+	for _, c := range []struct{ rad, ls float64 }{{1000, 200}, {2500, 400}, {400, 240}, {5000, 100}} {
+		x, y := clothoidXY(c.rad, c.ls, c.ls)
+		wx, wy := integrateClothoid(c.rad, c.ls)
+		if math.Abs(x-wx) > 1e-6*c.ls || math.Abs(y-wy) > 1e-6*c.ls {
+			t.Errorf("radius %v length %v: end at %v, %v, want %v, %v", c.rad, c.ls, x, y, wx, wy)
+		}
+	}
+	if got := jerkSpiral(1000, 0.5); math.Abs(got-math.Pow(1000*designLateralMss, 1.5)/500) > 1e-9 {
+		t.Errorf("jerk spiral %v m", got)
+	}
+}
+
+func TestAlignmentClothoids(t *testing.T) {
+
+	route := Route{Segments: []Segment{{Lat: 52, Lng: 4}, {Lat: 52.1, Lng: 4, Rad: 2000, Spiral: 300}, {Lat: 52.1, Lng: 4.15}}}
+	geo := ellipsoidal
+	al := makeAlignment(route, geo)
+	cnr := al.Corners[1]
+
+	kinds := ""
+	total := 0.0
+	for _, e := range al.Elements {
+		kinds += e.Kind[:1]
+		total += e.Length
+	}
+	if kinds != "lsasl" {
+		t.Fatalf("elements %s, want line, spiral, arc, spiral, line", kinds)
+	}
+	if math.Abs(total-al.Length) > 1e-6 {
+		t.Errorf("elements add up to %v m, the alignment is %v m", total, al.Length)
+	}
+
+	spiral, arc := al.Elements[1], al.Elements[2]
+	if spiral.Length != 300 || cnr.Spiral != 300 || cnr.Rad != 2000 {
+		t.Errorf("spiral of %v m on radius %v", spiral.Length, cnr.Rad)
+	}
+	// the spirals turn through ls/2R each and the arc the rest
+	if want := cnr.AngChange - 2*radDeg(300.0/4000); math.Abs(arc.Angle-want) > 1e-9 {
+		t.Errorf("arc turns %v degrees, want %v", arc.Angle, want)
+	}
+	// the arc is shifted in so it starts and ends on its circle
+	for _, p := range []LatLng{cnr.SC, cnr.CS} {
+		if d := geo.Distance(cnr.ArcCtre, p); math.Abs(d-2000) > 0.01 {
+			t.Errorf("arc point %v m from the centre, want 2000", d)
+		}
+	}
+	if d := geo.Distance(cnr.Pt, cnr.Tan1); math.Abs(d-clothoidTangent(2000, 300, degRad(math.Abs(cnr.AngChange)))) > 1e-6 {
+		t.Errorf("tangent %v m", d)
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// What passengers put up with, accelerations as felt in the pod
+type ComfortLimits struct {
+	Longitudinal float64 `json:"longitudinal"` // m/s2
+	Lateral      float64 `json:"lateral"`      // m/s2 left over after the cant
+	Vertical     float64 `json:"vertical"`     // m/s2 on top of gravity
+	Jerk         float64 `json:"jerk"`         // m/s3
+	Index        float64 `json:"index"`        // m/s2, see comfortIndex
+}
+
+func defaultComfortLimits() ComfortLimits {
+	return ComfortLimits{
+		Longitudinal: gravity * 0.15,
+		Lateral:      gravity * 0.1,
+		Vertical:     gravity * 0.05,
+		Jerk:         1.0,
+		Index:        0.63,
+	}
+}
+
+// Upper ends of the comfort reactions in ISO 2631-1 annex C, m/s2
+var comfortRatings = []struct {
+	upTo   float64
+	rating string
+}{
+	{0.315, "not uncomfortable"},
+	{0.63, "a little uncomfortable"},
+	{1.0, "fairly uncomfortable"},
+	{1.6, "uncomfortable"},
+	{2.5, "very uncomfortable"},
+	{math.Inf(1), "extremely uncomfortable"},
+}
+
+func comfortRating(index float64) string {
+	for _, r := range comfortRatings {
+		if index <= r.upTo {
+			return r.rating
+		}
+	}
+	return ""
+}
+
+// The axes summed as in ISO 2631-1 with the factors of 1 it gives for comfort.
+// The profile is too coarse for the frequency weighting, so this rates the
+// steady accelerations only and leaves out ride vibration
+func comfortIndex(long float64, lat float64, vert float64) float64 {
+	return math.Sqrt(long*long + lat*lat + vert*vert)
+}
+
+// Comfort over one speed segment
+type ComfortSample struct {
+	Distance     float64  `json:"distance"` // m, at the end of the segment
+	Time         float64  `json:"time"`     // s from the start
+	Speed        float64  `json:"speed"`    // m/s
+	Longitudinal float64  `json:"longitudinal"`
+	Lateral      float64  `json:"lateral"`
+	Vertical     float64  `json:"vertical"`
+	Jerk         float64  `json:"jerk"`
+	Index        float64  `json:"index"`
+	Exceeded     []string `json:"exceeded,omitempty"` // limits the segment is over
+}
+
+type ComfortSummary struct {
+	MaxLongitudinal float64            `json:"max_longitudinal"`
+	MaxLateral      float64            `json:"max_lateral"`
+	MaxVertical     float64            `json:"max_vertical"`
+	MaxJerk         float64            `json:"max_jerk"`
+	MaxIndex        float64            `json:"max_index"`
+	Index           float64            `json:"index"` // rms over the time on the move
+	Rating          string             `json:"rating"`
+	Flagged         int                `json:"flagged"`  // segments over a limit
+	Exceeded        map[string]float64 `json:"exceeded"` // m of route over each limit
+}
+
+type ComfortAnalysis struct {
+	Samples []ComfortSample `json:"samples"`
+	Summary ComfortSummary  `json:"summary"`
+}
+
+type RouteComfort struct {
+	ID     int             `json:"id,omitempty"`
+	Route  string          `json:"route"`
+	Limits ComfortLimits   `json:"limits"`
+	Out    ComfortAnalysis `json:"out"`
+	Return ComfortAnalysis `json:"return"`
+}
+
+// Accelerations along a simulated run. The longitudinal one is taken from the
+// speeds at the ends of each segment, the lateral and vertical ones at the
+// faster end, and jerk from the change between segments. Dwells at stops count
+// neither for jerk nor for the index
+func analyseComfort(prof SpeedProfile, limits ComfortLimits) ComfortAnalysis {
+
+	var ca ComfortAnalysis
+	s := &ca.Summary
+	s.Exceeded = make(map[string]float64)
+
+	var prevSpeed, prevTime float64
+	var prev [3]float64
+	var sumSq, moving float64
+
+	for _, seg := range prof.Segments {
+		vs, ve := prevSpeed, seg.Speed
+		v := math.Max(vs, ve)
+		dt := 0.0
+		if vs+ve > 0 {
+			dt = 2 * seg.Length / (vs + ve)
+		}
+
+		var acc [3]float64
+		if seg.Length > 0 {
+			acc[0] = (ve*ve - vs*vs) / (2 * seg.Length)
+		}
+		if seg.Radius > 0 {
+			c := degRad(seg.Cant)
+			centri := v * v / seg.Radius
+			acc[1] = centri*math.Cos(c) - gravity*math.Sin(c)
+			acc[2] = centri*math.Sin(c) + gravity*(math.Cos(c)-1)
+		}
+		if seg.VertRadius > 0 {
+			acc[2] += v * v / seg.VertRadius
+		}
+
+		sample := ComfortSample{
+			Distance:     seg.Distance,
+			Time:         seg.RouteTime,
+			Speed:        ve,
+			Longitudinal: math.Abs(acc[0]),
+			Lateral:      math.Abs(acc[1]),
+			Vertical:     math.Abs(acc[2]),
+			Index:        comfortIndex(acc[0], acc[1], acc[2]),
+		}
+		if span := (dt + prevTime) / 2; span > 0 {
+			sample.Jerk = comfortIndex(acc[0]-prev[0], acc[1]-prev[1], acc[2]-prev[2]) / span
+		}
+
+		for _, l := range []struct {
+			name         string
+			value, limit float64
+		}{
+			{"longitudinal", sample.Longitudinal, limits.Longitudinal},
+			{"lateral", sample.Lateral, limits.Lateral},
+			{"vertical", sample.Vertical, limits.Vertical},
+			{"jerk", sample.Jerk, limits.Jerk},
+			{"index", sample.Index, limits.Index},
+		} {
+			if l.value > l.limit {
+				sample.Exceeded = append(sample.Exceeded, l.name)
+				s.Exceeded[l.name] += seg.Length
+			}
+		}
+		if len(sample.Exceeded) > 0 {
+			s.Flagged++
+		}
+
+		s.MaxLongitudinal = math.Max(s.MaxLongitudinal, sample.Longitudinal)
+		s.MaxLateral = math.Max(s.MaxLateral, sample.Lateral)
+		s.MaxVertical = math.Max(s.MaxVertical, sample.Vertical)
+		s.MaxJerk = math.Max(s.MaxJerk, sample.Jerk)
+		s.MaxIndex = math.Max(s.MaxIndex, sample.Index)
+		sumSq += sample.Index * sample.Index * dt
+		moving += dt
+
+		ca.Samples = append(ca.Samples, sample)
+		prevSpeed, prevTime, prev = ve, dt, acc
+		if seg.SpeedLimit == 0 {
+			// at rest through the stop
+			prevTime, prev = 0, [3]float64{}
+		}
+	}
+
+	if moving > 0 {
+		s.Index = math.Sqrt(sumSq / moving)
+	}
+	s.Rating = comfortRating(s.Index)
+	return ca
+}
+
+// Reads the limits as comfort_longitudinal, comfort_lateral and comfort_vertical
+// in g like the other accelerations in the settings panel, comfort_jerk in m/s3
+// and comfort_index in m/s2
+func comfortLimitsFromQuery(q url.Values) (ComfortLimits, error) {
+
+	l := defaultComfortLimits()
+
+	for _, f := range []struct {
+		name  string
+		scale float64
+		dst   *float64
+	}{
+		{"comfort_longitudinal", gravity, &l.Longitudinal},
+		{"comfort_lateral", gravity, &l.Lateral},
+		{"comfort_vertical", gravity, &l.Vertical},
+		{"comfort_jerk", 1, &l.Jerk},
+		{"comfort_index", 1, &l.Index},
+	} {
+		if v := q.Get(f.name); v != "" {
+			x, err := strconv.ParseFloat(v, 64)
+			if err != nil || x <= 0 || math.IsNaN(x) || math.IsInf(x, 0) {
+				return l, fmt.Errorf("invalid %s %q", f.name, v)
+			}
+			*f.dst = x * f.scale
+		}
+	}
+	return l, nil
+}
+
+// GET /routes/{id}/comfort with the settings panel values and the comfort
+// limits as query parameters
+func comfortHandler(w http.ResponseWriter, r *http.Request, route Route) {
+
+	params, err := evalParamsFromQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	limits, err := comfortLimitsFromQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ev := evaluateRoute(route, params)
+	writeJSON(w, RouteComfort{
+		ID:     route.ID,
+		Route:  route.Name,
+		Limits: limits,
+		Out:    analyseComfort(ev.Speed, limits),
+		Return: analyseComfort(ev.Return, limits),
+	})
+}
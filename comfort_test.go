@@ -0,0 +1,84 @@
+package main
+
+import (
+	"math"
+	"net/url"
+	"testing"
+)
+
+func TestComfortRating(t *testing.T) {
+
+	tests := []struct {
+		index  float64
+		rating string
+	}{
+		{0, "not uncomfortable"},
+		{0.315, "not uncomfortable"},
+		{0.4, "a little uncomfortable"},
+		{0.63, "a little uncomfortable"},
+		{0.8, "fairly uncomfortable"},
+		{1.2, "uncomfortable"},
+		{2, "very uncomfortable"},
+		{2.5, "very uncomfortable"},
+		{9, "extremely uncomfortable"},
+	}
+	for _, tt := range tests {
+		if got := comfortRating(tt.index); got != tt.rating {
+			t.Errorf("%v m/s2 rated %q, want %q", tt.index, got, tt.rating)
+		}
+	}
+	if got := comfortIndex(0.3, 0.4, 1.2); math.Abs(got-1.3) > 1e-12 {
+		t.Errorf("index %v, want 1.3", got)
+	}
+}
+
+func TestAnalyseComfort(t *testing.T) {
+
+	limits := defaultComfortLimits()
+	v := 50.0
+	cant := radDeg(math.Atan(v * v / (gravity * 1000))) // balanced for v on 1000 m
+	prof := SpeedProfile{Segments: []SpeedSegment{
+		{Distance: 500, Length: 500, Radius: -1, SpeedLimit: 100, Speed: v},                    // from rest to v
+		{Distance: 1000, Length: 500, Radius: 1000, Cant: cant, SpeedLimit: 100, Speed: v},     // balanced curve
+		{Distance: 1500, Length: 500, Radius: 1000, SpeedLimit: 100, Speed: v},                 // flat curve
+		{Distance: 2000, Length: 500, Radius: -1, VertRadius: 5000, SpeedLimit: 100, Speed: v}, // sag
+	}}
+	ca := analyseComfort(prof, limits)
+	s := ca.Samples
+
+	if want := v * v / 1000; math.Abs(s[0].Longitudinal-want) > 1e-9 {
+		t.Errorf("longitudinal %v, want %v", s[0].Longitudinal, want)
+	}
+	if s[1].Lateral > 1e-9 || s[1].Longitudinal != 0 {
+		t.Errorf("lateral %v and longitudinal %v through the balanced curve", s[1].Lateral, s[1].Longitudinal)
+	}
+	if want := v * v / 1000; math.Abs(s[2].Lateral-want) > 1e-9 {
+		t.Errorf("lateral %v on the flat curve, want %v", s[2].Lateral, want)
+	}
+	if want := v * v / 5000; math.Abs(s[3].Vertical-want) > 1e-9 {
+		t.Errorf("vertical %v through the sag, want %v", s[3].Vertical, want)
+	}
+
+	// 2.5 m/s2 on the flat curve is over the 0.98 m/s2 lateral limit
+	if ca.Summary.Flagged == 0 || ca.Summary.Exceeded["lateral"] != 500 {
+		t.Errorf("%d segments flagged, %v m over the lateral limit", ca.Summary.Flagged, ca.Summary.Exceeded["lateral"])
+	}
+	if ca.Summary.MaxLateral != s[2].Lateral || ca.Summary.Rating != comfortRating(ca.Summary.Index) {
+		t.Errorf("summary %+v", ca.Summary)
+	}
+}
+
+func TestComfortLimitsFromQuery(t *testing.T) {
+
+	q, _ := url.ParseQuery("comfort_lateral=0.2&comfort_jerk=0.5")
+	l, err := comfortLimitsFromQuery(q)
+	if err != nil || l.Lateral != 0.2*gravity || l.Jerk != 0.5 || l.Longitudinal != defaultComfortLimits().Longitudinal {
+		t.Errorf("limits %+v %v", l, err)
+	}
+	for _, bad := range []string{"comfort_index=0", "comfort_vertical=-1", "comfort_longitudinal=lots", "comfort_index=NaN", "comfort_lateral=Inf", "comfort_jerk=-Inf"} {
+		q, _ := url.ParseQuery(bad)
+		if _, err := comfortLimitsFromQuery(q); err == nil {
+			t.Errorf("%s accepted", bad)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+const (
+	constructElevated = "elevated"
+	constructAtGrade  = "at_grade"
+	constructCutCover = "cut_and_cover"
+	constructBored    = "bored_tunnel"
+	constructBridge   = "bridge"
+)
+
+// Construction types in the order they are listed
+var constructionTypes = []string{constructElevated, constructAtGrade, constructCutCover, constructBored, constructBridge}
+
+// Civil works per metre, on top of the tube itself. Elevated stretches are
+// costed by their pylons instead
+var constructionCosts = map[string]float64{
+	constructElevated: 0,
+	constructAtGrade:  3500,
+	constructCutCover: 45000,
+	constructBored:    95000,
+	constructBridge:   38000,
+}
+
+var constructionLabels = map[string]string{
+	constructElevated: "Elevated",
+	constructAtGrade:  "At grade",
+	constructCutCover: "Cut and cover tunnel",
+	constructBored:    "Bored tunnel",
+	constructBridge:   "Bridge",
+}
+
+func constructionLabel(kind string) string {
+	if l, ok := constructionLabels[kind]; ok {
+		return l
+	}
+	return kind
+}
+
+// A stretch of the route between two vertices. An empty Type is worked out
+// from the vertical profile: bored tunnel below ground, bridge where the pylons
+// would be too tall and elevated elsewhere
+type Leg struct {
+	Start float64 `json:"start"` // m from the start of the route
+	End   float64 `json:"end"`
+	Type  string  `json:"type"`
+}
+
+type ConstructionCost struct {
+	Type     string  `json:"type"`
+	Length   float64 `json:"length"` // m
+	CostPerM float64 `json:"cost_per_m"`
+	Cost     float64 `json:"cost"`
+}
+
+// The legs between the route vertices, split at the middle of each fillet
+func routeLegs(route Route, al Alignment) []Leg {
+
+	st := al.VertexStations()
+
+	var legs []Leg
+	for i := 0; i < len(st)-1; i++ {
+		legs = append(legs, Leg{st[i], st[i+1], route.Segments[i].Type})
+	}
+	return legs
+}
+
+func checkConstructionTypes(route Route) error {
+	for i, s := range route.Segments {
+		if _, ok := constructionCosts[s.Type]; s.Type != "" && !ok {
+			return fmt.Errorf("segment %d has unknown construction type %q", i, s.Type)
+		}
+	}
+	return nil
+}
+
+// Construction at a distance along the route and the height of the tube over
+// the ground there
+func constructionAt(d float64, legs []Leg, vp *VerticalProfile) (string, float64) {
+
+	h := minPylonHeight
+	if vp != nil && len(vp.PVIs) > 1 {
+		h = vp.HeightAt(d)
+	}
+
+	for _, l := range legs {
+		if d >= l.Start && d < l.End && l.Type != "" {
+			return l.Type, h
+		}
+	}
+
+	switch {
+	case h < 0:
+		return constructBored, h
+	case h > bridgePylonHeight:
+		return constructBridge, h
+	}
+	return constructElevated, h
+}
+
+// Fills in the pylons and civil works of the capex, working along the route a
+// pylon spacing at a time. Without a vertical profile every pylon is of minimum
+// height and costs pylonCost
+func calcCivilCapex(c *CapexBreakdown, length float64, legs []Leg, vp *VerticalProfile) {
+
+	terrain := vp != nil && len(vp.PVIs) > 1
+	if terrain {
+		c.PylonBands = make([]PylonBandCount, len(pylonCostBands))
+		for i, b := range pylonCostBands {
+			c.PylonBands[i] = PylonBandCount{MaxHeight: b.MaxHeight, Cost: b.Cost}
+		}
+	}
+
+	lengths := make(map[string]float64)
+	c.MinPylonHeight = math.Inf(1)
+	sum := 0.0
+	var bridge *Bridge
+
+	sites := int(math.Ceil(length / pylonSpacingM))
+	for i := 0; i < sites; i++ {
+		d := float64(i) * pylonSpacingM
+		kind, h := constructionAt(d, legs, vp)
+		lengths[kind] += math.Min(pylonSpacingM, length-d)
+
+		tall := kind == constructBridge || (kind == constructElevated && h > bridgePylonHeight)
+		if !tall {
+			bridge = nil
+		} else {
+			if bridge == nil {
+				c.Bridges = append(c.Bridges, Bridge{Start: d})
+				bridge = &c.Bridges[len(c.Bridges)-1]
+			}
+			bridge.End = math.Min(d+pylonSpacingM, length)
+			bridge.MaxHeight = math.Max(bridge.MaxHeight, h)
+		}
+
+		if kind != constructElevated {
+			continue
+		}
+
+		h = math.Max(h, minPylonHeight)
+		c.Pylons++
+		sum += h
+		c.MinPylonHeight = math.Min(c.MinPylonHeight, h)
+		c.MaxPylonHeight = math.Max(c.MaxPylonHeight, h)
+		if h > bridgePylonHeight {
+			c.BridgePylons++
+		}
+
+		if !terrain {
+			c.PylonCost += pylonCost
+			continue
+		}
+		band := pylonBand(h)
+		c.PylonBands[band].Count++
+		c.PylonBands[band].Total += pylonCostBands[band].Cost
+		c.PylonCost += pylonCostBands[band].Cost
+	}
+
+	if c.Pylons > 0 {
+		c.MeanPylonHeight = sum / float64(c.Pylons)
+	} else {
+		c.MinPylonHeight = 0
+	}
+
+	for _, t := range constructionTypes {
+		if lengths[t] == 0 {
+			continue
+		}
+		cc := ConstructionCost{t, lengths[t], constructionCosts[t], lengths[t] * constructionCosts[t]}
+		c.Construction = append(c.Construction, cc)
+		c.CivilCost += cc.Cost
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestRouteLegs(t *testing.T) {
+
+	route := Route{Segments: []Segment{{Lat: 52, Lng: 4, Type: constructAtGrade}, {Lat: 52.1, Lng: 4, Rad: 2000}, {Lat: 52.1, Lng: 4.15, Type: constructBored}}}
+	al := makeAlignment(route, ellipsoidal)
+	legs := routeLegs(route, al)
+
+	if len(legs) != 2 || legs[0].Start != 0 || legs[1].End != al.Length || legs[0].End != legs[1].Start {
+		t.Fatalf("legs %v over %v m", legs, al.Length)
+	}
+	if legs[0].Type != constructAtGrade || legs[1].Type != "" {
+		t.Errorf("leg types %q and %q", legs[0].Type, legs[1].Type)
+	}
+	// the legs meet in the middle of the curve
+	if mid := al.VertexStations()[1]; legs[0].End != mid {
+		t.Errorf("legs meet at %v m, the curve's middle is at %v m", legs[0].End, mid)
+	}
+
+	if err := checkConstructionTypes(route); err != nil {
+		t.Error(err)
+	}
+	route.Segments[1].Type = "viaduct"
+	if err := checkConstructionTypes(route); err == nil {
+		t.Error("no error for an unknown construction type")
+	}
+}
+
+func TestCivilCapex(t *testing.T) {
+
+	legs := []Leg{{0, 4000, constructAtGrade}, {4000, 10000, ""}}
+	c := calcCapexBreakdown(10000, legs, nil, nil)
+
+	lengths := make(map[string]float64)
+	total := 0.0
+	for _, cc := range c.Construction {
+		lengths[cc.Type] = cc.Length
+		total += cc.Length
+		if cc.Cost != cc.Length*constructionCosts[cc.Type] {
+			t.Errorf("%s: %v m costing %v", cc.Type, cc.Length, cc.Cost)
+		}
+	}
+	if lengths[constructAtGrade] != 4000 || lengths[constructElevated] != 6000 || total != 10000 {
+		t.Errorf("lengths %v", lengths)
+	}
+	if c.CivilCost != 4000*constructionCosts[constructAtGrade] {
+		t.Errorf("civil cost %v", c.CivilCost)
+	}
+	if c.Pylons != int(6000/pylonSpacingM) || c.PylonCost != pylonCost*float64(c.Pylons) {
+		t.Errorf("%d pylons costing %v on the elevated 6 km", c.Pylons, c.PylonCost)
+	}
+
+	// over the ridge the tube goes into tunnel, and the pylons are costed by height
+	vp := designVertical(testRidge(), defaultVerticalLimits())
+	c = calcCapexBreakdown(40000, nil, &vp, nil)
+	bored := 0.0
+	for _, cc := range c.Construction {
+		if cc.Type == constructBored {
+			bored = cc.Length
+		}
+	}
+	// the tunnel ends are found to the ground sample, the construction to the pylon
+	if math.Abs(bored-vp.TunnelLength) > 2*(vp.ground.Spacing+pylonSpacingM) {
+		t.Errorf("%v m of bored tunnel, the grade line has %v m", bored, vp.TunnelLength)
+	}
+	counted := 0
+	for _, b := range c.PylonBands {
+		counted += b.Count
+	}
+	if counted != c.Pylons || c.MaxPylonHeight < c.MinPylonHeight || c.MinPylonHeight < minPylonHeight {
+		t.Errorf("%d pylons in the bands of %d, heights %v to %v", counted, c.Pylons, c.MinPylonHeight, c.MaxPylonHeight)
+	}
+}
@@ -0,0 +1,426 @@
+package main
+
+import (
+	"container/heap"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+)
+
+// Largest raster the search takes on
+const maxRasterCells = 4000000
+
+// Relative cost of building through a patch of ground, laid out like an image
+// over a box of latitude and longitude
+type CostRaster struct {
+	South float64     `json:"south"`
+	West  float64     `json:"west"`
+	North float64     `json:"north"`
+	East  float64     `json:"east"`
+	Costs [][]float64 `json:"costs"` // rows from north to south, cost per m, negative where the route may not go
+}
+
+type AlignmentSearchRequest struct {
+	Start       LatLng     `json:"start"`
+	End         LatLng     `json:"end"`
+	MinRadius   float64    `json:"min_radius"` // m
+	Raster      CostRaster `json:"raster"`
+	NoGo        []NoGoZone `json:"nogo,omitempty"`         // marked in the raster as no-go
+	SlopeWeight float64    `json:"slope_weight,omitempty"` // cost added per unit of ground slope from the DEM
+	Tolerance   float64    `json:"tolerance,omitempty"`    // m the straights may stray from the corridor, two cells when 0
+}
+
+type AlignmentSearch struct {
+	Route    Route    `json:"route"`
+	Corridor []LatLng `json:"corridor"` // centres of the cells the search went through
+	Cost     float64  `json:"cost"`     // of the corridor, cell costs times the m through them
+	Length   float64  `json:"length"`   // m of the fitted route
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// The raster ready for the search, with cell sizes in m and no-go cells marked
+type costGrid struct {
+	CostRaster
+	rows, cols   int
+	cellH, cellW float64 // m
+	blocked      []bool
+	minCost      float64
+	proj         Projection
+	geo          Geodesic
+
+	// ground heights of the cells, sampled as the search reaches them
+	slopeWeight   float64
+	noElevation   bool
+	elevation     []float64
+	elevationDone []bool
+}
+
+func (r CostRaster) check() error {
+
+	if r.North <= r.South || r.East <= r.West {
+		return fmt.Errorf("the raster needs north above south and east of west")
+	}
+	if len(r.Costs) == 0 || len(r.Costs[0]) == 0 {
+		return fmt.Errorf("the raster has no costs")
+	}
+	if len(r.Costs)*len(r.Costs[0]) > maxRasterCells {
+		return fmt.Errorf("the raster has more than %d cells", maxRasterCells)
+	}
+	for i, row := range r.Costs {
+		if len(row) != len(r.Costs[0]) {
+			return fmt.Errorf("raster row %d has %d cells, the first has %d", i, len(row), len(r.Costs[0]))
+		}
+	}
+	return nil
+}
+
+func newCostGrid(req AlignmentSearchRequest, geo Geodesic) *costGrid {
+
+	r := req.Raster
+	g := &costGrid{CostRaster: r, rows: len(r.Costs), cols: len(r.Costs[0]), geo: geo,
+		slopeWeight: req.SlopeWeight, minCost: math.Inf(1), proj: utmZoneFor(req.Start)}
+
+	midLat, midLng := (r.North+r.South)/2, (r.East+r.West)/2
+	g.cellH = geo.Distance(LatLng{r.North, midLng}, LatLng{r.South, midLng}) / float64(g.rows)
+	g.cellW = geo.Distance(LatLng{midLat, r.West}, LatLng{midLat, r.East}) / float64(g.cols)
+
+	n := g.rows * g.cols
+	g.blocked = make([]bool, n)
+	g.elevation = make([]float64, n)
+	g.elevationDone = make([]bool, n)
+	g.noElevation = dem == nil || g.slopeWeight <= 0
+	for i := 0; i < g.rows; i++ {
+		for j := 0; j < g.cols; j++ {
+			c := r.Costs[i][j]
+			if c < 0 || inNoGoZone(req.NoGo, g.proj, g.centre(i, j)) != nil {
+				g.blocked[i*g.cols+j] = true
+				continue
+			}
+			g.minCost = math.Min(g.minCost, c)
+		}
+	}
+	return g
+}
+
+func (g *costGrid) centre(i int, j int) LatLng {
+	return LatLng{
+		g.North - (float64(i)+0.5)*(g.North-g.South)/float64(g.rows),
+		g.West + (float64(j)+0.5)*(g.East-g.West)/float64(g.cols),
+	}
+}
+
+// The cell p falls in, false when it is off the raster
+func (g *costGrid) cell(p LatLng) (int, int, bool) {
+	i := int(math.Floor((g.North - p.Lat) / (g.North - g.South) * float64(g.rows)))
+	j := int(math.Floor((p.Lng - g.West) / (g.East - g.West) * float64(g.cols)))
+	return i, j, i >= 0 && i < g.rows && j >= 0 && j < g.cols
+}
+
+func (g *costGrid) blockedAt(p LatLng) bool {
+	i, j, ok := g.cell(p)
+	return !ok || g.blocked[i*g.cols+j]
+}
+
+// Ground height at the centre of cell k, sampled once. Cells the DEM does not
+// cover count as flat
+func (g *costGrid) height(k int) float64 {
+	if !g.elevationDone[k] {
+		g.elevationDone[k] = true
+		if z, err := dem.Elevation(g.centre(k/g.cols, k%g.cols)); err == nil {
+			g.elevation[k] = z
+		}
+	}
+	return g.elevation[k]
+}
+
+// Cost of going from cell a to the next cell b, dist m away
+func (g *costGrid) step(a int, b int, dist float64) float64 {
+	cost := dist * (g.Costs[a/g.cols][a%g.cols] + g.Costs[b/g.cols][b%g.cols]) / 2
+	if !g.noElevation {
+		cost *= 1 + g.slopeWeight*math.Abs(g.height(b)-g.height(a))/dist
+	}
+	return cost
+}
+
+type searchNode struct {
+	cell int
+	f    float64
+}
+
+type searchQueue []searchNode
+
+func (q searchQueue) Len() int            { return len(q) }
+func (q searchQueue) Less(i, j int) bool  { return q[i].f < q[j].f }
+func (q searchQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *searchQueue) Push(x interface{}) { *q = append(*q, x.(searchNode)) }
+func (q *searchQueue) Pop() interface{} {
+	old := *q
+	x := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return x
+}
+
+// A* from cell from to cell to over the 8 neighbours of each cell, with the
+// straight distance at the cheapest cell cost as the estimate. The cells in
+// order, nil when to cannot be reached
+func (g *costGrid) search(from int, to int) ([]int, float64) {
+
+	n := g.rows * g.cols
+	cost := make([]float64, n)
+	prev := make([]int, n)
+	done := make([]bool, n)
+	for k := range cost {
+		cost[k] = math.Inf(1)
+		prev[k] = -1
+	}
+
+	ti, tj := to/g.cols, to%g.cols
+	estimate := func(k int) float64 {
+		di, dj := float64(k/g.cols-ti)*g.cellH, float64(k%g.cols-tj)*g.cellW
+		return math.Sqrt(di*di+dj*dj) * g.minCost
+	}
+
+	q := &searchQueue{{from, estimate(from)}}
+	cost[from] = 0
+	for q.Len() > 0 {
+		k := heap.Pop(q).(searchNode).cell
+		if done[k] {
+			continue
+		}
+		if k == to {
+			var path []int
+			for c := to; c >= 0; c = prev[c] {
+				path = append([]int{c}, path...)
+			}
+			return path, cost[to]
+		}
+		done[k] = true
+
+		i, j := k/g.cols, k%g.cols
+		for di := -1; di <= 1; di++ {
+			for dj := -1; dj <= 1; dj++ {
+				ni, nj := i+di, j+dj
+				if (di == 0 && dj == 0) || ni < 0 || ni >= g.rows || nj < 0 || nj >= g.cols {
+					continue
+				}
+				nk := ni*g.cols + nj
+				if g.blocked[nk] || done[nk] {
+					continue
+				}
+				c := cost[k] + g.step(k, nk, math.Hypot(float64(di)*g.cellH, float64(dj)*g.cellW))
+				if c < cost[nk] {
+					cost[nk] = c
+					prev[nk] = k
+					heap.Push(q, searchNode{nk, c + estimate(nk)})
+				}
+			}
+		}
+	}
+	return nil, math.Inf(1)
+}
+
+// Whether the straight from a to b keeps off the no-go cells
+func (g *costGrid) clear(a LatLng, b LatLng) bool {
+	d, heading := g.geo.Distance(a, b), g.geo.Heading(a, b)
+	step := math.Min(g.cellH, g.cellW) / 2
+	for s := 0.0; s < d; s += step {
+		if g.blockedAt(g.geo.Offset(a, s, heading)) {
+			return false
+		}
+	}
+	return !g.blockedAt(b)
+}
+
+// Douglas-Peucker on the cell centres, with a straight only kept when it also
+// keeps off the no-go cells. Returns the indexes of the points kept
+func (g *costGrid) simplify(pts []LatLng, tolerance float64) []int {
+
+	xy := make([][2]float64, len(pts))
+	for k, p := range pts {
+		x, y := g.proj.Forward(p)
+		xy[k] = [2]float64{x, y}
+	}
+	offLine := func(k int, a int, b int) float64 {
+		dx, dy := xy[b][0]-xy[a][0], xy[b][1]-xy[a][1]
+		l := math.Hypot(dx, dy)
+		if l == 0 {
+			return math.Hypot(xy[k][0]-xy[a][0], xy[k][1]-xy[a][1])
+		}
+		return math.Abs(dx*(xy[a][1]-xy[k][1])-dy*(xy[a][0]-xy[k][0])) / l
+	}
+
+	keep := []int{0}
+	var fit func(a int, b int)
+	fit = func(a int, b int) {
+		far, worst := a, -1.0
+		for k := a + 1; k < b; k++ {
+			if d := offLine(k, a, b); d > worst {
+				far, worst = k, d
+			}
+		}
+		if b-a < 2 || (worst <= tolerance && g.clear(pts[a], pts[b])) {
+			keep = append(keep, b)
+			return
+		}
+		fit(a, far)
+		fit(far, b)
+	}
+	fit(0, len(pts)-1)
+	return keep
+}
+
+// Finds the cheapest corridor from start to end over the raster, fits straights
+// to it and puts a curve at each vertex. Curves are as large as the pod needs
+// for its top speed where the legs have room, and never under the minimum.
+// Vertices with no room for the minimum are dropped where the straight that
+// replaces them stays clear, and curves that cut into no-go cells are eased down
+// towards the minimum
+func searchAlignment(req AlignmentSearchRequest, pod Pod, geo Geodesic) (AlignmentSearch, error) {
+
+	var res AlignmentSearch
+
+	g := newCostGrid(req, geo)
+	si, sj, ok1 := g.cell(req.Start)
+	ei, ej, ok2 := g.cell(req.End)
+	if !ok1 || !ok2 {
+		return res, fmt.Errorf("start and end must be on the raster")
+	}
+	if geo.Distance(req.Start, req.End) < duplicateVertexM {
+		return res, fmt.Errorf("start and end are the same point")
+	}
+	from, to := si*g.cols+sj, ei*g.cols+ej
+	if g.blocked[from] || g.blocked[to] {
+		return res, fmt.Errorf("start and end may not be in no-go cells")
+	}
+
+	cells, cost := g.search(from, to)
+	if cells == nil {
+		return res, fmt.Errorf("no-go cells cut the start off from the end")
+	}
+	res.Cost = cost
+	for _, k := range cells {
+		res.Corridor = append(res.Corridor, g.centre(k/g.cols, k%g.cols))
+	}
+
+	// the ends are where they were asked for rather than at their cell centres,
+	// which may be one and the same
+	pts := []LatLng{req.Start}
+	if len(res.Corridor) > 2 {
+		pts = append(pts, res.Corridor[1:len(res.Corridor)-1]...)
+	}
+	pts = append(pts, req.End)
+	tolerance := req.Tolerance
+	if tolerance <= 0 {
+		tolerance = 2 * math.Max(g.cellH, g.cellW)
+	}
+
+	route := Route{Name: "search"}
+	for _, k := range g.simplify(pts, tolerance) {
+		route.Segments = append(route.Segments, Segment{Lat: pts[k].Lat, Lng: pts[k].Lng})
+	}
+
+	design := math.Max(req.MinRadius, minCurveRadius(pod.MaxSpeed, 0, pod))
+	setRadii := func() []float64 {
+		room := make([]float64, len(route.Segments))
+		for a := 1; a < len(route.Segments)-1; a++ {
+			route.Segments[a].Rad = design
+			room[a] = maxCornerRadius(route, a, geo)
+			route.Segments[a].Rad = math.Max(req.MinRadius, math.Min(design, room[a]))
+		}
+		return room
+	}
+
+	for {
+		room := setRadii()
+		drop := -1
+		for a := 1; a < len(route.Segments)-1; a++ {
+			if room[a] == 0 || room[a] >= req.MinRadius {
+				continue
+			}
+			prev, next := route.Segments[a-1].LatLng(), route.Segments[a+1].LatLng()
+			if (drop < 0 || room[a] < room[drop]) && g.clear(prev, next) {
+				drop = a
+			}
+		}
+		if drop < 0 {
+			for a := 1; a < len(route.Segments)-1; a++ {
+				if room[a] > 0 && room[a] < req.MinRadius {
+					res.Warnings = append(res.Warnings, fmt.Sprintf("vertex %d only has room for a radius of %.0f m", a, room[a]))
+				}
+			}
+			break
+		}
+		route.Segments = append(route.Segments[:drop], route.Segments[drop+1:]...)
+	}
+
+	// ease curves out of the no-go cells, halving the radius down to the minimum
+	for a := 1; a < len(route.Segments)-1; a++ {
+		for {
+			al := makeAlignment(route, geo)
+			inside := false
+			for _, e := range al.Elements {
+				if e.Vertex != a || e.Kind == "line" {
+					continue
+				}
+				for d := 0.0; d <= e.Length && !inside; d += math.Min(g.cellH, g.cellW) / 2 {
+					inside = g.blockedAt(e.PointAt(d))
+				}
+			}
+			if !inside {
+				break
+			}
+			if route.Segments[a].Rad <= req.MinRadius {
+				res.Warnings = append(res.Warnings, fmt.Sprintf("the curve at vertex %d cuts into no-go cells", a))
+				break
+			}
+			route.Segments[a].Rad = math.Max(req.MinRadius, route.Segments[a].Rad/2)
+		}
+	}
+
+	res.Route = route
+	res.Length = makeAlignment(route, geo).Length
+	return res, nil
+}
+
+// POST /searchroute with an AlignmentSearchRequest as the body and the settings
+// panel values as query parameters. The route found can be saved with /saveroute
+func searchRouteHandler(w http.ResponseWriter, r *http.Request) {
+
+	params, err := evalParamsFromQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	geo, err := parseGeodesic(params.Geodesic)
+	if err != nil {
+		geo = ellipsoidal
+	}
+
+	var req AlignmentSearchRequest
+	body, _ := ioutil.ReadAll(r.Body)
+	if err := json.Unmarshal(body, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := req.Raster.check(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := checkNoGoZones(req.NoGo); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.MinRadius < minRadius || req.SlopeWeight < 0 || req.Tolerance < 0 {
+		http.Error(w, fmt.Sprintf("min_radius must be at least %.0f m, slope_weight and tolerance may not be negative", minRadius), http.StatusBadRequest)
+		return
+	}
+
+	res, err := searchAlignment(req, params.Pod, geo)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	writeJSON(w, res)
+}
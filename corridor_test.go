@@ -0,0 +1,92 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSearchAlignmentSameCell(t *testing.T) {
+
+	req := AlignmentSearchRequest{
+		Start:     LatLng{Lat: 51.951, Lng: 4.41},
+		End:       LatLng{Lat: 51.959, Lng: 4.49},
+		MinRadius: 500,
+		Raster:    CostRaster{South: 51.9, West: 4, North: 52, East: 5, Costs: [][]float64{{1}}},
+	}
+	res, err := searchAlignment(req, podPresets[0], ellipsoidal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	segs := res.Route.Segments
+	if len(segs) != 2 || segs[0].LatLng() != req.Start || segs[1].LatLng() != req.End {
+		t.Errorf("route %+v, want the start and the end", segs)
+	}
+	if want := ellipsoidal.Distance(req.Start, req.End); res.Length < want-0.01 || res.Length > want+0.01 {
+		t.Errorf("length %.2f m, want %.2f m", res.Length, want)
+	}
+}
+
+// A 20 by 20 raster with a wall down the middle from the north edge, open
+// across the last rows unless closed is set
+func testWallRaster(closed bool) CostRaster {
+	r := CostRaster{South: 52, West: 4, North: 52.2, East: 4.4}
+	for i := 0; i < 20; i++ {
+		row := make([]float64, 20)
+		for j := range row {
+			row[j] = 1
+			if j == 10 && (i < 16 || closed) {
+				row[j] = -1
+			}
+		}
+		r.Costs = append(r.Costs, row)
+	}
+	return r
+}
+
+func TestSearchAlignmentAroundBarrier(t *testing.T) {
+
+	req := AlignmentSearchRequest{
+		Start:     LatLng{Lat: 52.175, Lng: 4.05},
+		End:       LatLng{Lat: 52.175, Lng: 4.35},
+		MinRadius: 200,
+		Raster:    testWallRaster(false),
+	}
+	res, err := searchAlignment(req, podPresets[4], ellipsoidal)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	segs := res.Route.Segments
+	if len(segs) < 3 || segs[0].LatLng() != req.Start || segs[len(segs)-1].LatLng() != req.End {
+		t.Fatalf("route %+v does not go from the start round to the end", segs)
+	}
+	// the gap is south of 52.04, so the corridor goes down to it
+	lowest := 90.0
+	for _, p := range res.Corridor {
+		lowest = math.Min(lowest, p.Lat)
+	}
+	if lowest > 52.04 {
+		t.Errorf("the corridor goes no further south than %v", lowest)
+	}
+
+	g := newCostGrid(req, ellipsoidal)
+	al := makeAlignment(res.Route, ellipsoidal)
+	if len(res.Warnings) > 0 {
+		t.Errorf("warnings %q", res.Warnings)
+	}
+	for _, e := range al.Elements {
+		for d := 0.0; d <= e.Length; d += 50 {
+			if g.blockedAt(e.PointAt(d)) {
+				t.Fatalf("the %s into vertex %d crosses the wall %v m along", e.Kind, e.Vertex, d)
+			}
+		}
+	}
+	if direct := ellipsoidal.Distance(req.Start, req.End); res.Length < direct*1.5 || res.Cost < direct*1.5 {
+		t.Errorf("length %v m and cost %v for a detour round a wall, %v m direct", res.Length, res.Cost, direct)
+	}
+
+	req.Raster = testWallRaster(true)
+	if _, err := searchAlignment(req, podPresets[4], ellipsoidal); err == nil {
+		t.Error("no error with the end walled off")
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Share of the day's demand in each hour from midnight
+type DemandProfile []float64
+
+// Named profiles, relative weights by hour
+var demandProfiles = map[string][]float64{
+	"uniform": {
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1,
+	},
+	// freight moved overnight to keep clear of the day's traffic
+	"freight_night_peak": {
+		7, 8, 8, 8, 7, 5, 3, 2, 2, 2, 2, 2,
+		2, 2, 2, 2, 2, 2, 3, 3, 4, 5, 6, 7,
+	},
+	// morning and evening rush
+	"commuter_double_peak": {
+		1, 0.5, 0.5, 0.5, 1, 3, 7, 10, 8, 5, 4, 4,
+		4, 4, 4, 5, 7, 10, 8, 5, 3, 2, 2, 1,
+	},
+}
+
+// A named profile or 24 comma separated hourly weights
+func parseDemandProfile(s string) (DemandProfile, error) {
+
+	if s == "" {
+		s = "uniform"
+	}
+	weights, ok := demandProfiles[s]
+	if !ok {
+		fields := strings.Split(s, ",")
+		if len(fields) != 24 {
+			names := make([]string, 0, len(demandProfiles))
+			for name := range demandProfiles {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			return nil, fmt.Errorf("demand profile must be one of %s or 24 hourly weights", strings.Join(names, ", "))
+		}
+		weights = make([]float64, 24)
+		for i, f := range fields {
+			x, err := strconv.ParseFloat(strings.TrimSpace(f), 64)
+			if err != nil || x < 0 || math.IsNaN(x) || math.IsInf(x, 0) {
+				return nil, fmt.Errorf("invalid hourly demand %q", f)
+			}
+			weights[i] = x
+		}
+	}
+
+	sum := 0.0
+	for _, w := range weights {
+		sum += w
+	}
+	if sum <= 0 {
+		return nil, fmt.Errorf("demand profile has no demand")
+	}
+	p := make(DemandProfile, 24)
+	for i, w := range weights {
+		p[i] = w / sum
+	}
+	return p, nil
+}
+
+// Hour with the most demand, the earliest on a tie
+func (p DemandProfile) Peak() int {
+	peak := 0
+	for h := range p {
+		if p[h] > p[peak] {
+			peak = h
+		}
+	}
+	return peak
+}
+
+// Fleet needed hour by hour
+type DemandFleet struct {
+	PeakHour            int     `json:"peak_hour"`
+	PeakPodTripsPerHour float64 `json:"peak_pod_trips_per_hour"`
+	PeakPods            int     `json:"peak_pods"`
+	AveragePods         float64 `json:"average_pods"` // over the day
+	IdlePods            float64 `json:"idle_pods"`    // of the peak fleet, averaged over the day
+	HourlyPods          []int   `json:"hourly_pods"`
+}
+
+// Sizes the fleet for every hour of the day as if that hour's rate went on all
+// day. Arguments are those of calcNumberOfPods, throughput in pod trips a day
+func demandFleet(profile DemandProfile, traveltime float64, throughput float64, loadingtime float64) DemandFleet {
+
+	f := DemandFleet{HourlyPods: make([]int, len(profile))}
+	sum := 0
+	for h, share := range profile {
+		f.HourlyPods[h] = calcNumberOfPods(traveltime, throughput*share*float64(len(profile)), loadingtime)
+		sum += f.HourlyPods[h]
+	}
+
+	f.PeakHour = profile.Peak()
+	f.PeakPodTripsPerHour = throughput * profile[f.PeakHour]
+	f.PeakPods = f.HourlyPods[f.PeakHour]
+	f.AveragePods = float64(sum) / float64(len(profile))
+	f.IdlePods = float64(f.PeakPods) - f.AveragePods
+	return f
+}
+
+// Seconds from midnight by which the share u (0 to 1) of the day's pod loads
+// has turned up, spreading each hour's share evenly over the hour
+func (p DemandProfile) timeOf(u float64) float64 {
+	if len(p) == 0 {
+		return u * 24 * 3600
+	}
+	for h, share := range p {
+		if u <= share && share > 0 {
+			return (float64(h) + u/share) * 3600
+		}
+		u -= share
+	}
+	return float64(len(p)) * 3600
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestParseDemandProfile(t *testing.T) {
+
+	tests := []struct {
+		s    string
+		peak int
+		ok   bool
+	}{
+		{"", 0, true},
+		{"uniform", 0, true},
+		{"commuter_double_peak", 7, true},
+		{"freight_night_peak", 1, true},
+		{"0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,2,1,0,0,0,0,0", 17, true},
+		{"1,2,3", 0, false},
+		{"0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0", 0, false},
+		{"0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,-1", 0, false},
+		{"0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,NaN", 0, false},
+		{"0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,1,Inf", 0, false},
+		{"rush", 0, false},
+	}
+	for _, tt := range tests {
+		p, err := parseDemandProfile(tt.s)
+		if (err == nil) != tt.ok {
+			t.Errorf("%q: error %v, want ok %v", tt.s, err, tt.ok)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		sum := 0.0
+		for _, share := range p {
+			sum += share
+		}
+		if len(p) != 24 || math.Abs(sum-1) > 1e-12 || p.Peak() != tt.peak {
+			t.Errorf("%q: %d hours summing to %v, peak %d, want %d", tt.s, len(p), sum, p.Peak(), tt.peak)
+		}
+	}
+
+	if _, err := parseDemandProfile("rush"); err == nil || !strings.Contains(err.Error(), "commuter_double_peak") {
+		t.Errorf("error %v does not list the profiles", err)
+	}
+}
+
+func TestDemandFleet(t *testing.T) {
+
+	uniform, _ := parseDemandProfile("uniform")
+	f := demandFleet(uniform, 600, 2000, 4)
+	pods := calcNumberOfPods(600, 2000, 4)
+	if f.PeakPods != pods || f.AveragePods != float64(pods) || f.IdlePods != 0 {
+		t.Errorf("uniform demand: peak %d average %v idle %v, want %d all day", f.PeakPods, f.AveragePods, f.IdlePods, pods)
+	}
+
+	commuter, _ := parseDemandProfile("commuter_double_peak")
+	f = demandFleet(commuter, 600, 2000, 4)
+	for h, n := range f.HourlyPods {
+		if n > f.PeakPods {
+			t.Errorf("hour %d needs %d pods, more than the %d of the peak", h, n, f.PeakPods)
+		}
+	}
+	if f.PeakHour != 7 || f.PeakPods <= pods || f.IdlePods <= 0 || math.Abs(f.PeakPodTripsPerHour-2000*commuter[7]) > 1e-9 {
+		t.Errorf("commuter demand: %+v", f)
+	}
+}
+
+func TestDemandTimeOf(t *testing.T) {
+
+	uniform, _ := parseDemandProfile("uniform")
+	if got := uniform.timeOf(0.5); math.Abs(got-12*3600) > 1e-6 {
+		t.Errorf("half the day's loads by %v s", got)
+	}
+
+	night, _ := parseDemandProfile("0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,1,1")
+	prev := 0.0
+	for u := 0.0; u <= 1; u += 0.01 {
+		tm := night.timeOf(u)
+		if tm < prev || tm < 22*3600 || tm > 24*3600 {
+			t.Fatalf("share %v at %v s", u, tm)
+		}
+		prev = tm
+	}
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Default distance between elevation samples along a route
+const elevationSpacingM float64 = 100.0
+
+// Most samples a profile may have, so a tiny spacing cannot run away
+const maxElevationSamples = 100000
+
+// SRTM marks missing data with this value
+const hgtVoid = -32768
+
+// Ground height in metres above the geoid
+type ElevationProvider interface {
+	Elevation(p LatLng) (float64, error)
+}
+
+// Elevation from the DEM tiles in DEM_DIR, set up in main
+var dem ElevationProvider
+
+// Reads one degree DEM tiles from a directory: SRTM .hgt tiles (1 or 3 arc
+// second) named after their south west corner as in N53E017.hgt, or GeoTIFF
+// tiles named the same with .tif or as Copernicus DEM names them, e.g.
+// Copernicus_DSM_COG_10_N53_00_E017_00_DEM.tif. Tiles are loaded on first use
+// and kept in memory
+type demProvider struct {
+	dir   string
+	mu    sync.Mutex
+	tiles map[string]*demTile
+}
+
+// A grid of samples, rows from the north. Samples are int16 or float32, and
+// those equal to void or NaN have no data
+type demTile struct {
+	rows, cols  int
+	north, west float64 // degrees at the centre of the first sample
+	dLat, dLng  float64 // degrees between samples
+	ints        []int16
+	floats      []float32
+	void        float64
+}
+
+func newDEMProvider(dir string) *demProvider {
+	return &demProvider{dir: dir, tiles: make(map[string]*demTile)}
+}
+
+func (t *demTile) at(row int, col int) (float64, bool) {
+	var v float64
+	if t.ints != nil {
+		v = float64(t.ints[row*t.cols+col])
+	} else {
+		v = float64(t.floats[row*t.cols+col])
+	}
+	return v, v != t.void && !math.IsNaN(v)
+}
+
+func hgtTileName(lat int, lng int) string {
+	ns, ew := 'N', 'E'
+	if lat < 0 {
+		ns, lat = 'S', -lat
+	}
+	if lng < 0 {
+		ew, lng = 'W', -lng
+	}
+	return fmt.Sprintf("%c%02d%c%03d.hgt", ns, lat, ew, lng)
+}
+
+// Part of a Copernicus DEM file name, e.g. N53_00_E017_00
+func copernicusTileName(lat int, lng int) string {
+	name := hgtTileName(lat, lng)
+	return name[:3] + "_00_" + name[3:7] + "_00"
+}
+
+func (h *demProvider) tile(lat int, lng int) (*demTile, error) {
+
+	name := strings.TrimSuffix(hgtTileName(lat, lng), ".hgt")
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if t, ok := h.tiles[name]; ok {
+		if t == nil {
+			return nil, fmt.Errorf("no elevation tile %s", name)
+		}
+		return t, nil
+	}
+
+	path := filepath.Join(h.dir, name+".hgt")
+	raw, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		path = filepath.Join(h.dir, name+".tif")
+		raw, err = ioutil.ReadFile(path)
+	}
+	if os.IsNotExist(err) {
+		matches, _ := filepath.Glob(filepath.Join(h.dir, "*_"+copernicusTileName(lat, lng)+"_*.tif"))
+		if len(matches) > 0 {
+			path = matches[0]
+			raw, err = ioutil.ReadFile(path)
+		}
+	}
+	if os.IsNotExist(err) {
+		h.tiles[name] = nil
+		return nil, fmt.Errorf("no elevation tile %s", name)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var t *demTile
+	if filepath.Ext(path) == ".hgt" {
+		t, err = parseHGT(raw, lat, lng)
+	} else {
+		t, err = parseGeoTIFF(raw)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("elevation tile %s: %v", filepath.Base(path), err)
+	}
+	h.tiles[name] = t
+
+	return t, nil
+}
+
+// A square grid of big endian int16 posts, the edges on whole degrees
+func parseHGT(raw []byte, lat int, lng int) (*demTile, error) {
+
+	size := int(math.Sqrt(float64(len(raw) / 2)))
+	if size < 2 || size*size*2 != len(raw) {
+		return nil, fmt.Errorf("not a square .hgt grid")
+	}
+
+	step := 1 / float64(size-1)
+	t := &demTile{rows: size, cols: size, north: float64(lat + 1), west: float64(lng), dLat: step, dLng: step,
+		ints: make([]int16, size*size), void: hgtVoid}
+	for i := range t.ints {
+		t.ints[i] = int16(binary.BigEndian.Uint16(raw[2*i:]))
+	}
+	return t, nil
+}
+
+// Bilinear interpolation between the four surrounding posts. Void posts are left
+// out and the rest reweighted. Past the outer posts of a tile the edge is held
+func (h *demProvider) Elevation(p LatLng) (float64, error) {
+
+	t, err := h.tile(int(math.Floor(p.Lat)), int(math.Floor(p.Lng)))
+	if err != nil {
+		return 0, err
+	}
+
+	x := math.Max(0, math.Min((p.Lng-t.west)/t.dLng, float64(t.cols-1)))
+	y := math.Max(0, math.Min((t.north-p.Lat)/t.dLat, float64(t.rows-1)))
+	col := math.Min(math.Floor(x), float64(t.cols-2))
+	row := math.Min(math.Floor(y), float64(t.rows-2))
+	fx := x - col
+	fy := y - row
+
+	posts := [4]struct{ r, c, w float64 }{
+		{row, col, (1 - fx) * (1 - fy)},
+		{row, col + 1, fx * (1 - fy)},
+		{row + 1, col, (1 - fx) * fy},
+		{row + 1, col + 1, fx * fy},
+	}
+
+	sum, weight := 0.0, 0.0
+	for _, post := range posts {
+		v, ok := t.at(int(post.r), int(post.c))
+		if !ok {
+			continue
+		}
+		sum += v * post.w
+		weight += post.w
+	}
+	if weight == 0 {
+		return 0, fmt.Errorf("no elevation data at %.5f, %.5f", p.Lat, p.Lng)
+	}
+	return sum / weight, nil
+}
+
+type ElevationSample struct {
+	Distance  float64 `json:"distance"` // m from the start of the route
+	Lat       float64 `json:"lat"`
+	Lng       float64 `json:"lng"`
+	Elevation float64 `json:"elevation"` // m
+}
+
+type ElevationProfile struct {
+	Spacing float64           `json:"spacing"`
+	Samples []ElevationSample `json:"samples"`
+	Min     float64           `json:"min"`
+	Max     float64           `json:"max"`
+	Ascent  float64           `json:"ascent"`  // total climb from start to end
+	Descent float64           `json:"descent"` // total drop from start to end
+}
+
+// Samples the ground along the alignment every spacing metres and at the end
+func sampleElevation(al Alignment, provider ElevationProvider, spacing float64) (ElevationProfile, error) {
+
+	prof := ElevationProfile{Spacing: spacing, Min: math.Inf(1), Max: math.Inf(-1)}
+	if spacing <= 0 {
+		return prof, fmt.Errorf("spacing must be positive")
+	}
+
+	count := int(math.Ceil(al.Length/spacing)) + 1
+	if count > maxElevationSamples {
+		return prof, fmt.Errorf("spacing of %g m gives too many samples", spacing)
+	}
+
+	for i := 0; i < count; i++ {
+		d := math.Min(float64(i)*spacing, al.Length)
+		p := al.PointAt(d)
+
+		elev, err := provider.Elevation(p)
+		if err != nil {
+			return prof, err
+		}
+
+		if i > 0 {
+			rise := elev - prof.Samples[i-1].Elevation
+			if rise > 0 {
+				prof.Ascent += rise
+			} else {
+				prof.Descent -= rise
+			}
+		}
+		prof.Min = math.Min(prof.Min, elev)
+		prof.Max = math.Max(prof.Max, elev)
+		prof.Samples = append(prof.Samples, ElevationSample{d, p.Lat, p.Lng, elev})
+	}
+
+	return prof, nil
+}
+
+// Elevation at a distance along the route, interpolated between samples
+func (prof *ElevationProfile) At(d float64) float64 {
+
+	s := prof.Samples
+	if len(s) == 0 {
+		return 0
+	}
+
+	i := sort.Search(len(s), func(i int) bool { return s[i].Distance >= d })
+	if i == 0 {
+		return s[0].Elevation
+	}
+	if i == len(s) {
+		return s[len(s)-1].Elevation
+	}
+
+	a, b := s[i-1], s[i]
+	if b.Distance == a.Distance {
+		return b.Elevation
+	}
+	return a.Elevation + (b.Elevation-a.Elevation)*(d-a.Distance)/(b.Distance-a.Distance)
+}
+
+// The same profile seen from the other end of the route
+func (prof *ElevationProfile) Reverse() *ElevationProfile {
+
+	rev := *prof
+	rev.Ascent, rev.Descent = prof.Descent, prof.Ascent
+	rev.Samples = make([]ElevationSample, len(prof.Samples))
+
+	length := 0.0
+	if n := len(prof.Samples); n > 0 {
+		length = prof.Samples[n-1].Distance
+	}
+	for i, s := range prof.Samples {
+		s.Distance = length - s.Distance
+		rev.Samples[len(prof.Samples)-1-i] = s
+	}
+	return &rev
+}
+
+// Reads ?spacing= in metres, elevationSpacingM by default
+func requestSpacing(r *http.Request) (float64, error) {
+
+	v := r.URL.Query().Get("spacing")
+	if v == "" {
+		return elevationSpacingM, nil
+	}
+
+	spacing, err := strconv.ParseFloat(v, 64)
+	if err != nil || spacing <= 0 || math.IsNaN(spacing) || math.IsInf(spacing, 0) {
+		return 0, fmt.Errorf("invalid spacing %q", v)
+	}
+	return spacing, nil
+}
+
+// GET /routes/{id}/elevation?spacing=100
+func elevationHandler(w http.ResponseWriter, r *http.Request, route Route) {
+
+	if len(route.Segments) < 2 {
+		http.Error(w, "route needs at least two points", http.StatusBadRequest)
+		return
+	}
+
+	spacing, err := requestSpacing(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	geo, err := requestGeodesic(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	prof, err := sampleElevation(makeAlignment(route, geo), dem, spacing)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	writeJSON(w, prof)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// A 3 by 3 tile, rows from the north edge
+func writeTestTile(t *testing.T, dir string, name string, posts []int16) {
+	raw := make([]byte, 2*len(posts))
+	for i, v := range posts {
+		binary.BigEndian.PutUint16(raw[2*i:], uint16(v))
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, name), raw, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHGTTileName(t *testing.T) {
+	for _, tt := range []struct {
+		lat, lng int
+		name     string
+	}{{53, 17, "N53E017.hgt"}, {-34, 18, "S34E018.hgt"}, {40, -4, "N40W004.hgt"}, {0, 0, "N00E000.hgt"}} {
+		if got := hgtTileName(tt.lat, tt.lng); got != tt.name {
+			t.Errorf("%d, %d is %s, want %s", tt.lat, tt.lng, got, tt.name)
+		}
+	}
+}
+
+func TestHGTElevation(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "hgt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeTestTile(t, dir, "N52E004.hgt", []int16{
+		20, 40, hgtVoid,
+		10, 30, 50,
+		0, 20, 40,
+	})
+	writeTestTile(t, dir, "N53E004.hgt", []int16{1, 2, 3})
+	h := newDEMProvider(dir)
+
+	tests := []struct {
+		p    LatLng
+		want float64
+	}{
+		{LatLng{52, 4}, 0},        // south west corner
+		{LatLng{52, 4.75}, 30},    // along the south edge
+		{LatLng{52.5, 4.5}, 30},   // the middle post
+		{LatLng{52.25, 4.25}, 15}, // between four posts
+		{LatLng{52.75, 4.75}, 40}, // the void post left out
+	}
+	for _, tt := range tests {
+		got, err := h.Elevation(tt.p)
+		if err != nil || math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("%v: %v %v, want %v", tt.p, got, err, tt.want)
+		}
+	}
+
+	if _, err := h.Elevation(LatLng{51.5, 4.5}); err == nil {
+		t.Error("no error for a missing tile")
+	}
+	if _, err := h.Elevation(LatLng{53.5, 4.5}); err == nil {
+		t.Error("no error for a tile that is not square")
+	}
+}
+
+// Ground rising 1 m every 10 m north
+type slopeProvider struct{}
+
+func (slopeProvider) Elevation(p LatLng) (float64, error) {
+	return ellipsoidal.Distance(LatLng{52, 4}, LatLng{p.Lat, 4}) / 10, nil
+}
+
+func TestSampleElevation(t *testing.T) {
+
+	al := makeAlignment(Route{Segments: []Segment{{Lat: 52, Lng: 4}, {Lat: 52.01, Lng: 4}}}, ellipsoidal)
+	prof, err := sampleElevation(al, slopeProvider{}, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := prof.Samples[len(prof.Samples)-1]
+	if len(prof.Samples) != int(math.Ceil(al.Length/100))+1 || last.Distance != al.Length {
+		t.Errorf("%d samples ending at %v of %v m", len(prof.Samples), last.Distance, al.Length)
+	}
+	if math.Abs(prof.Ascent-al.Length/10) > 1e-6 || prof.Descent != 0 || math.Abs(prof.At(550)-55) > 1e-6 {
+		t.Errorf("ascent %v descent %v, %v m at 550 m", prof.Ascent, prof.Descent, prof.At(550))
+	}
+
+	rev := prof.Reverse()
+	if rev.Ascent != prof.Descent || rev.Descent != prof.Ascent || math.Abs(rev.At(al.Length-550)-prof.At(550)) > 1e-6 {
+		t.Errorf("reversed ascent %v descent %v, %v m at 550 m from the end", rev.Ascent, rev.Descent, rev.At(al.Length-550))
+	}
+
+	if _, err := sampleElevation(al, slopeProvider{}, 0.001); err == nil {
+		t.Error("no error for too many samples")
+	}
+}
+
+func TestRequestSpacing(t *testing.T) {
+
+	if spacing, err := requestSpacing(httptest.NewRequest("GET", "/routes/a/elevation", nil)); err != nil || spacing != elevationSpacingM {
+		t.Errorf("default spacing %v, error %v", spacing, err)
+	}
+	if spacing, err := requestSpacing(httptest.NewRequest("GET", "/routes/a/elevation?spacing=50", nil)); err != nil || spacing != 50 {
+		t.Errorf("spacing %v, error %v", spacing, err)
+	}
+	for _, v := range []string{"0", "-10", "NaN", "Inf", "far"} {
+		if _, err := requestSpacing(httptest.NewRequest("GET", "/routes/a/elevation?spacing="+v, nil)); err == nil {
+			t.Errorf("spacing=%s: no error", v)
+		}
+	}
+}
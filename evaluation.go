@@ -0,0 +1,241 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+)
+
+// Inputs to an evaluation, the same values the settings panel in index.html sends
+type EvalParams struct {
+	Pod         Pod     `json:"pod"`
+	Throughput  float64 `json:"throughput"`  // Service units a day
+	LoadingTime float64 `json:"loadingtime"` // minutes
+	Diameter    float64 `json:"diameter"`    // m
+	Geodesic    string  `json:"geodesic"`
+
+	Vertical VerticalLimits `json:"vertical"`
+	Service  ServiceMode    `json:"service"`
+	Demand   DemandProfile  `json:"demand_profile"` // share of the throughput by hour
+}
+
+type CapexBreakdown struct {
+	TubeSegments    int     `json:"tube_segments"`
+	TubeSegmentCost float64 `json:"tube_segment_cost"`
+	TubeJointCost   float64 `json:"tube_joint_cost"`
+	Pylons          int     `json:"pylons"`
+	PylonCost       float64 `json:"pylon_cost"`
+	CivilCost       float64 `json:"civil_cost"` // construction other than pylons
+	Stations        int     `json:"stations"`
+	StationCost     float64 `json:"station_cost"`
+	Total           float64 `json:"total"`
+
+	Construction []ConstructionCost `json:"construction"`
+
+	// Pylon heights are the tube grade line less the ground
+	PylonBands      []PylonBandCount `json:"pylon_bands,omitempty"`
+	MinPylonHeight  float64          `json:"min_pylon_height"`
+	MaxPylonHeight  float64          `json:"max_pylon_height"`
+	MeanPylonHeight float64          `json:"mean_pylon_height"`
+	BridgePylons    int              `json:"bridge_pylons"` // taller than bridgePylonHeight
+	Bridges         []Bridge         `json:"bridges,omitempty"`
+}
+
+// Yearly running costs
+type OpexBreakdown struct {
+	TripsPerYear     float64 `json:"trips_per_year"`
+	EnergyKwhPerYear float64 `json:"energy_kwh_per_year"`
+	EnergyKwhPerUnit float64 `json:"energy_kwh_per_unit"` // carried one way, with the pod's empty return
+	EnergyCost       float64 `json:"energy_cost"`
+	Maintenance      float64 `json:"maintenance"`
+	Total            float64 `json:"total"`
+}
+
+type FleetSize struct {
+	TravelTime     float64     `json:"travel_time"`     // s one way, the mean of the two directions
+	RoundTripTime  float64     `json:"round_trip_time"` // minutes including loading at both ends
+	UnitsPerMinute float64     `json:"units_per_minute"`
+	PodTripsPerDay float64     `json:"pod_trips_per_day"` // loaded
+	Pods           int         `json:"pods"`              // enough for the peak hour
+	Demand         DemandFleet `json:"demand"`
+}
+
+type Evaluation struct {
+	ID     int            `json:"id,omitempty"`
+	Route  string         `json:"route"`
+	Params EvalParams     `json:"params"`
+	Length float64        `json:"length"`
+	Fleet  FleetSize      `json:"fleet"`
+	Capex  CapexBreakdown `json:"capex"`
+	Opex   OpexBreakdown  `json:"opex"`
+	Speed  SpeedProfile   `json:"speed"`  // start to end
+	Return SpeedProfile   `json:"return"` // end back to start
+
+	// nil when the DEM does not cover the route, which is then taken as flat
+	Elevation *ElevationProfile `json:"elevation,omitempty"`
+	Vertical  *VerticalProfile  `json:"vertical,omitempty"`
+
+	Curves   []CurveReport   `json:"curves"`
+	Stations []StationReport `json:"stations"`
+	Capacity LineCapacity    `json:"capacity"`
+}
+
+// Defaults match the settings panel and the first pod preset
+func defaultEvalParams() EvalParams {
+	demand, _ := parseDemandProfile("uniform")
+	return EvalParams{
+		Pod:         podPresets[0],
+		Throughput:  100,
+		LoadingTime: 4,
+		Diameter:    4.5,
+		Geodesic:    "ellipsoidal",
+		Vertical:    defaultVerticalLimits(),
+		Service:     defaultServiceMode(),
+		Demand:      demand,
+	}
+}
+
+// Reads evaluation inputs from a query string using the field names in index.html.
+// pod picks a preset (1 to 5 as in route.js) which the other pod fields then override
+func evalParamsFromQuery(q url.Values) (EvalParams, error) {
+
+	p := defaultEvalParams()
+
+	if v := q.Get("pod"); v != "" {
+		i, err := strconv.Atoi(v)
+		if err != nil || i < 1 || i > len(podPresets) {
+			return p, fmt.Errorf("pod must be 1 to %d", len(podPresets))
+		}
+		p.Pod = podPresets[i-1]
+	}
+
+	// the pod's kinematics and the design limits divide, so only throughput
+	// and loadingtime may be 0
+	fields := []struct {
+		name     string
+		scale    float64
+		dst      *float64
+		positive bool
+	}{
+		{"max_velocity", 1 / 3.6, &p.Pod.MaxSpeed, true},
+		{"accelleration", gravity, &p.Pod.MaxAccelMss, true},
+		{"emergency_decelleration", gravity, &p.Pod.EmergencyMss, true},
+		{"cornering_accelleration", gravity, &p.Pod.MaxCornerMss, true},
+		{"vertical_accelleration", gravity, &p.Pod.MaxVertMss, true},
+		{"max_power", 1, &p.Pod.MaxPower, true},
+		{"podweight", 1000, &p.Pod.Mass, true},
+		{"throughput", 1, &p.Throughput, false},
+		{"loadingtime", 1, &p.LoadingTime, false},
+		{"diameter", 1, &p.Diameter, true},
+		{"max_grade", 1, &p.Vertical.MaxGrade, true},
+		{"crest_radius", 1, &p.Vertical.MinCrestRadius, true},
+		{"sag_radius", 1, &p.Vertical.MinSagRadius, true},
+	}
+	for _, f := range fields {
+		v := q.Get(f.name)
+		if v == "" {
+			continue
+		}
+		x, err := strconv.ParseFloat(v, 64)
+		if err != nil || x < 0 || math.IsNaN(x) || math.IsInf(x, 0) {
+			return p, fmt.Errorf("invalid %s %q", f.name, v)
+		}
+		if f.positive && x == 0 {
+			return p, fmt.Errorf("%s must be more than 0", f.name)
+		}
+		*f.dst = x * f.scale
+	}
+
+	service, err := serviceModeFromQuery(q, p.Pod)
+	if err != nil {
+		return p, err
+	}
+	p.Service = service
+
+	if v := q.Get("demand_profile"); v != "" {
+		demand, err := parseDemandProfile(v)
+		if err != nil {
+			return p, err
+		}
+		p.Demand = demand
+	}
+
+	if v := q.Get("geodesic"); v != "" {
+		if _, err := parseGeodesic(v); err != nil {
+			return p, err
+		}
+		p.Geodesic = v
+	}
+	return p, nil
+}
+
+// Simulates the route and works out fleet size, capex and opex
+func evaluateRoute(route Route, params EvalParams) Evaluation {
+
+	geo, err := parseGeodesic(params.Geodesic)
+	if err != nil {
+		geo = ellipsoidal
+	}
+
+	al := makeAlignment(route, geo)
+
+	ev := Evaluation{
+		ID:     route.ID,
+		Route:  route.Name,
+		Params: params,
+		Length: al.Length,
+		Curves: al.Curves(params.Pod),
+	}
+
+	// the pod runs along the tube grade line rather than the ground
+	var back *VerticalProfile
+	if dem != nil {
+		if prof, err := sampleElevation(al, dem, elevationSpacingM); err == nil {
+			vp := designVertical(&prof, params.Vertical)
+			ev.Elevation = &prof
+			ev.Vertical = &vp
+			back = vp.Reverse()
+		}
+	}
+
+	ev.Capex = calcCapexBreakdown(al.Length, routeLegs(route, al), ev.Vertical, route.Stations)
+	ev.Speed = simulateSpeed(al, params.Pod, ev.Vertical)
+	ev.Return = simulateSpeed(makeAlignment(reverseRoute(route), geo), params.Pod, back)
+
+	// intermediate station dwells are in the travel time, those at the ends replace loadingtime
+	start, end := terminalLoading(route, params.LoadingTime)
+	// the fleet is sized for the busiest hour, sizing for the day's average falls short
+	travelTime := (ev.Speed.Time + ev.Return.Time) / 2
+	ev.Fleet = FleetSize{
+		TravelTime:     travelTime,
+		RoundTripTime:  (ev.Speed.Time+ev.Return.Time)/60 + start + end,
+		UnitsPerMinute: params.Throughput / (24 * 60),
+		PodTripsPerDay: params.Service.podTrips(params.Throughput, params.Pod),
+	}
+	ev.Fleet.Demand = demandFleet(params.Demand, travelTime, ev.Fleet.PodTripsPerDay, (start+end)/2)
+	ev.Fleet.Pods = ev.Fleet.Demand.PeakPods
+	ev.Stations = stationReports(route, al, params)
+	ev.Capacity = lineCapacity(params.Pod, params.Service, params.Throughput, map[string]SpeedProfile{"out": ev.Speed, "return": ev.Return})
+
+	ev.Opex = calcOpex(ev, params)
+
+	return ev
+}
+
+// Every loaded pod trip is a round trip, one run in each direction
+func calcOpex(ev Evaluation, params EvalParams) OpexBreakdown {
+
+	var o OpexBreakdown
+
+	o.TripsPerYear = 2 * ev.Fleet.PodTripsPerDay * 365
+	o.EnergyKwhPerYear = math.Max(0, ev.Speed.EnergyKwh+ev.Return.EnergyKwh) * o.TripsPerYear / 2
+	if params.Throughput > 0 {
+		o.EnergyKwhPerUnit = o.EnergyKwhPerYear / (params.Throughput * 365)
+	}
+	o.EnergyCost = o.EnergyKwhPerYear * energyCostPerKWh
+	o.Maintenance = ev.Capex.Total * maintenanceRate
+	o.Total = o.EnergyCost + o.Maintenance
+
+	return o
+}
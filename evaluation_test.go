@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestEvalParamsFromQuery(t *testing.T) {
+
+	tests := []struct {
+		query string
+		ok    bool
+	}{
+		{"", true},
+		{"pod=3&max_velocity=600&accelleration=0.2", true},
+		{"throughput=0&loadingtime=0", true},
+		{"max_velocity=0", false},
+		{"accelleration=0", false},
+		{"cornering_accelleration=0", false},
+		{"vertical_accelleration=0", false},
+		{"emergency_decelleration=0", false},
+		{"podweight=0", false},
+		{"max_power=0", false},
+		{"max_grade=0", false},
+		{"throughput=-1", false},
+		{"max_velocity=NaN", false},
+		{"max_velocity=Inf", false},
+		{"pod=9", false},
+	}
+	for _, tt := range tests {
+		q, _ := url.ParseQuery(tt.query)
+		_, err := evalParamsFromQuery(q)
+		if (err == nil) != tt.ok {
+			t.Errorf("%q: error %v, want ok %v", tt.query, err, tt.ok)
+		}
+	}
+}
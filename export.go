@@ -0,0 +1,244 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Sections of an evaluation export, each a CSV section or a workbook sheet
+func evaluationTables(evals []Evaluation) []table {
+
+	summary := table{Name: "Summary", Header: []string{"Route id", "Route", "Pod", "Length (m)", "Travel time (s)", "Avg speed (km/h)",
+		"Throughput (per day)", "Loading time (min)", "Tube diameter (m)", "Max speed (km/h)", "Accel (g)",
+		"Cornering (g)", "Vertical (g)", "Pod mass (kg)", "Motor power (kW)", "Geodesic", "Pods", "Capex (€)", "Opex (€/year)", "Energy per trip (kWh)",
+		"Return time (s)", "Return energy (kWh)", "Ascent (m)", "Descent (m)",
+		"Pylons", "Max pylon height (m)", "Mean pylon height (m)", "Bridge pylons", "Bridges", "Tunnels", "Tunnel length (m)",
+		"Elevated (m)", "At grade (m)", "Cut and cover (m)", "Bored tunnel (m)", "Bridge (m)",
+		"Service", "Unit", "Load factor", "Energy per unit (kWh)", "Curves", "Transitions (m)"}}
+	capex := table{Name: "Capex", Header: []string{"Route id", "Route", "Item", "Quantity", "Unit cost (€)", "Cost (€)"}}
+	opex := table{Name: "Opex", Header: []string{"Route id", "Route", "Item", "Quantity", "Unit cost (€)", "Cost (€/year)"}}
+	fleet := table{Name: "Pods", Header: []string{"Route id", "Route", "Travel time (s)", "Round trip (min)", "Units per minute", "Pod trips per day", "Pods",
+		"Peak hour", "Peak pod trips per hour", "Average pods", "Idle pods",
+		"Line capacity (units/day)", "Bottleneck headway (s)", "Bottleneck direction", "Bottleneck at (km)", "Capacity exceeded"}}
+	stations := table{Name: "Stations", Header: []string{"Route id", "Route", "Station", "Vertex", "Distance (km)", "Dwell (s)", "Berths",
+		"Capex (€)", "Capacity (pods/hour)", "Demand (pods/hour)", "Utilisation"}}
+	curves := table{Name: "Curves", Header: []string{"Route id", "Route", "Vertex", "Distance (km)", "Radius (m)", "Transition (m)", "Cant (deg)", "Angle (deg)", "Length (m)"}}
+	speed := table{Name: "Speed", Header: []string{"Route id", "Route", "Direction", "Distance (km)", "Length (m)", "Radius (m)", "Cant (deg)", "Grade (%)", "Vertical radius (m)", "Speed limit (km/h)",
+		"Speed (km/h)", "Time (s)", "Route time (s)", "Energy (kJ)", "Battery (kWh)"}}
+
+	for _, ev := range evals {
+		p := ev.Params
+		var ascent, descent, tunnels, tunnelLength interface{} = "", "", "", ""
+		if ev.Elevation != nil {
+			ascent, descent = ev.Elevation.Ascent, ev.Elevation.Descent
+		}
+		if ev.Vertical != nil {
+			tunnels, tunnelLength = len(ev.Vertical.Tunnels), ev.Vertical.TunnelLength
+		}
+		transitions := 0.0
+		for _, c := range ev.Curves {
+			transitions += 2 * c.Spiral
+		}
+		built := make(map[string]float64)
+		for _, cc := range ev.Capex.Construction {
+			built[cc.Type] = cc.Length
+		}
+		summary.add(ev.ID, ev.Route, p.Pod.Name, ev.Length, ev.Speed.Time, ev.Speed.AvgSpeed*3.6,
+			p.Throughput, p.LoadingTime, p.Diameter, p.Pod.MaxSpeed*3.6, p.Pod.MaxAccelMss/gravity,
+			p.Pod.MaxCornerMss/gravity, p.Pod.MaxVertMss/gravity, p.Pod.Mass, p.Pod.MaxPower, p.Geodesic, ev.Fleet.Pods, ev.Capex.Total, ev.Opex.Total, ev.Speed.EnergyKwh,
+			ev.Return.Time, ev.Return.EnergyKwh, ascent, descent,
+			ev.Capex.Pylons, ev.Capex.MaxPylonHeight, ev.Capex.MeanPylonHeight, ev.Capex.BridgePylons, len(ev.Capex.Bridges), tunnels, tunnelLength,
+			built[constructElevated], built[constructAtGrade], built[constructCutCover], built[constructBored], built[constructBridge],
+			p.Service.Mode, p.Service.label(), p.Service.LoadFactor, ev.Opex.EnergyKwhPerUnit, len(ev.Curves), transitions)
+
+		capex.add(ev.ID, ev.Route, "Tube segments", ev.Capex.TubeSegments, tubeSegmentCost, ev.Capex.TubeSegmentCost)
+		capex.add(ev.ID, ev.Route, "Tube joints", ev.Capex.TubeSegments, tubeJointCost, ev.Capex.TubeJointCost)
+		labels, bands := pylonLines(ev.Capex)
+		for i, b := range bands {
+			capex.add(ev.ID, ev.Route, labels[i], b.Count, b.Cost, b.Total)
+		}
+		if ev.Capex.Stations > 0 {
+			capex.add(ev.ID, ev.Route, "Stations", ev.Capex.Stations, "", ev.Capex.StationCost)
+		}
+		for _, cc := range ev.Capex.Construction {
+			if cc.CostPerM > 0 {
+				capex.add(ev.ID, ev.Route, constructionLabel(cc.Type)+" (m)", cc.Length, cc.CostPerM, cc.Cost)
+			}
+		}
+		capex.add(ev.ID, ev.Route, "Total", "", "", ev.Capex.Total)
+
+		opex.add(ev.ID, ev.Route, "Energy (kWh)", ev.Opex.EnergyKwhPerYear, energyCostPerKWh, ev.Opex.EnergyCost)
+		opex.add(ev.ID, ev.Route, "Maintenance (share of capex)", maintenanceRate, ev.Capex.Total, ev.Opex.Maintenance)
+		opex.add(ev.ID, ev.Route, "Total", "", "", ev.Opex.Total)
+
+		b := ev.Capacity.Bottleneck
+		fleet.add(ev.ID, ev.Route, ev.Fleet.TravelTime, ev.Fleet.RoundTripTime, ev.Fleet.UnitsPerMinute, ev.Fleet.PodTripsPerDay, ev.Fleet.Pods,
+			ev.Fleet.Demand.PeakHour, ev.Fleet.Demand.PeakPodTripsPerHour, ev.Fleet.Demand.AveragePods, ev.Fleet.Demand.IdlePods,
+			ev.Capacity.MaxThroughput, b.Headway, b.Direction, b.Distance/1000, fmt.Sprint(ev.Capacity.Exceeded))
+
+		for _, st := range ev.Stations {
+			var capacity interface{} = st.PodsPerHour
+			if st.Unlimited {
+				capacity = "unlimited"
+			}
+			stations.add(ev.ID, ev.Route, st.Name, st.Vertex, st.Distance/1000, st.Dwell, st.Berths, st.capex(), capacity, st.Demand, st.Utilisation)
+		}
+
+		for _, c := range ev.Curves {
+			curves.add(ev.ID, ev.Route, c.Vertex, c.Distance/1000, c.Radius, c.Spiral, c.Cant, c.Angle, c.Length)
+		}
+
+		for _, s := range ev.Speed.Segments {
+			speed.add(ev.ID, ev.Route, "Out", s.Distance/1000, s.Length, s.Radius, s.Cant, s.Grade*100, s.VertRadius, s.SpeedLimit*3.6, s.Speed*3.6, s.Time, s.RouteTime, s.Energy, s.Battery)
+		}
+		for _, s := range ev.Return.Segments {
+			speed.add(ev.ID, ev.Route, "Return", s.Distance/1000, s.Length, s.Radius, s.Cant, s.Grade*100, s.VertRadius, s.SpeedLimit*3.6, s.Speed*3.6, s.Time, s.RouteTime, s.Energy, s.Battery)
+		}
+	}
+
+	return []table{summary, capex, opex, fleet, stations, curves, speed}
+}
+
+// Writes the tables one after the other. When there is more than one, each is
+// headed by its name and separated from the last by a blank line
+func writeCSV(w io.Writer, tables []table) error {
+
+	cw := csv.NewWriter(w)
+
+	for i, t := range tables {
+		if len(tables) > 1 {
+			if i > 0 {
+				cw.Write(nil)
+			}
+			cw.Write([]string{t.Name})
+		}
+
+		cw.Write(t.Header)
+		for _, row := range t.Rows {
+			rec := make([]string, len(row))
+			for i, v := range row {
+				rec[i] = csvValue(v)
+			}
+			cw.Write(rec)
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+func csvValue(v interface{}) string {
+	switch v := v.(type) {
+	case float64:
+		return strconv.FormatFloat(round6(v), 'f', -1, 64)
+	case int:
+		return strconv.Itoa(v)
+	}
+	return fmt.Sprint(v)
+}
+
+// GET /routes/{id}/evaluation with the settings panel values as query parameters
+func evaluationHandler(w http.ResponseWriter, r *http.Request, route Route) {
+
+	params, err := evalParamsFromQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, evaluateRoute(route, params))
+}
+
+// GET /routes/{id}/evaluation.csv and /routes/{id}/evaluation.xlsx
+func evaluationExportHandler(w http.ResponseWriter, r *http.Request, route Route) {
+
+	params, err := evalParamsFromQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ev := evaluateRoute(route, params)
+	writeEvaluations(w, r, []Evaluation{ev}, routeFileBase(route))
+}
+
+// GET /evaluations.csv?ids=1,2,3 and /evaluations.xlsx?ids=1,2,3
+// Evaluates a batch of saved routes with the same settings
+func evaluationsHandler(w http.ResponseWriter, r *http.Request) {
+
+	params, err := evalParamsFromQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var evals []Evaluation
+	for _, s := range strings.Split(r.URL.Query().Get("ids"), ",") {
+		id, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil {
+			http.Error(w, "ids must be a comma separated list of route ids", http.StatusBadRequest)
+			return
+		}
+
+		route, err := fetchRoute(id)
+		if err == sql.ErrNoRows {
+			http.Error(w, fmt.Sprintf("route %d not found", id), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Print("loading route ", id, ": ", err)
+			http.Error(w, "could not load route", http.StatusInternalServerError)
+			return
+		}
+		if err := checkRoute(route); err != nil {
+			http.Error(w, fmt.Sprintf("route %d: %v", id, err), http.StatusUnprocessableEntity)
+			return
+		}
+
+		evals = append(evals, evaluateRoute(route, params))
+	}
+
+	writeEvaluations(w, r, evals, "evaluations")
+}
+
+// Picks CSV or XLSX from the extension of the request path
+func writeEvaluations(w http.ResponseWriter, r *http.Request, evals []Evaluation, name string) {
+
+	tables := evaluationTables(evals)
+
+	if strings.HasSuffix(r.URL.Path, ".xlsx") {
+		w.Header().Set("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+".xlsx"))
+		if err := writeXLSX(w, tables); err != nil {
+			log.Print("writing xlsx: ", err)
+		}
+		return
+	}
+
+	// ?section=speed gives just that table
+	if section := r.URL.Query().Get("section"); section != "" {
+		var picked []table
+		for _, t := range tables {
+			if strings.EqualFold(section, t.Name) {
+				picked = append(picked, t)
+			}
+		}
+		if len(picked) == 0 {
+			http.Error(w, fmt.Sprintf("unknown section %q", section), http.StatusBadRequest)
+			return
+		}
+		tables = picked
+	}
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+".csv"))
+	if err := writeCSV(w, tables); err != nil {
+		log.Print("writing csv: ", err)
+	}
+}
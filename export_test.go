@@ -0,0 +1,126 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+)
+
+func testEvaluation() Evaluation {
+	route := Route{ID: 7, Name: "A to B", Segments: []Segment{{Lat: 52, Lng: 4}, {Lat: 52.1, Lng: 4, Rad: 2000}, {Lat: 52.1, Lng: 4.15}},
+		Stations: []Station{{Name: "A", Vertex: 0, Berths: 2}, {Name: "B", Vertex: 2, Berths: 2}}}
+	return evaluateRoute(route, defaultEvalParams())
+}
+
+func TestEvaluationTables(t *testing.T) {
+
+	for _, tb := range evaluationTables([]Evaluation{testEvaluation()}) {
+		if len(tb.Rows) == 0 {
+			t.Errorf("%s: no rows", tb.Name)
+		}
+		for i, row := range tb.Rows {
+			if len(row) != len(tb.Header) {
+				t.Errorf("%s row %d: %d values for %d columns", tb.Name, i, len(row), len(tb.Header))
+			}
+			for j, v := range row {
+				if f, ok := v.(float64); ok && (math.IsNaN(f) || math.IsInf(f, 0)) {
+					t.Errorf("%s row %d: %s is %v", tb.Name, i, tb.Header[j], f)
+				}
+			}
+		}
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+
+	tables := []table{
+		{Name: "One", Header: []string{"a", "b"}, Rows: [][]interface{}{{1, 0.1 + 0.2}, {"x,y", 2.5}}},
+		{Name: "Two", Header: []string{"c"}, Rows: [][]interface{}{{"z"}}},
+	}
+	var b bytes.Buffer
+	if err := writeCSV(&b, tables); err != nil {
+		t.Fatal(err)
+	}
+	r := csv.NewReader(&b)
+	r.FieldsPerRecord = -1
+	recs, err := r.ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{{"One"}, {"a", "b"}, {"1", "0.3"}, {"x,y", "2.5"}, {"Two"}, {"c"}, {"z"}}
+	if len(recs) != len(want) {
+		t.Fatalf("records %q, want %q", recs, want)
+	}
+	for i := range want {
+		if strings.Join(recs[i], "|") != strings.Join(want[i], "|") {
+			t.Errorf("record %d is %q, want %q", i, recs[i], want[i])
+		}
+	}
+
+	// a single table goes without its name
+	b.Reset()
+	writeCSV(&b, tables[1:])
+	if b.String() != "c\nz\n" {
+		t.Errorf("single table written as %q", b.String())
+	}
+}
+
+func TestWriteXLSX(t *testing.T) {
+
+	tables := evaluationTables([]Evaluation{testEvaluation()})
+	var b bytes.Buffer
+	if err := writeXLSX(&b, tables); err != nil {
+		t.Fatal(err)
+	}
+	z, err := zip.NewReader(bytes.NewReader(b.Bytes()), int64(b.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make(map[string]bool)
+	for _, f := range z.File {
+		names[f.Name] = true
+	}
+	for _, n := range []string{"[Content_Types].xml", "_rels/.rels", "xl/workbook.xml", "xl/_rels/workbook.xml.rels", "xl/worksheets/sheet1.xml", fmt.Sprintf("xl/worksheets/sheet%d.xml", len(tables))} {
+		if !names[n] {
+			t.Errorf("no %s in the workbook", n)
+		}
+	}
+}
+
+func TestSheetXMLNotFinite(t *testing.T) {
+
+	xml := sheetXML(table{Name: "One", Header: []string{"a", "b", "c", "d"}, Rows: [][]interface{}{{math.NaN(), math.Inf(1), math.Inf(-1), 2.5}}})
+	for _, bad := range []string{"NaN", "Inf"} {
+		if strings.Contains(xml, bad) {
+			t.Errorf("%s in %s", bad, xml)
+		}
+	}
+	if !strings.Contains(xml, `<c r="A2"/><c r="B2"/><c r="C2"/><c r="D2"><v>2.5</v></c>`) {
+		t.Errorf("row written as %s", xml)
+	}
+}
+
+func TestCellRefAndSheetName(t *testing.T) {
+
+	for _, tt := range []struct {
+		col, row int
+		ref      string
+	}{{0, 0, "A1"}, {25, 9, "Z10"}, {26, 0, "AA1"}, {27, 1, "AB2"}, {701, 0, "ZZ1"}, {702, 0, "AAA1"}} {
+		if got := cellRef(tt.col, tt.row); got != tt.ref {
+			t.Errorf("column %d row %d is %s, want %s", tt.col, tt.row, got, tt.ref)
+		}
+	}
+	if got := sheetName("a/b:c", 1); got != "a_b_c" {
+		t.Errorf("sheet name %q", got)
+	}
+	if got := sheetName(strings.Repeat("x", 40), 1); len(got) != 31 {
+		t.Errorf("sheet name of %d characters", len(got))
+	}
+	if got := sheetName("", 3); got != "Sheet3" {
+		t.Errorf("empty sheet name became %q", got)
+	}
+}
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"container/heap"
+	"fmt"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// The first day fills the line with pods, the second is measured
+const fleetSimDays = 2
+
+// Largest fleet the search for the smallest one will try
+const maxFleetSearch = 10000
+
+// Most pod loads a day the simulation takes on, each is a handful of events
+// and the search runs the day some 30 times
+const maxFleetSimLoads = 50000
+
+type FleetSimParams struct {
+	Pods     int     `json:"pods"`     // fleet to simulate, the closed formula's when 0
+	Headway  float64 `json:"headway"`  // s between departures from a terminal
+	Arrivals string  `json:"arrivals"` // "uniform" or "poisson"
+	Seed     int64   `json:"seed"`
+	MaxWait  float64 `json:"max_wait"` // minutes a container may wait with demand still met
+}
+
+func defaultFleetSimParams() FleetSimParams {
+	return FleetSimParams{Headway: 30, Arrivals: "uniform", Seed: 1, MaxWait: 30}
+}
+
+// Outcome of a day with a given fleet
+type FleetSimResult struct {
+	Pods           int     `json:"pods"`
+	Demand         float64 `json:"demand"`    // pod loads a day
+	Arrived        int     `json:"arrived"`   // containers turning up in the day
+	Delivered      int     `json:"delivered"` // containers reaching the far end in the day
+	MeanWait       float64 `json:"mean_wait"` // minutes from arrival to departure, less the loading
+	MaxWait        float64 `json:"max_wait"`
+	MeanQueue      float64 `json:"mean_queue"` // containers waiting, averaged over the day
+	MaxQueue       int     `json:"max_queue"`
+	PodUtilisation float64 `json:"pod_utilisation"` // share of the day pods spend away from the depot
+	MeetsDemand    bool    `json:"meets_demand"`
+}
+
+type FleetSimulation struct {
+	Params      FleetSimParams `json:"params"`
+	FormulaPods int            `json:"formula_pods"` // calcNumberOfPods on the day's average, whatever the demand profile
+	MinPods     int            `json:"min_pods"`     // smallest fleet that meets demand, 0 if none does
+	Result      FleetSimResult `json:"result"`       // for Params.Pods
+	MinResult   FleetSimResult `json:"min_result"`   // for MinPods
+}
+
+// What the fleet simulation needs from an evaluation. Times are in seconds
+type fleetLine struct {
+	out, back      float64
+	loadA, loadB   float64
+	berthA, berthB int // 0 for no limit
+	throughput     float64
+	demand         DemandProfile
+}
+
+func fleetLineFor(route Route, ev Evaluation) fleetLine {
+
+	start, end := terminalLoading(route, ev.Params.LoadingTime)
+	line := fleetLine{
+		out:        ev.Speed.Time,
+		back:       ev.Return.Time,
+		loadA:      start * 60,
+		loadB:      end * 60,
+		throughput: ev.Fleet.PodTripsPerDay,
+		demand:     ev.Params.Demand,
+	}
+	if s, ok := route.stationAt(0); ok {
+		line.berthA = s.Berths
+	}
+	if s, ok := route.stationAt(len(route.Segments) - 1); ok {
+		line.berthB = s.Berths
+	}
+	return line
+}
+
+const (
+	evContainer = iota // a container turns up at the start terminal
+	evLoadedA          // pod loaded, waiting for a departure slot
+	evDepartA
+	evArriveB
+	evUnloadedB
+	evDepartB
+	evArriveA
+)
+
+type fleetEvent struct {
+	t    float64
+	kind int
+	pod  int
+}
+
+type fleetEvents []fleetEvent
+
+func (e fleetEvents) Len() int            { return len(e) }
+func (e fleetEvents) Less(i, j int) bool  { return e[i].t < e[j].t }
+func (e fleetEvents) Swap(i, j int)       { e[i], e[j] = e[j], e[i] }
+func (e *fleetEvents) Push(x interface{}) { *e = append(*e, x.(fleetEvent)) }
+func (e *fleetEvents) Pop() interface{} {
+	old := *e
+	x := old[len(old)-1]
+	*e = old[:len(old)-1]
+	return x
+}
+
+// Runs the fleet shuttling between the terminals. Each container waits for a
+// pod and a berth at the start, is loaded, leaves no sooner than the headway
+// after the last departure, is unloaded at the far end and the pod comes back
+// empty to the depot
+func simulateFleet(line fleetLine, p FleetSimParams, pods int) FleetSimResult {
+
+	res := FleetSimResult{Pods: pods, Demand: line.throughput}
+
+	day := 24 * 3600.0
+	from, to := (fleetSimDays-1)*day, fleetSimDays*day
+	within := func(a float64, b float64) float64 { return math.Max(0, math.Min(b, to)-math.Max(a, from)) }
+
+	var events fleetEvents
+	push := func(t float64, kind int, pod int) { heap.Push(&events, fleetEvent{t, kind, pod}) }
+
+	// arrivals are spaced evenly, or at random, in the share of the day's demand
+	// and then spread over the hours by the demand profile
+	if line.throughput > 0 {
+		gap := 1 / line.throughput
+		rnd := rand.New(rand.NewSource(p.Seed))
+		for d := 0; d < fleetSimDays; d++ {
+			u := gap / 2
+			if p.Arrivals == "poisson" {
+				u = rnd.ExpFloat64() * gap
+			}
+			for u < 1 {
+				push(float64(d)*day+line.demand.timeOf(u), evContainer, -1)
+				if p.Arrivals == "poisson" {
+					u += rnd.ExpFloat64() * gap
+				} else {
+					u += gap
+				}
+			}
+		}
+	}
+
+	berthA, berthB := line.berthA, line.berthB
+	if berthA == 0 {
+		berthA = pods
+	}
+	if berthB == 0 {
+		berthB = pods
+	}
+
+	idle := make([]int, pods)
+	for i := range idle {
+		idle[i] = i
+	}
+	busySince := make([]float64, pods)
+	cargo := make([]float64, pods) // when the container on board turned up, NaN when empty
+	for i := range busySince {
+		busySince[i] = math.Inf(1)
+		cargo[i] = math.NaN()
+	}
+	var queue []float64
+	var waitPods []int
+	lastDep := [2]float64{math.Inf(-1), math.Inf(-1)}
+	now, lastQueueT := 0.0, 0.0
+	waits, measured := 0.0, 0
+	busy := 0.0
+
+	loadA := func() {
+		for len(queue) > 0 && len(idle) > 0 && berthA > 0 {
+			res.MeanQueue += float64(len(queue)) * within(lastQueueT, now)
+			lastQueueT = now
+
+			pod := idle[len(idle)-1]
+			idle = idle[:len(idle)-1]
+			cargo[pod] = queue[0]
+			queue = queue[1:]
+			berthA--
+
+			busySince[pod] = now
+			push(now+line.loadA, evLoadedA, pod)
+		}
+	}
+	unloadB := func() {
+		for len(waitPods) > 0 && berthB > 0 {
+			pod := waitPods[0]
+			waitPods = waitPods[1:]
+			berthB--
+			push(now+line.loadB, evUnloadedB, pod)
+		}
+	}
+	depart := func(end int, kind int, pod int) {
+		t := math.Max(now, lastDep[end]+p.Headway)
+		lastDep[end] = t
+		push(t, kind, pod)
+	}
+
+	for events.Len() > 0 {
+		ev := heap.Pop(&events).(fleetEvent)
+		now = ev.t
+		if now >= to {
+			break
+		}
+
+		switch ev.kind {
+		case evContainer:
+			res.MeanQueue += float64(len(queue)) * within(lastQueueT, now)
+			lastQueueT = now
+			queue = append(queue, now)
+			if now >= from {
+				res.Arrived++
+				if len(queue) > res.MaxQueue {
+					res.MaxQueue = len(queue)
+				}
+			}
+			loadA()
+		case evLoadedA:
+			depart(0, evDepartA, ev.pod)
+		case evDepartA:
+			if arrived := cargo[ev.pod]; arrived >= from {
+				w := (now - arrived - line.loadA) / 60
+				waits += w
+				measured++
+				res.MaxWait = math.Max(res.MaxWait, w)
+			}
+			cargo[ev.pod] = math.NaN()
+			berthA++
+			push(now+line.out, evArriveB, ev.pod)
+			loadA()
+		case evArriveB:
+			if now >= from {
+				res.Delivered++
+			}
+			waitPods = append(waitPods, ev.pod)
+			unloadB()
+		case evUnloadedB:
+			depart(1, evDepartB, ev.pod)
+		case evDepartB:
+			berthB++
+			push(now+line.back, evArriveA, ev.pod)
+			unloadB()
+		case evArriveA:
+			busy += within(busySince[ev.pod], now)
+			busySince[ev.pod] = math.Inf(1)
+			idle = append(idle, ev.pod)
+			loadA()
+		}
+	}
+
+	// whatever is still under way or waiting at the end of the day
+	now = to
+	res.MeanQueue += float64(len(queue)) * within(lastQueueT, now)
+	res.MeanQueue /= day
+	for _, arrived := range append(queue, cargo...) {
+		if arrived >= from {
+			res.MaxWait = math.Max(res.MaxWait, (to-arrived-line.loadA)/60)
+		}
+	}
+	for _, since := range busySince {
+		busy += within(since, to)
+	}
+
+	if measured > 0 {
+		res.MeanWait = waits / float64(measured)
+	}
+	if pods > 0 {
+		res.PodUtilisation = busy / (day * float64(pods))
+	}
+	// a fleet that cannot keep up leaves containers waiting longer and longer
+	res.MeetsDemand = res.MaxWait <= p.MaxWait
+	return res
+}
+
+// Simulates the fleet asked for and searches for the smallest that meets demand
+func runFleetSimulation(route Route, ev Evaluation, p FleetSimParams) FleetSimulation {
+
+	line := fleetLineFor(route, ev)
+	sim := FleetSimulation{Params: p}
+	sim.FormulaPods = calcNumberOfPods((line.out+line.back)/2, line.throughput, (line.loadA+line.loadB)/120)
+	if sim.Params.Pods == 0 {
+		sim.Params.Pods = ev.Fleet.Pods
+	}
+	sim.Result = simulateFleet(line, p, sim.Params.Pods)
+
+	// double up to a fleet that is enough, then bisect
+	lo, hi := 0, 1
+	hiRes := simulateFleet(line, p, hi)
+	for !hiRes.MeetsDemand {
+		if hi >= maxFleetSearch {
+			return sim // demand is out of reach, held back by berths or headway
+		}
+		lo, hi = hi, 2*hi
+		if hi > maxFleetSearch {
+			hi = maxFleetSearch
+		}
+		hiRes = simulateFleet(line, p, hi)
+	}
+	for hi-lo > 1 {
+		mid := (lo + hi) / 2
+		if r := simulateFleet(line, p, mid); r.MeetsDemand {
+			hi, hiRes = mid, r
+		} else {
+			lo = mid
+		}
+	}
+	sim.MinPods = hi
+	sim.MinResult = hiRes
+	return sim
+}
+
+// Reads pods, headway, arrivals, seed and max_wait from a query string
+func fleetSimParamsFromQuery(q url.Values) (FleetSimParams, error) {
+
+	p := defaultFleetSimParams()
+
+	if v := q.Get("pods"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxFleetSearch {
+			return p, fmt.Errorf("pods must be 1 to %d", maxFleetSearch)
+		}
+		p.Pods = n
+	}
+	for _, f := range []struct {
+		name string
+		dst  *float64
+	}{{"headway", &p.Headway}, {"max_wait", &p.MaxWait}} {
+		if v := q.Get(f.name); v != "" {
+			x, err := strconv.ParseFloat(v, 64)
+			if err != nil || x < 0 || math.IsNaN(x) || math.IsInf(x, 0) {
+				return p, fmt.Errorf("invalid %s %q", f.name, v)
+			}
+			*f.dst = x
+		}
+	}
+	if v := q.Get("arrivals"); v != "" {
+		if v != "uniform" && v != "poisson" {
+			return p, fmt.Errorf("arrivals must be uniform or poisson")
+		}
+		p.Arrivals = v
+	}
+	if v := q.Get("seed"); v != "" {
+		seed, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return p, fmt.Errorf("invalid seed %q", v)
+		}
+		p.Seed = seed
+	}
+	return p, nil
+}
+
+// GET /routes/{id}/fleet with the settings panel values and the simulation
+// settings as query parameters
+func fleetHandler(w http.ResponseWriter, r *http.Request, route Route) {
+
+	params, err := evalParamsFromQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	simParams, err := fleetSimParamsFromQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ev := evaluateRoute(route, params)
+	if loads := fleetLineFor(route, ev).throughput; loads > maxFleetSimLoads {
+		http.Error(w, fmt.Sprintf("the simulation takes up to %d pod loads a day, the throughput needs %.0f", maxFleetSimLoads, loads),
+			http.StatusUnprocessableEntity)
+		return
+	}
+	writeJSON(w, runFleetSimulation(route, ev, simParams))
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+)
+
+// An evaluation of a line 10 minutes each way with 4 minutes loading at each end
+func testFleetEvaluation(podTrips float64) Evaluation {
+	params := defaultEvalParams()
+	ev := Evaluation{Params: params}
+	ev.Speed.Time, ev.Return.Time = 600, 600
+	ev.Fleet.PodTripsPerDay = podTrips
+	ev.Fleet.Pods = calcNumberOfPods(600, podTrips, params.LoadingTime)
+	return ev
+}
+
+func TestSimulateFleetAgainstFormula(t *testing.T) {
+
+	route := Route{Segments: []Segment{{Lat: 52, Lng: 4}, {Lat: 52.1, Lng: 4}}}
+	p := defaultFleetSimParams()
+
+	for _, podTrips := range []float64{200, 1000, 2500} {
+		ev := testFleetEvaluation(podTrips)
+		line := fleetLineFor(route, ev)
+		pods := ev.Fleet.Pods
+
+		if r := simulateFleet(line, p, pods); !r.MeetsDemand {
+			t.Errorf("%v a day: the formula's %d pods leave loads waiting %.1f minutes", podTrips, pods, r.MaxWait)
+		}
+		if r := simulateFleet(line, p, pods/2); r.MeetsDemand {
+			t.Errorf("%v a day: half the formula's %d pods meets demand", podTrips, pods)
+		}
+
+		// the evaluation sizes the fleet for the peak hour, the formula for the average
+		ev.Fleet.Pods *= 2
+		sim := runFleetSimulation(route, ev, p)
+		if sim.FormulaPods != pods {
+			t.Errorf("%v a day: formula pods %d, want %d", podTrips, sim.FormulaPods, pods)
+		}
+		if sim.MinPods < 1 || sim.MinPods > pods {
+			t.Errorf("%v a day: smallest fleet %d, the formula gives %d", podTrips, sim.MinPods, pods)
+		}
+	}
+}
+
+func TestFleetSearchOutOfReach(t *testing.T) {
+
+	// pods leave a terminal every 30 s at most, which is 2880 a day
+	route := Route{Segments: []Segment{{Lat: 52, Lng: 4}, {Lat: 52.1, Lng: 4}}}
+	p := defaultFleetSimParams()
+	sim := runFleetSimulation(route, testFleetEvaluation(4000), p)
+	if sim.MinPods != 0 {
+		t.Errorf("smallest fleet %d for demand the headway cannot carry", sim.MinPods)
+	}
+}
+
+func TestFleetSimParamsFromQuery(t *testing.T) {
+
+	p, err := fleetSimParamsFromQuery(url.Values{"pods": {"12"}, "headway": {"45"}, "arrivals": {"poisson"}})
+	if err != nil || p.Pods != 12 || p.Headway != 45 || p.Arrivals != "poisson" {
+		t.Errorf("params %+v, error %v", p, err)
+	}
+	for _, q := range []string{"pods=0", "headway=-1", "headway=NaN", "max_wait=Inf", "arrivals=burst", "seed=x"} {
+		v, _ := url.ParseQuery(q)
+		if _, err := fleetSimParamsFromQuery(v); err == nil {
+			t.Errorf("%s: no error", q)
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// Same sphere as google.maps.geometry.spherical, so Go and the browser agree
+const earthRadiusM float64 = 6378137.0
+
+type LatLng struct {
+	Lat float64 `json:"lat"`
+	Lng float64 `json:"lng"`
+}
+
+func (s Segment) LatLng() LatLng {
+	return LatLng{s.Lat, s.Lng}
+}
+
+// Great circle distance in metres
+func computeDistanceBetween(from LatLng, to LatLng) float64 {
+
+	lat1 := degRad(from.Lat)
+	lat2 := degRad(to.Lat)
+	dLat := lat2 - lat1
+	dLng := degRad(to.Lng - from.Lng)
+
+	a := math.Pow(math.Sin(dLat/2), 2) + math.Cos(lat1)*math.Cos(lat2)*math.Pow(math.Sin(dLng/2), 2)
+
+	return 2 * math.Asin(math.Sqrt(a)) * earthRadiusM
+}
+
+// Initial heading from one point to another, degrees clockwise from north in [-180, 180)
+func computeHeading(from LatLng, to LatLng) float64 {
+
+	lat1 := degRad(from.Lat)
+	lat2 := degRad(to.Lat)
+	dLng := degRad(to.Lng - from.Lng)
+
+	y := math.Sin(dLng) * math.Cos(lat2)
+	x := math.Cos(lat1)*math.Sin(lat2) - math.Sin(lat1)*math.Cos(lat2)*math.Cos(dLng)
+
+	return wrapAngle(radDeg(math.Atan2(y, x)))
+}
+
+// Point reached travelling distance metres from a point along heading degrees
+func computeOffset(from LatLng, distance float64, heading float64) LatLng {
+
+	d := distance / earthRadiusM
+	h := degRad(heading)
+	lat1 := degRad(from.Lat)
+	lng1 := degRad(from.Lng)
+
+	lat2 := math.Asin(math.Sin(lat1)*math.Cos(d) + math.Cos(lat1)*math.Sin(d)*math.Cos(h))
+	lng2 := lng1 + math.Atan2(math.Sin(h)*math.Sin(d)*math.Cos(lat1), math.Cos(d)-math.Sin(lat1)*math.Sin(lat2))
+
+	return LatLng{radDeg(lat2), wrapAngle(radDeg(lng2))}
+}
+
+func degRad(d float64) float64 {
+	return d * math.Pi / 180
+}
+
+func radDeg(r float64) float64 {
+	return r * 180 / math.Pi
+}
+
+// Wraps an angle in degrees into [-180, 180)
+func wrapAngle(a float64) float64 {
+	a = math.Mod(a+180, 360)
+	if a < 0 {
+		a += 360
+	}
+	return a - 180
+}
+
+// How distances, headings and offsets are worked out on the earth
+type Geodesic interface {
+	Distance(from LatLng, to LatLng) float64
+	Heading(from LatLng, to LatLng) float64
+	Offset(from LatLng, distance float64, heading float64) LatLng
+}
+
+// Google's sphere, matching the browser
+type sphericalGeodesic struct{}
+
+// Vincenty's formulae on an ellipsoid
+type ellipsoidalGeodesic struct {
+	ellipsoid
+}
+
+var spherical Geodesic = sphericalGeodesic{}
+var ellipsoidal Geodesic = ellipsoidalGeodesic{wgs84}
+
+// Picks the model by name, ellipsoidal unless "spherical" is asked for
+func parseGeodesic(name string) (Geodesic, error) {
+	switch name {
+	case "", "ellipsoidal", "wgs84":
+		return ellipsoidal, nil
+	case "spherical":
+		return spherical, nil
+	}
+	return nil, fmt.Errorf("unknown geodesic %q, expected ellipsoidal or spherical", name)
+}
+
+func (sphericalGeodesic) Distance(from LatLng, to LatLng) float64 {
+	return computeDistanceBetween(from, to)
+}
+
+func (sphericalGeodesic) Heading(from LatLng, to LatLng) float64 {
+	return computeHeading(from, to)
+}
+
+func (sphericalGeodesic) Offset(from LatLng, distance float64, heading float64) LatLng {
+	return computeOffset(from, distance, heading)
+}
+
+func (g ellipsoidalGeodesic) Distance(from LatLng, to LatLng) float64 {
+	dist, _, ok := g.inverse(from, to)
+	if !ok {
+		return computeDistanceBetween(from, to)
+	}
+	return dist
+}
+
+func (g ellipsoidalGeodesic) Heading(from LatLng, to LatLng) float64 {
+	_, az, ok := g.inverse(from, to)
+	if !ok {
+		return computeHeading(from, to)
+	}
+	return az
+}
+
+// Vincenty inverse. Returns the distance in metres and the initial azimuth in degrees.
+// ok is false for nearly antipodal points where the iteration does not converge
+func (g ellipsoidalGeodesic) inverse(p1 LatLng, p2 LatLng) (float64, float64, bool) {
+
+	a, f := g.A, g.F
+	b := a * (1 - f)
+
+	L := degRad(p2.Lng - p1.Lng)
+	u1 := math.Atan((1 - f) * math.Tan(degRad(p1.Lat)))
+	u2 := math.Atan((1 - f) * math.Tan(degRad(p2.Lat)))
+	sinU1, cosU1 := math.Sin(u1), math.Cos(u1)
+	sinU2, cosU2 := math.Sin(u2), math.Cos(u2)
+
+	lam := L
+	var sinSigma, cosSigma, sigma, cosSqAlpha, cos2SigmaM, sinLam, cosLam float64
+
+	converged := false
+	for i := 0; i < 200; i++ {
+		sinLam, cosLam = math.Sin(lam), math.Cos(lam)
+		sinSigma = math.Hypot(cosU2*sinLam, cosU1*sinU2-sinU1*cosU2*cosLam)
+		if sinSigma == 0 {
+			return 0, 0, true // same point
+		}
+		cosSigma = sinU1*sinU2 + cosU1*cosU2*cosLam
+		sigma = math.Atan2(sinSigma, cosSigma)
+		sinAlpha := cosU1 * cosU2 * sinLam / sinSigma
+		cosSqAlpha = 1 - sinAlpha*sinAlpha
+		cos2SigmaM = 0
+		if cosSqAlpha != 0 {
+			cos2SigmaM = cosSigma - 2*sinU1*sinU2/cosSqAlpha
+		}
+		c := f / 16 * cosSqAlpha * (4 + f*(4-3*cosSqAlpha))
+		lamP := lam
+		lam = L + (1-c)*f*sinAlpha*(sigma+c*sinSigma*(cos2SigmaM+c*cosSigma*(-1+2*cos2SigmaM*cos2SigmaM)))
+		if math.Abs(lam-lamP) < 1e-12 {
+			converged = true
+			break
+		}
+	}
+	if !converged {
+		return 0, 0, false
+	}
+
+	uSq := cosSqAlpha * (a*a - b*b) / (b * b)
+	A := 1 + uSq/16384*(4096+uSq*(-768+uSq*(320-175*uSq)))
+	B := uSq / 1024 * (256 + uSq*(-128+uSq*(74-47*uSq)))
+	deltaSigma := B * sinSigma * (cos2SigmaM + B/4*(cosSigma*(-1+2*cos2SigmaM*cos2SigmaM)-
+		B/6*cos2SigmaM*(-3+4*sinSigma*sinSigma)*(-3+4*cos2SigmaM*cos2SigmaM)))
+
+	dist := b * A * (sigma - deltaSigma)
+	az := math.Atan2(cosU2*sinLam, cosU1*sinU2-sinU1*cosU2*cosLam)
+
+	return dist, wrapAngle(radDeg(az)), true
+}
+
+// Vincenty direct
+func (g ellipsoidalGeodesic) Offset(from LatLng, distance float64, heading float64) LatLng {
+
+	a, f := g.A, g.F
+	b := a * (1 - f)
+
+	alpha1 := degRad(heading)
+	sinAlpha1, cosAlpha1 := math.Sin(alpha1), math.Cos(alpha1)
+
+	tanU1 := (1 - f) * math.Tan(degRad(from.Lat))
+	cosU1 := 1 / math.Sqrt(1+tanU1*tanU1)
+	sinU1 := tanU1 * cosU1
+
+	sigma1 := math.Atan2(tanU1, cosAlpha1)
+	sinAlpha := cosU1 * sinAlpha1
+	cosSqAlpha := 1 - sinAlpha*sinAlpha
+	uSq := cosSqAlpha * (a*a - b*b) / (b * b)
+	A := 1 + uSq/16384*(4096+uSq*(-768+uSq*(320-175*uSq)))
+	B := uSq / 1024 * (256 + uSq*(-128+uSq*(74-47*uSq)))
+
+	sigma := distance / (b * A)
+	var sinSigma, cosSigma, cos2SigmaM float64
+	for i := 0; i < 200; i++ {
+		cos2SigmaM = math.Cos(2*sigma1 + sigma)
+		sinSigma, cosSigma = math.Sin(sigma), math.Cos(sigma)
+		deltaSigma := B * sinSigma * (cos2SigmaM + B/4*(cosSigma*(-1+2*cos2SigmaM*cos2SigmaM)-
+			B/6*cos2SigmaM*(-3+4*sinSigma*sinSigma)*(-3+4*cos2SigmaM*cos2SigmaM)))
+		sigmaP := sigma
+		sigma = distance/(b*A) + deltaSigma
+		if math.Abs(sigma-sigmaP) < 1e-12 {
+			break
+		}
+	}
+	cos2SigmaM = math.Cos(2*sigma1 + sigma)
+	sinSigma, cosSigma = math.Sin(sigma), math.Cos(sigma)
+
+	tmp := sinU1*sinSigma - cosU1*cosSigma*cosAlpha1
+	lat := math.Atan2(sinU1*cosSigma+cosU1*sinSigma*cosAlpha1, (1-f)*math.Hypot(sinAlpha, tmp))
+	lam := math.Atan2(sinSigma*sinAlpha1, cosU1*cosSigma-sinU1*sinSigma*cosAlpha1)
+	c := f / 16 * cosSqAlpha * (4 + f*(4-3*cosSqAlpha))
+	L := lam - (1-c)*f*sinAlpha*(sigma+c*sinSigma*(cos2SigmaM+c*cosSigma*(-1+2*cos2SigmaM*cos2SigmaM)))
+
+	return LatLng{radDeg(lat), wrapAngle(from.Lng + radDeg(L))}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func dms(d float64, m float64, s float64) float64 {
+	if d < 0 {
+		return d - m/60 - s/3600
+	}
+	return d + m/60 + s/3600
+}
+
+// Flinders Peak to Buninyong on GRS80, the example in Vincenty's paper as
+// worked by Geoscience Australia
+func TestVincentyFlindersPeak(t *testing.T) {
+
+	geo := ellipsoidalGeodesic{grs80}
+	flinders := LatLng{dms(-37, 57, 3.72030), dms(144, 25, 29.52440)}
+	buninyong := LatLng{dms(-37, 39, 10.15610), dms(143, 55, 35.38390)}
+
+	dist := geo.Distance(flinders, buninyong)
+	if math.Abs(dist-54972.271) > 0.001 {
+		t.Errorf("distance %.4f m, want 54972.271", dist)
+	}
+	az := geo.Heading(flinders, buninyong)
+	if want := wrapAngle(dms(306, 52, 5.37)); math.Abs(az-want) > 0.01/3600 {
+		t.Errorf("azimuth %.7f, want %.7f", az, want)
+	}
+
+	p := geo.Offset(flinders, 54972.271, dms(306, 52, 5.37))
+	if math.Abs(p.Lat-buninyong.Lat) > 1e-7 || math.Abs(p.Lng-buninyong.Lng) > 1e-7 {
+		t.Errorf("offset reaches %v, want %v", p, buninyong)
+	}
+}
+
+func TestGeodesics(t *testing.T) {
+
+	from := LatLng{52, 4}
+	for _, geo := range []Geodesic{ellipsoidal, spherical} {
+		for _, heading := range []float64{0, 45, 90, -135, 180} {
+			to := geo.Offset(from, 25000, heading)
+			if d := geo.Distance(from, to); math.Abs(d-25000) > 1e-3 {
+				t.Errorf("%T heading %v: %v m away, want 25000", geo, heading, d)
+			}
+			if h := geo.Heading(from, to); math.Abs(wrapAngle(h-heading)) > 1e-6 {
+				t.Errorf("%T: heading %v, want %v", geo, h, heading)
+			}
+		}
+		if d := geo.Distance(from, from); d != 0 {
+			t.Errorf("%T: %v m from a point to itself", geo, d)
+		}
+	}
+
+	// a degree of latitude is longer near the poles on the ellipsoid
+	eq := ellipsoidal.Distance(LatLng{0, 0}, LatLng{1, 0})
+	pole := ellipsoidal.Distance(LatLng{88, 0}, LatLng{89, 0})
+	if eq > 110600 || pole < 111600 {
+		t.Errorf("a degree of latitude is %v m at the equator and %v m near the pole", eq, pole)
+	}
+}
+
+func TestParseGeodesic(t *testing.T) {
+
+	for name, want := range map[string]Geodesic{"": ellipsoidal, "wgs84": ellipsoidal, "ellipsoidal": ellipsoidal, "spherical": spherical} {
+		if geo, err := parseGeodesic(name); err != nil || geo != want {
+			t.Errorf("%q: %T %v", name, geo, err)
+		}
+	}
+	if _, err := parseGeodesic("flat"); err == nil {
+		t.Error("no error for an unknown geodesic")
+	}
+}
@@ -0,0 +1,377 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// TIFF tags the DEM reader looks at
+const (
+	tiffImageWidth      = 256
+	tiffImageLength     = 257
+	tiffBitsPerSample   = 258
+	tiffCompression     = 259
+	tiffStripOffsets    = 273
+	tiffSamplesPerPixel = 277
+	tiffRowsPerStrip    = 278
+	tiffStripByteCounts = 279
+	tiffPredictor       = 317
+	tiffTileWidth       = 322
+	tiffTileLength      = 323
+	tiffTileOffsets     = 324
+	tiffTileByteCounts  = 325
+	tiffSampleFormat    = 339
+	geoPixelScale       = 33550
+	geoTiepoint         = 33922
+	geoKeyDirectory     = 34735
+	gdalNoData          = 42113
+)
+
+// GeoKeys and their values
+const (
+	geoKeyModelType       = 1024
+	geoKeyRasterType      = 1025
+	geoModelProjected     = 1
+	geoRasterPixelIsPoint = 2
+)
+
+type tiffEntry struct {
+	typ    uint16
+	count  uint32
+	offset []byte // the value itself when it fits in 4 bytes
+}
+
+type tiffReader struct {
+	raw     []byte
+	order   binary.ByteOrder
+	entries map[uint16]tiffEntry
+}
+
+var tiffTypeSize = map[uint16]int{1: 1, 2: 1, 3: 2, 4: 4, 6: 1, 7: 1, 8: 2, 9: 4, 11: 4, 12: 8}
+
+// Bytes of a tag's values
+func (r *tiffReader) value(tag uint16) ([]byte, bool, error) {
+
+	e, ok := r.entries[tag]
+	if !ok {
+		return nil, false, nil
+	}
+	size, ok := tiffTypeSize[e.typ]
+	if !ok {
+		return nil, true, fmt.Errorf("tag %d has unknown type %d", tag, e.typ)
+	}
+	n := int(e.count) * size
+	if n <= 4 {
+		return e.offset[:n], true, nil
+	}
+	off := int(r.order.Uint32(e.offset))
+	if off < 0 || off+n > len(r.raw) {
+		return nil, true, fmt.Errorf("tag %d runs off the end of the file", tag)
+	}
+	return r.raw[off : off+n], true, nil
+}
+
+// Integer values of a BYTE, SHORT or LONG tag
+func (r *tiffReader) ints(tag uint16) ([]int, error) {
+
+	b, ok, err := r.value(tag)
+	if err != nil || !ok {
+		return nil, err
+	}
+	var v []int
+	switch typ := r.entries[tag].typ; typ {
+	case 1:
+		for _, x := range b {
+			v = append(v, int(x))
+		}
+	case 3:
+		for i := 0; i < len(b); i += 2 {
+			v = append(v, int(r.order.Uint16(b[i:])))
+		}
+	case 4:
+		for i := 0; i < len(b); i += 4 {
+			v = append(v, int(r.order.Uint32(b[i:])))
+		}
+	default:
+		return nil, fmt.Errorf("tag %d has type %d, want an integer", tag, typ)
+	}
+	return v, nil
+}
+
+// The single value of an integer tag, def when it is left out
+func (r *tiffReader) int(tag uint16, def int) (int, error) {
+	v, err := r.ints(tag)
+	if err != nil || v == nil {
+		return def, err
+	}
+	return v[0], nil
+}
+
+func (r *tiffReader) doubles(tag uint16) ([]float64, error) {
+
+	b, ok, err := r.value(tag)
+	if err != nil || !ok {
+		return nil, err
+	}
+	if typ := r.entries[tag].typ; typ != 12 {
+		return nil, fmt.Errorf("tag %d has type %d, want double", tag, typ)
+	}
+	v := make([]float64, len(b)/8)
+	for i := range v {
+		v[i] = math.Float64frombits(r.order.Uint64(b[8*i:]))
+	}
+	return v, nil
+}
+
+// Reads the first image of a single band GeoTIFF in geographic coordinates,
+// int16 or float32 samples, uncompressed or Deflate, in strips or tiles
+func parseGeoTIFF(raw []byte) (*demTile, error) {
+
+	r := &tiffReader{raw: raw, entries: make(map[uint16]tiffEntry)}
+	switch {
+	case len(raw) < 8:
+		return nil, fmt.Errorf("too short for a TIFF")
+	case raw[0] == 'I' && raw[1] == 'I':
+		r.order = binary.LittleEndian
+	case raw[0] == 'M' && raw[1] == 'M':
+		r.order = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("not a TIFF")
+	}
+	if magic := r.order.Uint16(raw[2:]); magic != 42 {
+		if magic == 43 {
+			return nil, fmt.Errorf("BigTIFF is not supported")
+		}
+		return nil, fmt.Errorf("not a TIFF")
+	}
+
+	ifd := int(r.order.Uint32(raw[4:]))
+	if ifd < 8 || ifd+2 > len(raw) {
+		return nil, fmt.Errorf("bad IFD offset %d", ifd)
+	}
+	n := int(r.order.Uint16(raw[ifd:]))
+	if ifd+2+12*n > len(raw) {
+		return nil, fmt.Errorf("IFD runs off the end of the file")
+	}
+	for i := 0; i < n; i++ {
+		e := raw[ifd+2+12*i:]
+		r.entries[r.order.Uint16(e)] = tiffEntry{typ: r.order.Uint16(e[2:]), count: r.order.Uint32(e[4:]), offset: e[8:12]}
+	}
+
+	var width, height, bits, format, compression, predictor, spp int
+	for _, f := range []struct {
+		dst *int
+		tag uint16
+		def int
+	}{
+		{&width, tiffImageWidth, 0}, {&height, tiffImageLength, 0}, {&bits, tiffBitsPerSample, 1}, {&format, tiffSampleFormat, 1},
+		{&compression, tiffCompression, 1}, {&predictor, tiffPredictor, 1}, {&spp, tiffSamplesPerPixel, 1},
+	} {
+		v, err := r.int(f.tag, f.def)
+		if err != nil {
+			return nil, err
+		}
+		*f.dst = v
+	}
+
+	if width < 2 || height < 2 {
+		return nil, fmt.Errorf("image of %d by %d", width, height)
+	}
+	if spp != 1 {
+		return nil, fmt.Errorf("%d samples per pixel, want a single band", spp)
+	}
+	if !(bits == 16 && format == 2) && !(bits == 32 && format == 3) {
+		return nil, fmt.Errorf("%d bit samples of format %d, want int16 or float32", bits, format)
+	}
+	switch compression {
+	case 1, 8, 32946:
+	case 5:
+		return nil, fmt.Errorf("LZW compression is not supported, recompress with gdal_translate -co COMPRESS=DEFLATE")
+	default:
+		return nil, fmt.Errorf("compression %d is not supported", compression)
+	}
+	if predictor < 1 || predictor > 3 || (predictor == 3 && format != 3) || (predictor == 2 && format != 2) {
+		return nil, fmt.Errorf("predictor %d is not supported for these samples", predictor)
+	}
+
+	t := &demTile{rows: height, cols: width, void: math.NaN()}
+	if bits == 16 {
+		t.ints = make([]int16, width*height)
+	} else {
+		t.floats = make([]float32, width*height)
+	}
+
+	// strips are tiles as wide as the image
+	blockW, blockH := width, height
+	offsets, err := r.ints(tiffTileOffsets)
+	if err != nil {
+		return nil, err
+	}
+	counts, err := r.ints(tiffTileByteCounts)
+	if err != nil {
+		return nil, err
+	}
+	tiled := offsets != nil
+	if tiled {
+		if blockW, err = r.int(tiffTileWidth, 0); err != nil {
+			return nil, err
+		}
+		if blockH, err = r.int(tiffTileLength, 0); err != nil {
+			return nil, err
+		}
+	} else {
+		if offsets, err = r.ints(tiffStripOffsets); err != nil {
+			return nil, err
+		}
+		if counts, err = r.ints(tiffStripByteCounts); err != nil {
+			return nil, err
+		}
+		if blockH, err = r.int(tiffRowsPerStrip, height); err != nil {
+			return nil, err
+		}
+		if blockH > height {
+			blockH = height
+		}
+	}
+	if blockW < 1 || blockH < 1 {
+		return nil, fmt.Errorf("blocks of %d by %d", blockW, blockH)
+	}
+	across := (width + blockW - 1) / blockW
+	down := (height + blockH - 1) / blockH
+	if len(offsets) != across*down || len(counts) != len(offsets) {
+		return nil, fmt.Errorf("%d blocks with %d byte counts, want %d", len(offsets), len(counts), across*down)
+	}
+
+	size := bits / 8
+	for i, off := range offsets {
+		if off < 0 || counts[i] < 0 || off+counts[i] > len(raw) {
+			return nil, fmt.Errorf("block %d runs off the end of the file", i)
+		}
+		block := raw[off : off+counts[i]]
+		if compression != 1 {
+			zr, err := zlib.NewReader(bytes.NewReader(block))
+			if err != nil {
+				return nil, fmt.Errorf("block %d: %v", i, err)
+			}
+			if block, err = ioutil.ReadAll(zr); err != nil {
+				return nil, fmt.Errorf("block %d: %v", i, err)
+			}
+		}
+
+		// the last strip stops at the foot of the image, tiles are always whole
+		x0, y0 := (i%across)*blockW, (i/across)*blockH
+		rows := blockH
+		if !tiled && y0+rows > height {
+			rows = height - y0
+		}
+		if len(block) < rows*blockW*size {
+			return nil, fmt.Errorf("block %d has %d bytes, want %d", i, len(block), rows*blockW*size)
+		}
+
+		for y := 0; y < rows && y0+y < height; y++ {
+			line := block[y*blockW*size : (y+1)*blockW*size]
+			switch predictor {
+			case 2:
+				for x := 1; x < blockW; x++ {
+					r.order.PutUint16(line[2*x:], r.order.Uint16(line[2*x:])+r.order.Uint16(line[2*x-2:]))
+				}
+			case 3:
+				line = unshuffleFloats(line, blockW)
+			}
+			for x := 0; x < blockW && x0+x < width; x++ {
+				at := (y0+y)*width + x0 + x
+				switch {
+				case predictor == 3:
+					t.floats[at] = math.Float32frombits(binary.BigEndian.Uint32(line[4*x:]))
+				case bits == 16:
+					t.ints[at] = int16(r.order.Uint16(line[2*x:]))
+				default:
+					t.floats[at] = math.Float32frombits(r.order.Uint32(line[4*x:]))
+				}
+			}
+		}
+	}
+
+	if err := georeference(r, t); err != nil {
+		return nil, err
+	}
+
+	if b, ok, err := r.value(gdalNoData); err != nil {
+		return nil, err
+	} else if ok {
+		s := strings.TrimSpace(strings.TrimRight(string(b), "\x00"))
+		if t.void, err = strconv.ParseFloat(s, 64); err != nil {
+			return nil, fmt.Errorf("invalid nodata value %q", s)
+		}
+	}
+
+	return t, nil
+}
+
+// Undoes the floating point predictor on one row: differences between
+// neighbouring bytes, with the most significant bytes of the samples first
+func unshuffleFloats(line []byte, n int) []byte {
+
+	for i := 1; i < len(line); i++ {
+		line[i] += line[i-1]
+	}
+	out := make([]byte, len(line))
+	for x := 0; x < n; x++ {
+		for k := 0; k < 4; k++ {
+			out[4*x+k] = line[k*n+x]
+		}
+	}
+	return out
+}
+
+// Places the tile from its tie point and pixel size. Area pixels are centred
+// half a pixel in from the corner the tie point gives
+func georeference(r *tiffReader, t *demTile) error {
+
+	scale, err := r.doubles(geoPixelScale)
+	if err != nil {
+		return err
+	}
+	tie, err := r.doubles(geoTiepoint)
+	if err != nil {
+		return err
+	}
+	if len(scale) < 2 || len(tie) < 6 || scale[0] <= 0 || scale[1] <= 0 {
+		return fmt.Errorf("no tie point and pixel scale")
+	}
+
+	raster := 1
+	keys, err := r.ints(geoKeyDirectory)
+	if err != nil {
+		return err
+	}
+	for i := 4; i+3 < len(keys); i += 4 {
+		// only keys held in the directory itself
+		if keys[i+1] != 0 {
+			continue
+		}
+		switch keys[i] {
+		case geoKeyModelType:
+			if keys[i+3] == geoModelProjected {
+				return fmt.Errorf("projected coordinates, want latitude and longitude")
+			}
+		case geoKeyRasterType:
+			raster = keys[i+3]
+		}
+	}
+
+	t.dLng, t.dLat = scale[0], scale[1]
+	t.west = tie[3] - tie[0]*t.dLng
+	t.north = tie[4] + tie[1]*t.dLat
+	if raster != geoRasterPixelIsPoint {
+		t.west += t.dLng / 2
+		t.north -= t.dLat / 2
+	}
+	return nil
+}
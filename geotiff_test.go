@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+// A single band GeoTIFF for the reader to take apart
+type testTIFF struct {
+	order        binary.ByteOrder
+	width        int
+	height       int
+	ints         []int16 // or floats
+	floats       []float32
+	compression  int
+	predictor    int
+	tileW        int // strips of rowsPerStrip when 0
+	tileH        int
+	rowsPerStrip int
+	point        bool // PixelIsPoint rather than PixelIsArea
+	modelType    int
+	tie          []float64
+	scale        []float64
+	nodata       string
+}
+
+type testTIFFEntry struct {
+	tag   uint16
+	typ   uint16
+	count int
+	value []byte
+}
+
+func (tt testTIFF) encode() []byte {
+
+	o := tt.order
+	var b bytes.Buffer
+	if o == binary.ByteOrder(binary.BigEndian) {
+		b.WriteString("MM\x00\x2a\x00\x00\x00\x00")
+	} else {
+		b.WriteString("II\x2a\x00\x00\x00\x00\x00")
+	}
+
+	size := 2
+	if tt.floats != nil {
+		size = 4
+	}
+	blockW, blockH := tt.width, tt.rowsPerStrip
+	if tt.tileW > 0 {
+		blockW, blockH = tt.tileW, tt.tileH
+	}
+	across := (tt.width + blockW - 1) / blockW
+	down := (tt.height + blockH - 1) / blockH
+
+	var offsets, counts []int
+	for by := 0; by < down; by++ {
+		for bx := 0; bx < across; bx++ {
+			var block []byte
+			for y := by * blockH; y < (by+1)*blockH; y++ {
+				if y >= tt.height && tt.tileW == 0 {
+					break
+				}
+				line := make([]byte, blockW*size)
+				for x := 0; x < blockW; x++ {
+					ix, iy := bx*blockW+x, y
+					if ix >= tt.width || iy >= tt.height {
+						continue
+					}
+					if tt.ints != nil {
+						o.PutUint16(line[2*x:], uint16(tt.ints[iy*tt.width+ix]))
+					} else if tt.predictor == 3 {
+						binary.BigEndian.PutUint32(line[4*x:], math.Float32bits(tt.floats[iy*tt.width+ix]))
+					} else {
+						o.PutUint32(line[4*x:], math.Float32bits(tt.floats[iy*tt.width+ix]))
+					}
+				}
+				switch tt.predictor {
+				case 2:
+					for x := blockW - 1; x > 0; x-- {
+						o.PutUint16(line[2*x:], o.Uint16(line[2*x:])-o.Uint16(line[2*x-2:]))
+					}
+				case 3:
+					shuffled := make([]byte, len(line))
+					for x := 0; x < blockW; x++ {
+						for k := 0; k < 4; k++ {
+							shuffled[k*blockW+x] = line[4*x+k]
+						}
+					}
+					for i := len(shuffled) - 1; i > 0; i-- {
+						shuffled[i] -= shuffled[i-1]
+					}
+					line = shuffled
+				}
+				block = append(block, line...)
+			}
+			if tt.compression == 8 {
+				var z bytes.Buffer
+				zw := zlib.NewWriter(&z)
+				zw.Write(block)
+				zw.Close()
+				block = z.Bytes()
+			}
+			offsets = append(offsets, b.Len())
+			counts = append(counts, len(block))
+			b.Write(block)
+		}
+	}
+
+	short := func(tag uint16, v ...int) testTIFFEntry {
+		raw := make([]byte, 2*len(v))
+		for i, x := range v {
+			o.PutUint16(raw[2*i:], uint16(x))
+		}
+		return testTIFFEntry{tag, 3, len(v), raw}
+	}
+	long := func(tag uint16, v ...int) testTIFFEntry {
+		raw := make([]byte, 4*len(v))
+		for i, x := range v {
+			o.PutUint32(raw[4*i:], uint32(x))
+		}
+		return testTIFFEntry{tag, 4, len(v), raw}
+	}
+	double := func(tag uint16, v ...float64) testTIFFEntry {
+		raw := make([]byte, 8*len(v))
+		for i, x := range v {
+			o.PutUint64(raw[8*i:], math.Float64bits(x))
+		}
+		return testTIFFEntry{tag, 12, len(v), raw}
+	}
+
+	format := 2
+	if tt.floats != nil {
+		format = 3
+	}
+	raster := 1
+	if tt.point {
+		raster = 2
+	}
+	modelType := tt.modelType
+	if modelType == 0 {
+		modelType = 2
+	}
+	entries := []testTIFFEntry{
+		long(tiffImageWidth, tt.width), long(tiffImageLength, tt.height), short(tiffBitsPerSample, 8*size),
+		short(tiffCompression, tt.compression), short(tiffSamplesPerPixel, 1), short(tiffPredictor, tt.predictor),
+		short(tiffSampleFormat, format), double(geoPixelScale, tt.scale...), double(geoTiepoint, tt.tie...),
+		short(geoKeyDirectory, 1, 1, 0, 2, geoKeyModelType, 0, 1, modelType, geoKeyRasterType, 0, 1, raster),
+	}
+	if tt.tileW > 0 {
+		entries = append(entries, short(tiffTileWidth, tt.tileW), short(tiffTileLength, tt.tileH),
+			long(tiffTileOffsets, offsets...), long(tiffTileByteCounts, counts...))
+	} else {
+		entries = append(entries, short(tiffRowsPerStrip, tt.rowsPerStrip), long(tiffStripOffsets, offsets...), long(tiffStripByteCounts, counts...))
+	}
+	if tt.nodata != "" {
+		entries = append(entries, testTIFFEntry{gdalNoData, 2, len(tt.nodata) + 1, append([]byte(tt.nodata), 0)})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].tag < entries[j].tag })
+
+	// values that do not fit in an entry go before the IFD
+	for i, e := range entries {
+		if len(e.value) > 4 {
+			off := make([]byte, 4)
+			o.PutUint32(off, uint32(b.Len()))
+			b.Write(e.value)
+			entries[i].value = off
+		}
+	}
+	if b.Len()%2 == 1 {
+		b.WriteByte(0)
+	}
+	ifd := b.Len()
+	num := make([]byte, 2)
+	o.PutUint16(num, uint16(len(entries)))
+	b.Write(num)
+	for _, e := range entries {
+		raw := make([]byte, 12)
+		o.PutUint16(raw, e.tag)
+		o.PutUint16(raw[2:], e.typ)
+		o.PutUint32(raw[4:], uint32(e.count))
+		copy(raw[8:], e.value)
+		b.Write(raw)
+	}
+	b.Write([]byte{0, 0, 0, 0})
+
+	out := b.Bytes()
+	o.PutUint32(out[4:], uint32(ifd))
+	return out
+}
+
+// The 3 by 3 grid of TestHGTElevation, posts half a degree apart
+var testTIFFInts = []int16{
+	20, 40, hgtVoid,
+	10, 30, 50,
+	0, 20, 40,
+}
+
+func testTIFFFloats() []float32 {
+	floats := make([]float32, len(testTIFFInts))
+	for i, v := range testTIFFInts {
+		floats[i] = float32(v)
+		if v == hgtVoid {
+			floats[i] = float32(math.NaN())
+		}
+	}
+	return floats
+}
+
+func TestGeoTIFFElevation(t *testing.T) {
+
+	tests := []struct {
+		name string
+		tiff testTIFF
+	}{
+		{"N52E004.tif", testTIFF{order: binary.BigEndian, ints: testTIFFInts, compression: 1, predictor: 1, rowsPerStrip: 2,
+			point: true, tie: []float64{0, 0, 0, 4, 53, 0}, scale: []float64{0.5, 0.5, 0}, nodata: "-32768"}},
+		{"Copernicus_DSM_COG_10_N52_00_E004_00_DEM.tif", testTIFF{order: binary.LittleEndian, floats: testTIFFFloats(), compression: 8, predictor: 3,
+			tileW: 2, tileH: 2, tie: []float64{0, 0, 0, 3.75, 53.25, 0}, scale: []float64{0.5, 0.5, 0}}},
+		{"N52E004.tif", testTIFF{order: binary.LittleEndian, ints: testTIFFInts, compression: 8, predictor: 2,
+			tileW: 2, tileH: 2, tie: []float64{1, 1, 0, 4.25, 52.75, 0}, scale: []float64{0.5, 0.5, 0}, nodata: "-32768"}},
+		{"N52E004.tif", testTIFF{order: binary.LittleEndian, floats: testTIFFFloats(), compression: 1, predictor: 1, rowsPerStrip: 3,
+			point: true, tie: []float64{0, 0, 0, 4, 53, 0}, scale: []float64{0.5, 0.5, 0}}},
+	}
+
+	for i, tt := range tests {
+		dir, err := ioutil.TempDir("", "geotiff")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		tt.tiff.width, tt.tiff.height = 3, 3
+		if err := ioutil.WriteFile(filepath.Join(dir, tt.name), tt.tiff.encode(), 0644); err != nil {
+			t.Fatal(err)
+		}
+		h := newDEMProvider(dir)
+
+		for _, p := range []struct {
+			p    LatLng
+			want float64
+		}{
+			{LatLng{52, 4}, 0},
+			{LatLng{52, 4.75}, 30},
+			{LatLng{52.5, 4.5}, 30},
+			{LatLng{52.25, 4.25}, 15},
+			{LatLng{52.75, 4.75}, 40},
+		} {
+			got, err := h.Elevation(p.p)
+			if err != nil || math.Abs(got-p.want) > 1e-9 {
+				t.Errorf("tile %d %s, %v: %v %v, want %v", i, tt.name, p.p, got, err, p.want)
+			}
+		}
+	}
+}
+
+func TestGeoTIFFRejects(t *testing.T) {
+
+	good := testTIFF{order: binary.LittleEndian, width: 3, height: 3, ints: testTIFFInts, compression: 1, predictor: 1, rowsPerStrip: 3,
+		tie: []float64{0, 0, 0, 4, 53, 0}, scale: []float64{0.5, 0.5, 0}}
+	if _, err := parseGeoTIFF(good.encode()); err != nil {
+		t.Fatal(err)
+	}
+
+	lzw, projected, noScale := good, good, good
+	lzw.compression = 5
+	projected.modelType = geoModelProjected
+	noScale.scale = []float64{0, 0, 0}
+	for name, raw := range map[string][]byte{
+		"LZW":       lzw.encode(),
+		"projected": projected.encode(),
+		"no scale":  noScale.encode(),
+		"not TIFF":  []byte("GIF89a and more"),
+		"cut short": good.encode()[:40],
+	} {
+		if _, err := parseGeoTIFF(raw); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+)
+
+type landXML struct {
+	XMLName          xml.Name           `xml:"LandXML"`
+	Xmlns            string             `xml:"xmlns,attr"`
+	Version          string             `xml:"version,attr"`
+	Date             string             `xml:"date,attr"`
+	Time             string             `xml:"time,attr"`
+	Units            landXMLUnits       `xml:"Units"`
+	CoordinateSystem landXMLCoordSystem `xml:"CoordinateSystem"`
+	Application      landXMLApplication `xml:"Application"`
+	Alignments       landXMLAlignments  `xml:"Alignments"`
+}
+
+type landXMLUnits struct {
+	Metric struct {
+		LinearUnit    string `xml:"linearUnit,attr"`
+		AreaUnit      string `xml:"areaUnit,attr"`
+		VolumeUnit    string `xml:"volumeUnit,attr"`
+		AngularUnit   string `xml:"angularUnit,attr"`
+		DirectionUnit string `xml:"directionUnit,attr"`
+	} `xml:"Metric"`
+}
+
+type landXMLCoordSystem struct {
+	Name     string `xml:"name,attr"`
+	EpsgCode int    `xml:"epsgCode,attr"`
+}
+
+type landXMLApplication struct {
+	Name         string `xml:"name,attr"`
+	Manufacturer string `xml:"manufacturer,attr"`
+}
+
+type landXMLAlignments struct {
+	Name      string             `xml:"name,attr"`
+	Alignment []landXMLAlignment `xml:"Alignment"`
+}
+
+type landXMLAlignment struct {
+	Name      string         `xml:"name,attr"`
+	Length    float64        `xml:"length,attr"`
+	StaStart  float64        `xml:"staStart,attr"`
+	CoordGeom landXMLCoord   `xml:"CoordGeom"`
+	Feature   landXMLFeature `xml:"Feature"`
+}
+
+type landXMLFeature struct {
+	Name     string            `xml:"name,attr"`
+	Property []landXMLProperty `xml:"Property"`
+}
+
+type landXMLProperty struct {
+	Label string `xml:"label,attr"`
+	Value string `xml:"value,attr"`
+}
+
+// Lines and curves in alignment order
+type landXMLCoord struct {
+	Items []interface{}
+}
+
+type landXMLLine struct {
+	XMLName  xml.Name `xml:"Line"`
+	StaStart float64  `xml:"staStart,attr"`
+	Length   float64  `xml:"length,attr"`
+	Start    string   `xml:"Start"`
+	End      string   `xml:"End"`
+}
+
+type landXMLCurve struct {
+	XMLName  xml.Name `xml:"Curve"`
+	Rot      string   `xml:"rot,attr"`
+	CrvType  string   `xml:"crvType,attr"`
+	StaStart float64  `xml:"staStart,attr"`
+	Length   float64  `xml:"length,attr"`
+	Radius   float64  `xml:"radius,attr"`
+	Delta    float64  `xml:"delta,attr"`
+	Start    string   `xml:"Start"`
+	Center   string   `xml:"Center"`
+	End      string   `xml:"End"`
+}
+
+type landXMLSpiral struct {
+	XMLName     xml.Name `xml:"Spiral"`
+	Rot         string   `xml:"rot,attr"`
+	SpiType     string   `xml:"spiType,attr"`
+	StaStart    float64  `xml:"staStart,attr"`
+	Length      float64  `xml:"length,attr"`
+	RadiusStart string   `xml:"radiusStart,attr"`
+	RadiusEnd   string   `xml:"radiusEnd,attr"`
+	Theta       float64  `xml:"theta,attr"`
+	Start       string   `xml:"Start"`
+	PI          string   `xml:"PI"`
+	End         string   `xml:"End"`
+}
+
+func (c landXMLCoord) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	if err := e.EncodeToken(start); err != nil {
+		return err
+	}
+	for _, item := range c.Items {
+		if err := e.Encode(item); err != nil {
+			return err
+		}
+	}
+	return e.EncodeToken(start.End())
+}
+
+// GET /routes/{id}/landxml?crs=EPSG:32633&geodesic=ellipsoidal
+// Exports the horizontal alignment as LandXML 1.2 in UTM or EPSG:3035. Without a crs
+// the UTM zone of the first vertex is used
+func landXMLHandler(w http.ResponseWriter, r *http.Request, route Route) {
+
+	if len(route.Segments) < 2 {
+		http.Error(w, "route needs at least two points", http.StatusBadRequest)
+		return
+	}
+
+	proj, err := parseCRS(r.URL.Query().Get("crs"), route.Segments[0].LatLng())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	geo, err := requestGeodesic(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	doc := makeLandXML(route, proj, geo, time.Now())
+
+	out, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", exportFileName(route, "xml")))
+	w.Write([]byte(xml.Header))
+	w.Write(out)
+}
+
+// Builds the LandXML document. Lengths and radii are measured on the projected grid
+// so the geometry closes in CAD; stations run from 0 at the first vertex. The
+// distortion against the ground is attached as a Feature
+func makeLandXML(route Route, proj Projection, geo Geodesic, now time.Time) landXML {
+
+	al := makeAlignment(route, geo)
+
+	name := route.Name
+	if name == "" {
+		name = "Route"
+	}
+
+	doc := landXML{
+		Xmlns:            "http://www.landxml.org/schema/LandXML-1.2",
+		Version:          "1.2",
+		Date:             now.Format("2006-01-02"),
+		Time:             now.Format("15:04:05"),
+		CoordinateSystem: landXMLCoordSystem{proj.Name(), proj.EPSG()},
+		Application:      landXMLApplication{"euroloop-route", "Euroloop"},
+		Alignments:       landXMLAlignments{Name: name},
+	}
+	doc.Units.Metric.LinearUnit = "meter"
+	doc.Units.Metric.AreaUnit = "squareMeter"
+	doc.Units.Metric.VolumeUnit = "cubicMeter"
+	doc.Units.Metric.AngularUnit = "decimal degrees"
+	doc.Units.Metric.DirectionUnit = "decimal degrees"
+
+	var coord landXMLCoord
+	station := 0.0
+
+	for _, el := range al.Elements {
+		sx, sy := proj.Forward(el.Start)
+		ex, ey := proj.Forward(el.End)
+
+		if el.Kind == "spiral" {
+			// the grid scale taken over the spiral applies to its radius too
+			length := gridLength(el, proj)
+			radius := fmt.Sprintf("%.3f", el.Radius*length/el.Length)
+			pi := el.geo.Offset(el.End, spiralLongTangent(el.Radius, el.Length), el.Heading)
+			rs, re := "INF", radius
+			if el.Entry {
+				pi = el.geo.Offset(el.Start, spiralLongTangent(el.Radius, el.Length), el.Heading)
+			} else {
+				rs, re = radius, "INF"
+			}
+			px, py := proj.Forward(pi)
+
+			rot := "cw"
+			if el.Angle < 0 {
+				rot = "ccw"
+			}
+
+			coord.Items = append(coord.Items, landXMLSpiral{
+				Rot:         rot,
+				SpiType:     "clothoid",
+				StaStart:    round3(station),
+				Length:      round3(length),
+				RadiusStart: rs,
+				RadiusEnd:   re,
+				Theta:       round6(math.Abs(el.Angle)),
+				Start:       landXMLPoint(sx, sy),
+				PI:          landXMLPoint(px, py),
+				End:         landXMLPoint(ex, ey),
+			})
+			station += length
+			continue
+		}
+
+		if el.Kind == "arc" {
+			cx, cy := proj.Forward(el.Center)
+			radius := (math.Hypot(sx-cx, sy-cy) + math.Hypot(ex-cx, ey-cy)) / 2
+			delta := math.Abs(el.Angle)
+			length := radius * degRad(delta)
+			if delta == 0 {
+				continue // all spiral
+			}
+
+			rot := "cw"
+			if el.Angle < 0 {
+				rot = "ccw"
+			}
+
+			coord.Items = append(coord.Items, landXMLCurve{
+				Rot:      rot,
+				CrvType:  "arc",
+				StaStart: round3(station),
+				Length:   round3(length),
+				Radius:   round3(radius),
+				Delta:    round6(delta),
+				Start:    landXMLPoint(sx, sy),
+				Center:   landXMLPoint(cx, cy),
+				End:      landXMLPoint(ex, ey),
+			})
+			station += length
+			continue
+		}
+
+		length := math.Hypot(ex-sx, ey-sy)
+		if length == 0 {
+			continue
+		}
+		coord.Items = append(coord.Items, landXMLLine{
+			StaStart: round3(station),
+			Length:   round3(length),
+			Start:    landXMLPoint(sx, sy),
+			End:      landXMLPoint(ex, ey),
+		})
+		station += length
+	}
+
+	dist := measureDistortion(al, proj)
+
+	doc.Alignments.Alignment = []landXMLAlignment{{
+		Name:      name,
+		Length:    round3(station),
+		StaStart:  0,
+		CoordGeom: coord,
+		Feature: landXMLFeature{
+			Name: "distortion",
+			Property: []landXMLProperty{
+				{"groundLength", fmt.Sprintf("%.3f", dist.GroundLength)},
+				{"gridLength", fmt.Sprintf("%.3f", station)},
+				{"minScale", fmt.Sprintf("%.8f", dist.MinScale)},
+				{"maxScale", fmt.Sprintf("%.8f", dist.MaxScale)},
+				{"maxAngularDistortion", fmt.Sprintf("%.6f", dist.MaxAngular)},
+			},
+		},
+	}}
+
+	return doc
+}
+
+// LandXML points are written northing first
+func landXMLPoint(x float64, y float64) string {
+	return fmt.Sprintf("%.4f %.4f", y, x)
+}
+
+func round3(v float64) float64 {
+	return math.Round(v*1000) / 1000
+}
+
+func round6(v float64) float64 {
+	return math.Round(v*1e6) / 1e6
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/xml"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMakeLandXML(t *testing.T) {
+
+	route := Route{Name: "Corner", Segments: []Segment{{Lat: 52, Lng: 4}, {Lat: 52.1, Lng: 4, Rad: 2000}, {Lat: 52.1, Lng: 4.15}}}
+	proj := utmZoneFor(route.Segments[0].LatLng())
+	doc := makeLandXML(route, proj, ellipsoidal, time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC))
+
+	if doc.CoordinateSystem.EpsgCode != 32631 || doc.Date != "2020-01-02" || doc.Time != "03:04:05" {
+		t.Errorf("coordinate system %v at %s %s", doc.CoordinateSystem, doc.Date, doc.Time)
+	}
+
+	al := doc.Alignments.Alignment[0]
+	items := al.CoordGeom.Items
+	if len(items) != 3 {
+		t.Fatalf("%d items, want a line, a curve and a line", len(items))
+	}
+	line1, ok1 := items[0].(landXMLLine)
+	curve, ok2 := items[1].(landXMLCurve)
+	line2, ok3 := items[2].(landXMLLine)
+	if !ok1 || !ok2 || !ok3 {
+		t.Fatalf("items %T %T %T", items[0], items[1], items[2])
+	}
+
+	// the elements join up and the stations run on without a gap
+	if line1.End != curve.Start || curve.End != line2.Start {
+		t.Errorf("ends %s/%s and %s/%s do not meet", line1.End, curve.Start, curve.End, line2.Start)
+	}
+	if math.Abs(curve.StaStart-(line1.StaStart+line1.Length)) > 0.002 || math.Abs(line2.StaStart-(curve.StaStart+curve.Length)) > 0.002 {
+		t.Errorf("stations %v %v %v", line1.StaStart, curve.StaStart, line2.StaStart)
+	}
+	if math.Abs(al.Length-(line2.StaStart+line2.Length)) > 0.002 {
+		t.Errorf("alignment length %v, the last line ends at %v", al.Length, line2.StaStart+line2.Length)
+	}
+
+	// turning from north to east is clockwise, the grid radius is near the
+	// ground radius
+	if curve.Rot != "cw" || math.Abs(curve.Radius-2000) > 2 || math.Abs(curve.Delta-90) > 1 {
+		t.Errorf("curve %s radius %v delta %v", curve.Rot, curve.Radius, curve.Delta)
+	}
+
+	out, err := xml.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tag := range []string{"<LandXML ", "<CoordGeom><Line ", "<Curve rot=\"cw\"", "<Feature name=\"distortion\">"} {
+		if !strings.Contains(string(out), tag) {
+			t.Errorf("no %s in %s", tag, out)
+		}
+	}
+}
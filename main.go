@@ -19,8 +19,12 @@ import (
 )
 
 type Route struct {
+	ID       int       `json:"id,omitempty"`
 	Name     string    `json:"name"`
 	Segments []Segment `json:"coords"`
+	Stations []Station `json:"stations,omitempty"`
+	MaxJerk  float64   `json:"max_jerk,omitempty"`  // m/s3, sets the clothoids of vertices with no spiral
+	AutoCant bool      `json:"auto_cant,omitempty"` // bank curves with no cant for the pod's top speed
 }
 
 type RouteName struct {
@@ -33,25 +37,38 @@ type RouteNames struct {
 }
 
 type Segment struct {
-	Lat float64 `json:"lat"`
-	Lng float64 `json:"lng"`
-	Rad float64 `json:"rad"`
+	Lat    float64 `json:"lat"`
+	Lng    float64 `json:"lng"`
+	Rad    float64 `json:"rad"`
+	Spiral float64 `json:"spiral,omitempty"` // m of clothoid either side of the arc
+	Cant   float64 `json:"cant,omitempty"`   // degrees the tube is banked through the curve
+	Type   string  `json:"type,omitempty"`   // construction of the leg to the next vertex, see constructionCosts
 }
 
 type RouteData struct {
-	Length      float64 `json:"length"`
-	Velocity    float64 `json:"velocity"`
-	TravelTime  float64 `json:"travel_time"`
-	Throughput  float64 `json:"throughput"`
-	Diameter    float64 `json:"diameter"`
-	LoadingTime float64 `json:"loadingtime"`
+	Length      float64     `json:"length"`
+	Velocity    float64     `json:"velocity"`
+	TravelTime  float64     `json:"travel_time"`
+	Throughput  float64     `json:"throughput"`
+	Diameter    float64     `json:"diameter"`
+	LoadingTime float64     `json:"loadingtime"`
+	Segments    []Segment   `json:"coords,omitempty"`         // when sent, the length is measured here instead
+	Geodesic    string      `json:"geodesic,omitempty"`       // "ellipsoidal" (default) or "spherical"
+	Demand      string      `json:"demand_profile,omitempty"` // named profile or 24 hourly weights, uniform when left out
+	Pod         int         `json:"pod,omitempty"`            // preset 1 to 5 as in route.js
+	Service     ServiceMode `json:"service"`                  // what throughput counts, TEU when left out
 }
 
 type Response struct {
-	Nrpods           int `json:"nrpods"`
-	Capex            int `json:"capex"`
-	Opex             int `json:"opex"`
-	PowerConsumption int `json:"powerconsumption"`
+	Nrpods           int      `json:"nrpods"`
+	Capex            int      `json:"capex"`
+	Opex             int      `json:"opex"`
+	PowerConsumption int      `json:"powerconsumption"`
+	Length           int      `json:"length"`
+	AveragePods      float64  `json:"averagepods"` // Nrpods is enough for the peak hour
+	IdlePods         float64  `json:"idlepods"`
+	MaxThroughput    int      `json:"maxthroughput"` // units a day the line can carry
+	Warnings         []string `json:"warnings,omitempty"`
 }
 
 type pingResponse struct {
@@ -62,9 +79,12 @@ type pingResponse struct {
 var tubeSegmentCost float64 = 28300.0
 var tubeJointCost float64 = 8700.0
 var tubeSegmentLength float64 = 12.0
-var pylonCost float64 = 16800.0
+var pylonCost float64 = 16800.0 // on flat ground, see pylonCostBands for the rest
 var pylonSpacingM float64 = 20.0
 
+var energyCostPerKWh float64 = 0.10 // €
+var maintenanceRate float64 = 0.01  // share of capex per year
+
 var db *sql.DB
 
 func main() {
@@ -76,6 +96,16 @@ func main() {
 	db, err = sql.Open("postgres", connStr)
 	checkErr(err)
 
+	demDir := os.Getenv("DEM_DIR")
+	if demDir == "" {
+		demDir = "dem"
+	}
+	dem = newDEMProvider(demDir)
+
+	if f := os.Getenv("PYLON_COSTS"); f != "" {
+		checkErr(loadPylonConfig(f))
+	}
+
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8080"
@@ -90,6 +120,14 @@ func main() {
 	mux.HandleFunc("/saveroute", saveRoute)
 	mux.HandleFunc("/loadroute", loadRoute)
 	mux.HandleFunc("/getroutenames", getRouteNames)
+	mux.HandleFunc("/routes/", routesHandler)
+	mux.HandleFunc("/savenetwork", saveNetwork)
+	mux.HandleFunc("/networks/", networksHandler)
+	mux.HandleFunc("/searchroute", searchRouteHandler)
+	mux.HandleFunc("/projectroute", projectRouteHandler)
+	mux.HandleFunc("/unprojectroute", unprojectRouteHandler)
+	mux.HandleFunc("/evaluations.csv", evaluationsHandler)
+	mux.HandleFunc("/evaluations.xlsx", evaluationsHandler)
 	mux.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("static"))))
 
 	handler := cors.Default().Handler(mux)
@@ -183,10 +221,54 @@ func requestHandler(w http.ResponseWriter, r *http.Request) {
 
 	_ = json.Unmarshal(body, &data)
 
+	profile, err := parseDemandProfile(data.Demand)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if data.Pod < 0 || data.Pod > len(podPresets) {
+		http.Error(w, fmt.Sprintf("pod must be 1 to %d", len(podPresets)), http.StatusBadRequest)
+		return
+	}
+	pod := podPresets[0]
+	if data.Pod > 0 {
+		pod = podPresets[data.Pod-1]
+	}
+	if data.Velocity > 0 {
+		pod.MaxSpeed = data.Velocity / 3.6
+	}
+	service, err := data.Service.complete(pod)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	capacity := cruiseCapacity(pod, service, data.Throughput)
+
+	if len(data.Segments) > 1 {
+		geo, err := parseGeodesic(data.Geodesic)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		route := Route{Segments: data.Segments}
+		al := makeAlignment(route, geo)
+		data.Length = al.Length
+		capacity = lineCapacity(pod, service, data.Throughput, map[string]SpeedProfile{
+			"out":    simulateSpeed(al, pod, nil),
+			"return": simulateSpeed(makeAlignment(reverseRoute(route), geo), pod, nil),
+		})
+	}
+
 	capex := calcCapex(data.Length)
-	nrPods := calcNumberOfPods(data.TravelTime, data.Throughput, data.LoadingTime)
+	fleet := demandFleet(profile, data.TravelTime, service.podTrips(data.Throughput, pod), data.LoadingTime)
 
-	resp, _ := json.Marshal(Response{nrPods, capex, 0, 0})
+	var warnings []string
+	if capacity.Exceeded {
+		warnings = append(warnings, capacity.Warning())
+	}
+
+	resp, _ := json.Marshal(Response{fleet.PeakPods, capex, 0, 0, int(data.Length), fleet.AveragePods, fleet.IdlePods, int(capacity.MaxThroughput), warnings})
 	w.Write(resp)
 }
 
@@ -233,12 +315,26 @@ func calcNumberOfPods(traveltime float64, throughput float64, loadingtime float6
 }
 
 func calcCapex(length float64) int {
+	return int(calcCapexBreakdown(length, nil, nil, nil).Total)
+}
+
+// legs give the construction type along the route, nil for all elevated. vp is
+// the tube grade line, nil to take the ground as flat
+func calcCapexBreakdown(length float64, legs []Leg, vp *VerticalProfile, stations []Station) CapexBreakdown {
 
-	tubeSegments := math.Ceil(length/tubeSegmentLength) * 2
-	tubeCost := tubeSegments * (tubeSegmentCost + tubeJointCost)
-	pylonCostTotal := pylonCost * math.Ceil(length/pylonSpacingM)
+	var c CapexBreakdown
+
+	c.TubeSegments = int(math.Ceil(length/tubeSegmentLength) * 2)
+	c.TubeSegmentCost = float64(c.TubeSegments) * tubeSegmentCost
+	c.TubeJointCost = float64(c.TubeSegments) * tubeJointCost
+	calcCivilCapex(&c, length, legs, vp)
+	for _, s := range stations {
+		c.Stations++
+		c.StationCost += s.capex()
+	}
+	c.Total = c.TubeSegmentCost + c.TubeJointCost + c.PylonCost + c.CivilCost + c.StationCost
 
-	return int(tubeCost + pylonCostTotal)
+	return c
 }
 
 func checkErr(err error) {
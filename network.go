@@ -0,0 +1,424 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// How far the end of an edge's geometry may be from its node, m
+var nodeTolerance float64 = 50.0
+
+const (
+	nodeStation  = "station"
+	nodeJunction = "junction"
+)
+
+// A station or a junction where edges meet
+type NetworkNode struct {
+	ID     string  `json:"id"`
+	Kind   string  `json:"kind"` // "station" or "junction"
+	Name   string  `json:"name,omitempty"`
+	Lat    float64 `json:"lat"`
+	Lng    float64 `json:"lng"`
+	Rad    float64 `json:"rad,omitempty"`   // m, curve through a junction
+	Dwell  float64 `json:"dwell,omitempty"` // s, stations only
+	Berths int     `json:"berths,omitempty"`
+	Cost   float64 `json:"cost,omitempty"`
+}
+
+// A stretch of tube between two nodes. The geometry is either a saved route or
+// given in coords, and runs From to To
+type NetworkEdge struct {
+	ID       string    `json:"id"`
+	From     string    `json:"from"`
+	To       string    `json:"to"`
+	Route    int       `json:"route,omitempty"` // id of a saved route
+	Segments []Segment `json:"coords,omitempty"`
+}
+
+// A service over a chain of edges, in order. Edges may be run either way
+type NetworkLine struct {
+	Name  string   `json:"name"`
+	Edges []string `json:"edges"`
+}
+
+type Network struct {
+	ID    int           `json:"id,omitempty"`
+	Name  string        `json:"name"`
+	Nodes []NetworkNode `json:"nodes"`
+	Edges []NetworkEdge `json:"edges"`
+	Lines []NetworkLine `json:"lines"`
+}
+
+func (n NetworkNode) station() Station {
+	return Station{Name: n.Name, Dwell: n.Dwell, Berths: n.Berths, Cost: n.Cost}
+}
+
+func (e NetworkEdge) route() Route {
+	return Route{Name: e.ID, Segments: e.Segments}
+}
+
+func (nw Network) node(id string) (NetworkNode, bool) {
+	for _, n := range nw.Nodes {
+		if n.ID == id {
+			return n, true
+		}
+	}
+	return NetworkNode{}, false
+}
+
+func (nw Network) edge(id string) (NetworkEdge, bool) {
+	for _, e := range nw.Edges {
+		if e.ID == id {
+			return e, true
+		}
+	}
+	return NetworkEdge{}, false
+}
+
+// Checks the nodes, that every edge runs between them and that every line is
+// a connected chain of edges
+func checkNetwork(nw Network, geo Geodesic) error {
+
+	nodes := make(map[string]bool)
+	for i, n := range nw.Nodes {
+		if n.ID == "" || nodes[n.ID] {
+			return fmt.Errorf("node %d needs an id of its own", i)
+		}
+		switch n.Kind {
+		case nodeJunction:
+		case nodeStation:
+			if n.Berths < 1 || n.Dwell < 0 || n.Cost < 0 {
+				return fmt.Errorf("station %s needs at least one berth and a dwell and cost that are not negative", n.ID)
+			}
+		default:
+			return fmt.Errorf("node %s must be a station or a junction", n.ID)
+		}
+		nodes[n.ID] = true
+	}
+
+	edges := make(map[string]bool)
+	for i, e := range nw.Edges {
+		if e.ID == "" || edges[e.ID] {
+			return fmt.Errorf("edge %d needs an id of its own", i)
+		}
+		if len(e.Segments) < 2 {
+			return fmt.Errorf("edge %s has no geometry", e.ID)
+		}
+		if err := checkConstructionTypes(e.route()); err != nil {
+			return fmt.Errorf("edge %s: %v", e.ID, err)
+		}
+		for _, end := range []struct {
+			node string
+			seg  Segment
+		}{{e.From, e.Segments[0]}, {e.To, e.Segments[len(e.Segments)-1]}} {
+			n, ok := nw.node(end.node)
+			if !ok {
+				return fmt.Errorf("edge %s runs to unknown node %q", e.ID, end.node)
+			}
+			if d := geo.Distance(LatLng{n.Lat, n.Lng}, LatLng{end.seg.Lat, end.seg.Lng}); d > nodeTolerance {
+				return fmt.Errorf("edge %s ends %.0f m from node %s", e.ID, d, n.ID)
+			}
+		}
+		edges[e.ID] = true
+	}
+
+	for _, l := range nw.Lines {
+		if len(l.Edges) == 0 {
+			return fmt.Errorf("line %s has no edges", l.Name)
+		}
+		if _, err := nw.lineNodes(l); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// The nodes a line calls at in order, or an error when its edges do not join up
+func (nw Network) lineNodes(l NetworkLine) ([]string, error) {
+
+	var nodes []string
+	for i, id := range l.Edges {
+		e, ok := nw.edge(id)
+		if !ok {
+			return nil, fmt.Errorf("line %s uses unknown edge %q", l.Name, id)
+		}
+		if i == 0 {
+			// the first edge runs towards the node it shares with the second
+			nodes = []string{e.From, e.To}
+			if len(l.Edges) > 1 {
+				if next, ok := nw.edge(l.Edges[1]); ok && (e.From == next.From || e.From == next.To) {
+					nodes = []string{e.To, e.From}
+				}
+			}
+			continue
+		}
+		last := nodes[len(nodes)-1]
+		switch last {
+		case e.From:
+			nodes = append(nodes, e.To)
+		case e.To:
+			nodes = append(nodes, e.From)
+		default:
+			return nil, fmt.Errorf("line %s: edge %s does not join on at node %s", l.Name, id, last)
+		}
+	}
+	return nodes, nil
+}
+
+type NetworkLineLength struct {
+	Name   string   `json:"name"`
+	Length float64  `json:"length"` // m
+	Nodes  []string `json:"nodes"`
+}
+
+type NetworkEdgeCost struct {
+	ID     string         `json:"id"`
+	Length float64        `json:"length"`
+	Lines  int            `json:"lines"` // sharing the edge
+	Capex  CapexBreakdown `json:"capex"`
+}
+
+// Each edge is built once however many lines run over it
+type NetworkEvaluation struct {
+	ID           int                 `json:"id,omitempty"`
+	Name         string              `json:"name"`
+	Length       float64             `json:"length"`        // m of tube
+	LineLength   float64             `json:"line_length"`   // m summed over the lines, shared tube counted for each
+	SharedLength float64             `json:"shared_length"` // m of tube used by more than one line
+	Lines        []NetworkLineLength `json:"lines"`
+	Edges        []NetworkEdgeCost   `json:"edges"`
+	Stations     int                 `json:"stations"`
+	StationCost  float64             `json:"station_cost"`
+	Junctions    int                 `json:"junctions"`
+	Capex        float64             `json:"capex"`
+}
+
+// Length and capex of a checked network. capex leaves out the tube costs when
+// false, which saves sampling the DEM for every edge
+func evaluateNetwork(nw Network, params EvalParams, capex bool) NetworkEvaluation {
+
+	geo, err := parseGeodesic(params.Geodesic)
+	if err != nil {
+		geo = ellipsoidal
+	}
+
+	ev := NetworkEvaluation{ID: nw.ID, Name: nw.Name}
+
+	// lines on each edge, once however often a line runs along it
+	uses := make(map[string]map[int]bool)
+	for i, l := range nw.Lines {
+		for _, id := range l.Edges {
+			if uses[id] == nil {
+				uses[id] = make(map[int]bool)
+			}
+			uses[id][i] = true
+		}
+	}
+
+	lengths := make(map[string]float64)
+	for _, e := range nw.Edges {
+		route := e.route()
+		al := makeAlignment(route, geo)
+		lengths[e.ID] = al.Length
+
+		ec := NetworkEdgeCost{ID: e.ID, Length: al.Length, Lines: len(uses[e.ID])}
+		if capex {
+			var vp *VerticalProfile
+			if dem != nil {
+				if prof, err := sampleElevation(al, dem, elevationSpacingM); err == nil {
+					v := designVertical(&prof, params.Vertical)
+					vp = &v
+				}
+			}
+			// stations belong to the nodes, so are costed once below
+			ec.Capex = calcCapexBreakdown(al.Length, routeLegs(route, al), vp, nil)
+			ev.Capex += ec.Capex.Total
+		}
+		ev.Edges = append(ev.Edges, ec)
+
+		ev.Length += al.Length
+		if len(uses[e.ID]) > 1 {
+			ev.SharedLength += al.Length
+		}
+	}
+
+	for _, l := range nw.Lines {
+		nodes, _ := nw.lineNodes(l)
+		ll := NetworkLineLength{Name: l.Name, Nodes: nodes}
+		for _, id := range l.Edges {
+			ll.Length += lengths[id]
+		}
+		ev.Lines = append(ev.Lines, ll)
+		ev.LineLength += ll.Length
+	}
+
+	for _, n := range nw.Nodes {
+		if n.Kind == nodeJunction {
+			ev.Junctions++
+			continue
+		}
+		ev.Stations++
+		ev.StationCost += n.station().capex()
+	}
+	if capex {
+		ev.Capex += ev.StationCost
+	}
+
+	return ev
+}
+
+// Actions on a saved network, served as /networks/{id}/{action}
+var networkActions = map[string]func(http.ResponseWriter, *http.Request, Network){
+	"length":     networkLengthHandler,
+	"evaluation": networkEvaluationHandler,
+	"path":       networkPathHandler,
+	"assignment": assignmentHandler,
+}
+
+// POST /savenetwork with a Network as the body, which is checked before it is
+// stored as sent. Returns the new id
+func saveNetwork(w http.ResponseWriter, r *http.Request) {
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "could not read network", http.StatusBadRequest)
+		return
+	}
+
+	var nw Network
+	if err := json.Unmarshal(body, &nw); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	geo, err := requestGeodesic(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := fillEdgeRoutes(&nw); err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	if err := checkNetwork(nw, geo); err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	var id int
+	err = db.QueryRow("INSERT INTO networks (doc) VALUES ($1) RETURNING id", string(body)).Scan(&id)
+	if err != nil {
+		log.Print("saving network: ", err)
+		http.Error(w, "could not save network", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, map[string]int{"id": id})
+}
+
+func networksHandler(w http.ResponseWriter, r *http.Request) {
+
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/networks/"), "/"), "/")
+	if len(parts) != 2 {
+		http.NotFound(w, r)
+		return
+	}
+
+	id, err := strconv.Atoi(parts[0])
+	if err != nil {
+		http.Error(w, "invalid network id", http.StatusBadRequest)
+		return
+	}
+
+	action, ok := networkActions[parts[1]]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	nw, err := fetchNetwork(id)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		log.Print("loading network ", id, ": ", err)
+		http.Error(w, "could not load network", http.StatusInternalServerError)
+		return
+	}
+
+	geo, err := requestGeodesic(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := checkNetwork(nw, geo); err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	action(w, r, nw)
+}
+
+// Loads a network saved by saveNetwork, with the geometry of edges that refer
+// to saved routes filled in
+func fetchNetwork(id int) (Network, error) {
+
+	var nw Network
+	var doc []byte
+
+	if err := db.QueryRow("SELECT doc FROM networks WHERE id = ($1)", id).Scan(&doc); err != nil {
+		return nw, err
+	}
+	if err := json.Unmarshal(doc, &nw); err != nil {
+		return nw, err
+	}
+	nw.ID = id
+	return nw, fillEdgeRoutes(&nw)
+}
+
+// Copies the geometry of saved routes into the edges that refer to them
+func fillEdgeRoutes(nw *Network) error {
+
+	for i, e := range nw.Edges {
+		if e.Route == 0 || len(e.Segments) > 0 {
+			continue
+		}
+		route, err := fetchRoute(e.Route)
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("edge %s refers to route %d which does not exist", e.ID, e.Route)
+		}
+		if err != nil {
+			return err
+		}
+		nw.Edges[i].Segments = route.Segments
+	}
+	return nil
+}
+
+// GET /networks/{id}/length
+func networkLengthHandler(w http.ResponseWriter, r *http.Request, nw Network) {
+
+	params := defaultEvalParams()
+	if v := r.URL.Query().Get("geodesic"); v != "" {
+		params.Geodesic = v
+	}
+	writeJSON(w, evaluateNetwork(nw, params, false))
+}
+
+// GET /networks/{id}/evaluation with the settings panel values as query parameters
+func networkEvaluationHandler(w http.ResponseWriter, r *http.Request, nw Network) {
+
+	params, err := evalParamsFromQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, evaluateNetwork(nw, params, true))
+}
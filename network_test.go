@@ -0,0 +1,120 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Two ways from A to B, a short one with a tight corner and a longer smooth
+// one through a junction
+func testNetwork() Network {
+	return Network{
+		Name: "test",
+		Nodes: []NetworkNode{
+			{ID: "A", Kind: nodeStation, Lat: 52, Lng: 4, Berths: 1},
+			{ID: "B", Kind: nodeStation, Lat: 52, Lng: 4.2, Berths: 1},
+			{ID: "J", Kind: nodeJunction, Lat: 52.03, Lng: 4.1, Rad: 5000},
+		},
+		Edges: []NetworkEdge{
+			{ID: "direct", From: "A", To: "B", Segments: []Segment{{Lat: 52, Lng: 4}, {Lat: 52.01, Lng: 4.1, Rad: 30}, {Lat: 52, Lng: 4.2}}},
+			{ID: "AJ", From: "A", To: "J", Segments: []Segment{{Lat: 52, Lng: 4}, {Lat: 52.03, Lng: 4.1}}},
+			{ID: "JB", From: "J", To: "B", Segments: []Segment{{Lat: 52.03, Lng: 4.1}, {Lat: 52, Lng: 4.2}}},
+		},
+		Lines: []NetworkLine{{Name: "one", Edges: []string{"direct"}}, {Name: "two", Edges: []string{"AJ", "JB"}}},
+	}
+}
+
+func TestCheckNetwork(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		change func(nw *Network)
+		ok     bool
+	}{
+		{"valid", func(nw *Network) {}, true},
+		{"duplicate node", func(nw *Network) { nw.Nodes[1].ID = "A" }, false},
+		{"unknown kind", func(nw *Network) { nw.Nodes[2].Kind = "depot" }, false},
+		{"station without berths", func(nw *Network) { nw.Nodes[0].Berths = 0 }, false},
+		{"edge to unknown node", func(nw *Network) { nw.Edges[0].To = "X" }, false},
+		{"edge ends away from its node", func(nw *Network) { nw.Edges[1].Segments[1].Lat = 52.04 }, false},
+		{"edge without geometry", func(nw *Network) { nw.Edges[0].Segments = nil }, false},
+		{"line of unjoined edges", func(nw *Network) {
+			nw.Nodes = append(nw.Nodes, NetworkNode{ID: "C", Kind: nodeJunction, Lat: 53, Lng: 5}, NetworkNode{ID: "D", Kind: nodeJunction, Lat: 53, Lng: 5.1})
+			nw.Edges = append(nw.Edges, NetworkEdge{ID: "CD", From: "C", To: "D", Segments: []Segment{{Lat: 53, Lng: 5}, {Lat: 53, Lng: 5.1}}})
+			nw.Lines[1].Edges = []string{"AJ", "CD"}
+		}, false},
+		{"line with unknown edge", func(nw *Network) { nw.Lines[0].Edges = []string{"nope"} }, false},
+	}
+	for _, tt := range tests {
+		nw := testNetwork()
+		tt.change(&nw)
+		if err := checkNetwork(nw, ellipsoidal); (err == nil) != tt.ok {
+			t.Errorf("%s: error %v, want ok %v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestEvaluateNetworkSharedTube(t *testing.T) {
+
+	nw := testNetwork()
+	nw.Lines = append(nw.Lines, NetworkLine{Name: "three", Edges: []string{"JB", "AJ", "direct"}})
+	ev := evaluateNetwork(nw, defaultEvalParams(), true)
+
+	var length, lines float64
+	for _, e := range ev.Edges {
+		length += e.Length
+		lines += e.Length * float64(e.Lines)
+	}
+	if math.Abs(ev.Length-length) > 1e-6 || math.Abs(ev.LineLength-lines) > 1e-6 {
+		t.Errorf("length %v line length %v, want %v and %v", ev.Length, ev.LineLength, length, lines)
+	}
+	if math.Abs(ev.SharedLength-length) > 1e-6 {
+		t.Errorf("shared length %v, every edge is shared so want %v", ev.SharedLength, length)
+	}
+	if ev.Stations != 2 || ev.Junctions != 1 {
+		t.Errorf("%d stations and %d junctions", ev.Stations, ev.Junctions)
+	}
+	if ev.StationCost != 2*(stationCost+berthCost) {
+		t.Errorf("station cost %v", ev.StationCost)
+	}
+}
+
+// A line out and back along an edge does not share it with itself
+func TestEvaluateNetworkOutAndBack(t *testing.T) {
+
+	nw := testNetwork()
+	nw.Lines[0].Edges = []string{"direct", "direct"}
+	if err := checkNetwork(nw, ellipsoidal); err != nil {
+		t.Fatal(err)
+	}
+	ev := evaluateNetwork(nw, defaultEvalParams(), false)
+
+	for _, e := range ev.Edges {
+		if e.Lines != 1 {
+			t.Errorf("edge %s on %d lines, want 1", e.ID, e.Lines)
+		}
+	}
+	if ev.SharedLength != 0 {
+		t.Errorf("shared length %v, no edge is shared", ev.SharedLength)
+	}
+	if want := 2*ev.Edges[0].Length + ev.Edges[1].Length + ev.Edges[2].Length; math.Abs(ev.LineLength-want) > 1e-6 {
+		t.Errorf("line length %v, want %v", ev.LineLength, want)
+	}
+}
+
+func TestSaveNetworkChecks(t *testing.T) {
+
+	for _, body := range []string{
+		`{"name": "bad", "nodes": [{"id": "A", "kind": "station", "lat": 52, "lng": 4}]}`,
+		`{"name": "bad", "nodes": [], "edges": [{"id": "e", "from": "A", "to": "B", "coords": [{"lat": 52, "lng": 4}, {"lat": 52, "lng": 4.1}]}]}`,
+	} {
+		w := httptest.NewRecorder()
+		saveNetwork(w, httptest.NewRequest("POST", "/savenetwork", strings.NewReader(body)))
+		if w.Code != http.StatusUnprocessableEntity {
+			t.Errorf("%s: status %d, want %d", body, w.Code, http.StatusUnprocessableEntity)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+)
+
+var junctionRadius float64 = 2000.0 // m, curve through a junction that gives no rad
+var maxJunctionTurn float64 = 90.0  // degrees, a pod cannot turn back at a junction
+
+// A way through the network from one station to another
+type NetworkPath struct {
+	Nodes  []string `json:"nodes"`
+	Edges  []string `json:"edges"`
+	Length float64  `json:"length"` // m
+	Time   float64  `json:"time"`   // s, simulated over the whole path with stops at stations on the way
+}
+
+// Either path is nil when the stations are not connected
+type NetworkPaths struct {
+	From     string       `json:"from"`
+	To       string       `json:"to"`
+	Fastest  *NetworkPath `json:"fastest"`
+	Shortest *NetworkPath `json:"shortest"`
+}
+
+// An edge run one way
+type networkHop struct {
+	edge     NetworkEdge
+	from, to string
+	route    Route
+	length   float64
+	time     float64 // s, from a stand to a stand
+}
+
+func makeHops(nw Network, pod Pod, geo Geodesic) []networkHop {
+
+	var hops []networkHop
+	for _, e := range nw.Edges {
+		for _, reverse := range []bool{false, true} {
+			h := networkHop{edge: e, from: e.From, to: e.To, route: e.route()}
+			if reverse {
+				h.from, h.to, h.route = e.To, e.From, reverseRoute(h.route)
+			}
+			al := makeAlignment(h.route, geo)
+			h.length = al.Length
+			h.time = simulateSpeed(al, pod, nil).Time
+			hops = append(hops, h)
+		}
+	}
+	return hops
+}
+
+// Degrees the heading changes by going from hop a onto hop b
+func hopTurn(a networkHop, b networkHop, geo Geodesic) float64 {
+
+	in := a.route.Segments[len(a.route.Segments)-2:]
+	out := b.route.Segments[:2]
+	turn := math.Abs(geo.Heading(LatLng{out[0].Lat, out[0].Lng}, LatLng{out[1].Lat, out[1].Lng}) -
+		geo.Heading(LatLng{in[0].Lat, in[0].Lng}, LatLng{in[1].Lat, in[1].Lng}))
+	turn = math.Mod(turn, 360)
+	if turn > 180 {
+		turn = 360 - turn
+	}
+	return turn
+}
+
+func (n NetworkNode) radius() float64 {
+	if n.Rad > 0 {
+		return n.Rad
+	}
+	return junctionRadius
+}
+
+// Dijkstra over hops, so the turn at each junction is known. fastest weighs
+// hops by time, otherwise by length. A hop is weighed by its time at speed,
+// which is its time from a stand less the time lost starting and stopping,
+// and never less than its length at top speed. The start and stop are added
+// once for the whole path and again at each station called at on the way,
+// and the time lost slowing for the junction curve is added
+func findNetworkPath(nw Network, hops []networkHop, from string, to string, pod Pod, geo Geodesic, fastest bool) []int {
+
+	v := pod.MaxSpeed
+	stopLoss := v / pod.MaxAccelMss
+
+	weight := func(h networkHop) float64 {
+		if fastest {
+			return math.Max(h.length/v, h.time-stopLoss)
+		}
+		return h.length
+	}
+	through := func(a networkHop, b networkHop) (float64, bool) {
+		if a.edge.ID == b.edge.ID || hopTurn(a, b, geo) > maxJunctionTurn {
+			return 0, false
+		}
+		if !fastest {
+			return 0, true
+		}
+		n, _ := nw.node(a.to)
+		if n.Kind == nodeStation {
+			return n.Dwell + stopLoss, true
+		}
+		vj := math.Min(v, math.Sqrt(n.radius()*pod.MaxCornerMss))
+		return (v - vj) * (v - vj) / (pod.MaxAccelMss * v), true
+	}
+
+	cost := make([]float64, len(hops))
+	prev := make([]int, len(hops))
+	done := make([]bool, len(hops))
+	for i, h := range hops {
+		cost[i] = math.Inf(1)
+		prev[i] = -1
+		if h.from == from {
+			cost[i] = weight(h)
+			if fastest {
+				cost[i] += stopLoss
+			}
+		}
+	}
+
+	for {
+		best := -1
+		for i := range hops {
+			if !done[i] && !math.IsInf(cost[i], 1) && (best < 0 || cost[i] < cost[best]) {
+				best = i
+			}
+		}
+		if best < 0 {
+			return nil
+		}
+		if hops[best].to == to {
+			var path []int
+			for i := best; i >= 0; i = prev[i] {
+				path = append([]int{i}, path...)
+			}
+			return path
+		}
+		done[best] = true
+
+		for i, h := range hops {
+			if done[i] || h.from != hops[best].to {
+				continue
+			}
+			extra, ok := through(hops[best], h)
+			if !ok {
+				continue
+			}
+			if c := cost[best] + extra + weight(h); c < cost[i] {
+				cost[i] = c
+				prev[i] = best
+			}
+		}
+	}
+}
+
+// Joins the hops into one route with the junction curves and the stations on
+// the way, the ends included
+func networkPathRoute(nw Network, hops []networkHop, path []int) Route {
+
+	var route Route
+	station := func(id string) {
+		if n, ok := nw.node(id); ok && n.Kind == nodeStation {
+			s := n.station()
+			s.Vertex = len(route.Segments) - 1
+			route.Stations = append(route.Stations, s)
+		}
+	}
+
+	for k, i := range path {
+		segs := hops[i].route.Segments
+		if k == 0 {
+			route.Segments = append(route.Segments, segs[0])
+			station(hops[i].from)
+		} else {
+			// the vertex at the node starts the next hop's leg
+			j := len(route.Segments) - 1
+			route.Segments[j].Type = segs[0].Type
+			if n, _ := nw.node(hops[i].from); n.Kind == nodeJunction {
+				route.Segments[j].Rad = n.radius()
+			}
+		}
+		route.Segments = append(route.Segments, segs[1:]...)
+		station(hops[i].to)
+	}
+	return route
+}
+
+func networkPath(nw Network, hops []networkHop, path []int, params EvalParams, geo Geodesic) *NetworkPath {
+
+	if path == nil {
+		return nil
+	}
+
+	p := &NetworkPath{Nodes: []string{hops[path[0]].from}}
+	for _, i := range path {
+		p.Nodes = append(p.Nodes, hops[i].to)
+		p.Edges = append(p.Edges, hops[i].edge.ID)
+	}
+
+	route := networkPathRoute(nw, hops, path)
+	al := makeAlignment(route, geo)
+	var vp *VerticalProfile
+	if dem != nil {
+		if prof, err := sampleElevation(al, dem, elevationSpacingM); err == nil {
+			v := designVertical(&prof, params.Vertical)
+			vp = &v
+		}
+	}
+	p.Length = al.Length
+	p.Time = simulateSpeed(al, params.Pod, vp).Time
+	return p
+}
+
+// GET /networks/{id}/path?from=&to= with the settings panel values as query
+// parameters. from and to are station node ids
+func networkPathHandler(w http.ResponseWriter, r *http.Request, nw Network) {
+
+	params, err := evalParamsFromQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	geo, err := parseGeodesic(params.Geodesic)
+	if err != nil {
+		geo = ellipsoidal
+	}
+
+	from, to := r.URL.Query().Get("from"), r.URL.Query().Get("to")
+	for _, id := range []string{from, to} {
+		if n, ok := nw.node(id); !ok || n.Kind != nodeStation {
+			http.Error(w, fmt.Sprintf("from and to must be stations, %q is not", id), http.StatusBadRequest)
+			return
+		}
+	}
+	if from == to {
+		http.Error(w, "from and to are the same station", http.StatusBadRequest)
+		return
+	}
+
+	hops := makeHops(nw, params.Pod, geo)
+	writeJSON(w, NetworkPaths{
+		From:     from,
+		To:       to,
+		Fastest:  networkPath(nw, hops, findNetworkPath(nw, hops, from, to, params.Pod, geo, true), params, geo),
+		Shortest: networkPath(nw, hops, findNetworkPath(nw, hops, from, to, params.Pod, geo, false), params, geo),
+	})
+}
@@ -0,0 +1,55 @@
+package main
+
+import "testing"
+
+func TestFindNetworkPath(t *testing.T) {
+
+	nw := testNetwork()
+	if err := checkNetwork(nw, ellipsoidal); err != nil {
+		t.Fatal(err)
+	}
+	params := defaultEvalParams()
+	hops := makeHops(nw, params.Pod, ellipsoidal)
+
+	tests := []struct {
+		from, to string
+		fastest  bool
+		edges    []string
+	}{
+		{"A", "B", false, []string{"direct"}},
+		{"A", "B", true, []string{"AJ", "JB"}},
+		{"B", "A", true, []string{"JB", "AJ"}},
+		{"A", "J", true, []string{"AJ"}},
+	}
+	for _, tt := range tests {
+		path := findNetworkPath(nw, hops, tt.from, tt.to, params.Pod, ellipsoidal, tt.fastest)
+		p := networkPath(nw, hops, path, params, ellipsoidal)
+		if p == nil {
+			t.Errorf("%s to %s fastest %v: no path", tt.from, tt.to, tt.fastest)
+			continue
+		}
+		if len(p.Edges) != len(tt.edges) {
+			t.Errorf("%s to %s fastest %v: edges %v, want %v", tt.from, tt.to, tt.fastest, p.Edges, tt.edges)
+			continue
+		}
+		for i := range p.Edges {
+			if p.Edges[i] != tt.edges[i] {
+				t.Errorf("%s to %s fastest %v: edges %v, want %v", tt.from, tt.to, tt.fastest, p.Edges, tt.edges)
+				break
+			}
+		}
+		if p.Time <= 0 || p.Length <= 0 {
+			t.Errorf("%s to %s: time %v length %v", tt.from, tt.to, p.Time, p.Length)
+		}
+	}
+
+	// a hop shorter than the pod needs to reach top speed still weighs more than nothing
+	short := Network{
+		Nodes: []NetworkNode{{ID: "A", Kind: nodeStation, Lat: 52, Lng: 4, Berths: 1}, {ID: "B", Kind: nodeStation, Lat: 52, Lng: 4.005, Berths: 1}},
+		Edges: []NetworkEdge{{ID: "ab", From: "A", To: "B", Segments: []Segment{{Lat: 52, Lng: 4}, {Lat: 52, Lng: 4.005}}}},
+	}
+	hops = makeHops(short, params.Pod, ellipsoidal)
+	if path := findNetworkPath(short, hops, "A", "B", params.Pod, ellipsoidal, true); len(path) != 1 {
+		t.Errorf("short hop: path %v", path)
+	}
+}
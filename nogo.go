@@ -0,0 +1,46 @@
+package main
+
+import "fmt"
+
+// An area the route may not enter, such as a town or a nature reserve
+type NoGoZone struct {
+	Name    string   `json:"name"`
+	Polygon []LatLng `json:"polygon"` // the last point joins back to the first
+}
+
+func checkNoGoZones(zones []NoGoZone) error {
+	for i, z := range zones {
+		if len(z.Polygon) < 3 {
+			return fmt.Errorf("no-go zone %d %s needs at least three points", i, z.Name)
+		}
+	}
+	return nil
+}
+
+// Whether p is inside the zone, by counting the edges a ray from p crosses on
+// the grid of proj
+func (z NoGoZone) contains(proj Projection, p LatLng) bool {
+
+	x, y := proj.Forward(p)
+	inside := false
+	j := len(z.Polygon) - 1
+	for i := range z.Polygon {
+		xi, yi := proj.Forward(z.Polygon[i])
+		xj, yj := proj.Forward(z.Polygon[j])
+		if (yi > y) != (yj > y) && x < xi+(y-yi)*(xj-xi)/(yj-yi) {
+			inside = !inside
+		}
+		j = i
+	}
+	return inside
+}
+
+// The first zone p is in, nil when none
+func inNoGoZone(zones []NoGoZone, proj Projection, p LatLng) *NoGoZone {
+	for i := range zones {
+		if zones[i].contains(proj, p) {
+			return &zones[i]
+		}
+	}
+	return nil
+}
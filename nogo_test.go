@@ -0,0 +1,17 @@
+package main
+
+import "testing"
+
+func TestNoGoZone(t *testing.T) {
+
+	zone := NoGoZone{Polygon: []LatLng{{52, 4}, {52, 4.1}, {52.1, 4.1}, {52.1, 4}}}
+	proj := utmZoneFor(LatLng{52, 4})
+	for p, in := range map[LatLng]bool{{52.05, 4.05}: true, {52.2, 4.05}: false, {52.05, 3.9}: false} {
+		if zone.contains(proj, p) != in {
+			t.Errorf("%v inside %v, want %v", p, !in, in)
+		}
+	}
+	if err := checkNoGoZones([]NoGoZone{{Name: "line", Polygon: []LatLng{{52, 4}, {52, 4.1}}}}); err == nil {
+		t.Error("no error for a zone of two points")
+	}
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"strconv"
+)
+
+// Radii the optimiser tries at each corner, spaced evenly on a log scale from
+// minRadius to the largest the legs leave room for
+const radiusSteps = 24
+
+// Seconds a larger radius must save to be picked over a smaller one, which
+// keeps the curves near the vertices once the pod is at top speed through them
+var optimiseTolerance float64 = 0.1
+
+// Spacing of the points checked against the no-go zones, m
+var zoneSampleM float64 = 20.0
+
+const (
+	limitGeometry = "geometry" // the legs leave no room for more
+	limitZone     = "zone"     // a larger curve would cut into a no-go zone
+	limitTarget   = "target"   // the pod takes it at the target speed
+)
+
+type OptimisedCorner struct {
+	Vertex    int     `json:"vertex"`
+	Before    float64 `json:"before"` // m as drawn
+	Radius    float64 `json:"radius"`
+	MaxRadius float64 `json:"max_radius"`      // m the legs leave room for
+	Limit     string  `json:"limit,omitempty"` // what held the radius down, if anything
+}
+
+type RadiusOptimisation struct {
+	Objective     string            `json:"objective"`              // "time" or "speed"
+	TargetSpeed   float64           `json:"target_speed,omitempty"` // m/s
+	Route         Route             `json:"route"`
+	Corners       []OptimisedCorner `json:"corners"`
+	Time          float64           `json:"time"` // s one way as drawn, the mean of the two directions on flat ground
+	OptimisedTime float64           `json:"optimised_time"`
+	TimeSaved     float64           `json:"time_saved"`
+}
+
+// Body of a request to /routes/{id}/optimise, which may be left out
+type OptimiseRequest struct {
+	NoGo []NoGoZone `json:"nogo"`
+}
+
+// Picks the radius of every curved vertex of the route, leaving stops and
+// stations as they are. With no target speed each radius is the one that
+// gives the shortest travel time, found a vertex at a time until none
+// changes. With one it is the smallest the pod takes at that speed. Either
+// way makeAlignment's clamping sets the largest radius, and a curve is made
+// smaller until it stays out of the no-go zones
+func optimiseRadii(route Route, pod Pod, geo Geodesic, zones []NoGoZone, target float64) RadiusOptimisation {
+
+	opt := RadiusOptimisation{Objective: "time", TargetSpeed: target}
+	if target > 0 {
+		opt.Objective = "speed"
+	}
+
+	travelTime := func(r Route) float64 {
+		out := simulateSpeed(makeAlignment(r, geo), pod, nil)
+		back := simulateSpeed(makeAlignment(reverseRoute(r), geo), pod, nil)
+		return (out.Time + back.Time) / 2
+	}
+	opt.Time = travelTime(route)
+
+	best := route
+	best.Segments = append([]Segment(nil), route.Segments...)
+
+	var proj Projection
+	if len(zones) > 0 && len(route.Segments) > 0 {
+		proj = utmZoneFor(route.Segments[0].LatLng())
+	}
+	// whether the curve at vertex a keeps out of the zones with radius rad
+	clear := func(a int, rad float64) bool {
+		if proj == nil {
+			return true
+		}
+		trial := best
+		trial.Segments = append([]Segment(nil), best.Segments...)
+		trial.Segments[a].Rad = rad
+		al := makeAlignment(trial, geo)
+		for _, e := range al.Elements {
+			if e.Vertex != a || e.Kind == "line" {
+				continue
+			}
+			for d := 0.0; d <= e.Length; d += zoneSampleM {
+				if inNoGoZone(zones, proj, e.PointAt(d)) != nil {
+					return false
+				}
+			}
+			if inNoGoZone(zones, proj, e.End) != nil {
+				return false
+			}
+		}
+		return true
+	}
+
+	stations := make(map[int]bool)
+	for _, s := range route.Stations {
+		stations[s.Vertex] = true
+	}
+
+	for a := 1; a < len(route.Segments)-1; a++ {
+		if route.Segments[a].Rad <= 0 || stations[a] {
+			continue
+		}
+		c := OptimisedCorner{Vertex: a, Before: route.Segments[a].Rad, MaxRadius: maxCornerRadius(route, a, geo)}
+		if c.MaxRadius == 0 {
+			// a straight through, any radius does
+			c.MaxRadius = route.Segments[a].Rad
+		}
+		opt.Corners = append(opt.Corners, c)
+	}
+
+	// the radii to try at a corner, smallest first, cut off at the zones
+	candidates := func(c *OptimisedCorner) []float64 {
+		var rads []float64
+		lo, hi := math.Log(minRadius), math.Log(math.Max(c.MaxRadius, minRadius))
+		for i := 0; i <= radiusSteps; i++ {
+			rad := math.Exp(lo + (hi-lo)*float64(i)/radiusSteps)
+			if !clear(c.Vertex, rad) {
+				c.Limit = limitZone
+				break
+			}
+			rads = append(rads, rad)
+		}
+		return rads
+	}
+
+	if target > 0 {
+		for i := range opt.Corners {
+			c := &opt.Corners[i]
+			rad, limit := targetRadius(target, best.Segments[c.Vertex].Cant, best.AutoCant, pod), limitTarget
+			if rad > c.MaxRadius {
+				rad, limit = c.MaxRadius, limitGeometry
+			}
+			rad = math.Max(rad, minRadius)
+			if !clear(c.Vertex, rad) {
+				rads := candidates(c)
+				for len(rads) > 0 && rads[len(rads)-1] > rad {
+					rads = rads[:len(rads)-1]
+				}
+				rad, limit = c.Before, limitZone
+				if len(rads) > 0 {
+					rad = rads[len(rads)-1]
+				}
+			}
+			c.Radius, c.Limit = rad, limit
+			best.Segments[c.Vertex].Rad = rad
+		}
+	} else {
+		for i := range opt.Corners {
+			opt.Corners[i].Radius = best.Segments[opt.Corners[i].Vertex].Rad
+		}
+		// the time at one corner depends on how fast the pod leaves the last
+		for pass := 0; pass < 3; pass++ {
+			changed := false
+			for i := range opt.Corners {
+				c := &opt.Corners[i]
+				c.Limit = ""
+				rads := candidates(c)
+				if len(rads) == 0 {
+					c.Limit = limitZone
+					continue
+				}
+				times := make([]float64, len(rads))
+				fastest := math.Inf(1)
+				for k, rad := range rads {
+					best.Segments[c.Vertex].Rad = rad
+					times[k] = travelTime(best)
+					fastest = math.Min(fastest, times[k])
+				}
+				for k, rad := range rads {
+					if times[k] <= fastest+optimiseTolerance {
+						if rad != c.Radius {
+							changed = true
+						}
+						c.Radius = rad
+						break
+					}
+				}
+				best.Segments[c.Vertex].Rad = c.Radius
+				// the zone only counts when it stopped the radius growing
+				if c.Radius != rads[len(rads)-1] {
+					c.Limit = ""
+				} else if c.Limit == "" && c.Radius >= c.MaxRadius*(1-1e-9) {
+					c.Limit = limitGeometry
+				}
+			}
+			if !changed {
+				break
+			}
+		}
+	}
+
+	opt.Route = best
+	opt.OptimisedTime = travelTime(best)
+	opt.TimeSaved = opt.Time - opt.OptimisedTime
+	return opt
+}
+
+// Largest radius at vertex a that makeAlignment leaves as it is, 0 when the
+// route runs straight through
+func maxCornerRadius(route Route, a int, geo Geodesic) float64 {
+
+	trial := route
+	trial.Segments = append([]Segment(nil), route.Segments...)
+	fits := func(rad float64) (bool, float64) {
+		trial.Segments[a].Rad = rad
+		got := makeAlignment(trial, geo).Corners[a].Rad
+		return got >= rad*(1-1e-9), got
+	}
+
+	// without clothoids the clamped radius is the largest, and it bounds the
+	// radius with them, which is found by bisection
+	spiral, jerk := trial.Segments[a].Spiral, trial.MaxJerk
+	trial.Segments[a].Spiral, trial.MaxJerk = 0, 0
+	_, hi := fits(math.MaxFloat32)
+	trial.Segments[a].Spiral, trial.MaxJerk = spiral, jerk
+	if hi == 0 || (spiral == 0 && jerk == 0) {
+		return hi
+	}
+
+	lo := 0.0
+	for i := 0; i < 40; i++ {
+		mid := (lo + hi) / 2
+		if ok, _ := fits(mid); ok {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	return lo
+}
+
+// Smallest radius the pod takes at speed v, with the curve banked at cant
+// degrees or, when that is 0 and autoCant is set, at the cant CantAt would pick
+func targetRadius(v float64, cant float64, autoCant bool, pod Pod) float64 {
+
+	rad := minCurveRadius(v, cant, pod)
+	if cant == 0 && autoCant {
+		// the cant eases off as the radius grows, so settle the two together
+		for i := 0; i < 20; i++ {
+			rad = minCurveRadius(v, optimalCant(pod.MaxSpeed, rad), pod)
+		}
+	}
+	return rad
+}
+
+// POST /routes/{id}/optimise with the settings panel values as query parameters,
+// target_speed in km/h to aim for a speed rather than the shortest time, and
+// any no-go zones in the body
+func optimiseHandler(w http.ResponseWriter, r *http.Request, route Route) {
+
+	params, err := evalParamsFromQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	geo, err := parseGeodesic(params.Geodesic)
+	if err != nil {
+		geo = ellipsoidal
+	}
+
+	var target float64
+	if v := r.URL.Query().Get("target_speed"); v != "" {
+		x, err := strconv.ParseFloat(v, 64)
+		if err != nil || x <= 0 || math.IsNaN(x) || math.IsInf(x, 0) {
+			http.Error(w, fmt.Sprintf("invalid target_speed %q", v), http.StatusBadRequest)
+			return
+		}
+		target = x / 3.6
+	}
+
+	var req OptimiseRequest
+	if body, _ := ioutil.ReadAll(r.Body); len(body) > 0 {
+		if err := json.Unmarshal(body, &req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if err := checkNoGoZones(req.NoGo); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, optimiseRadii(route, params.Pod, geo, req.NoGo, target))
+}
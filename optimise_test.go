@@ -0,0 +1,92 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func testOptimiseRoute() Route {
+	return Route{Segments: []Segment{{Lat: 52, Lng: 4}, {Lat: 52.1, Lng: 4, Rad: 500}, {Lat: 52.1, Lng: 4.15}}}
+}
+
+func TestOptimiseRadiiTime(t *testing.T) {
+
+	pod := podPresets[0]
+	opt := optimiseRadii(testOptimiseRoute(), pod, ellipsoidal, nil, 0)
+	if len(opt.Corners) != 1 {
+		t.Fatalf("%d corners", len(opt.Corners))
+	}
+	c := opt.Corners[0]
+
+	// the pod takes the curve flat out once it is big enough
+	if full := minCurveRadius(pod.MaxSpeed, 0, pod); c.Radius < full*0.8 || c.Radius > c.MaxRadius {
+		t.Errorf("radius %v, the pod is at top speed from %v m and the legs allow %v m", c.Radius, full, c.MaxRadius)
+	}
+	if opt.TimeSaved <= 0 || math.Abs(opt.Time-opt.OptimisedTime-opt.TimeSaved) > 1e-9 {
+		t.Errorf("%v s as drawn, %v s optimised, %v s saved", opt.Time, opt.OptimisedTime, opt.TimeSaved)
+	}
+	if opt.Route.Segments[1].Rad != c.Radius || testOptimiseRoute().Segments[1].Rad != 500 {
+		t.Errorf("route radius %v, corner radius %v", opt.Route.Segments[1].Rad, c.Radius)
+	}
+}
+
+func TestOptimiseRadiiTarget(t *testing.T) {
+
+	pod := podPresets[0]
+	target := 300 / 3.6
+	opt := optimiseRadii(testOptimiseRoute(), pod, ellipsoidal, nil, target)
+	c := opt.Corners[0]
+	if want := targetRadius(target, 0, false, pod); math.Abs(c.Radius-want) > 1e-6 || c.Limit != limitTarget {
+		t.Errorf("radius %v limited by %q, want %v for the target speed", c.Radius, c.Limit, want)
+	}
+
+	// a target the legs leave no room for
+	route := Route{Segments: []Segment{{Lat: 52, Lng: 4}, {Lat: 52.005, Lng: 4, Rad: 500}, {Lat: 52.005, Lng: 4.008}}}
+	opt = optimiseRadii(route, pod, ellipsoidal, nil, target)
+	c = opt.Corners[0]
+	if c.Limit != limitGeometry || math.Abs(c.Radius-c.MaxRadius) > 1e-6 {
+		t.Errorf("radius %v of %v limited by %q", c.Radius, c.MaxRadius, c.Limit)
+	}
+	if got := makeAlignment(opt.Route, ellipsoidal).Corners[1].Rad; got < c.Radius*(1-1e-9) {
+		t.Errorf("makeAlignment cuts the radius to %v", got)
+	}
+}
+
+func TestOptimiseRadiiNoGo(t *testing.T) {
+
+	// a field inside the corner, some 600 m from the vertex
+	zone := NoGoZone{Name: "field", Polygon: []LatLng{{52.0952, 4.0047}, {52.0952, 4.0077}, {52.0972, 4.0077}, {52.0972, 4.0047}}}
+	pod := podPresets[0]
+	free := optimiseRadii(testOptimiseRoute(), pod, ellipsoidal, nil, 0)
+	opt := optimiseRadii(testOptimiseRoute(), pod, ellipsoidal, []NoGoZone{zone}, 0)
+	c := opt.Corners[0]
+	if c.Limit != limitZone || c.Radius >= free.Corners[0].Radius {
+		t.Errorf("radius %v limited by %q, %v without the zone", c.Radius, c.Limit, free.Corners[0].Radius)
+	}
+
+	proj := utmZoneFor(LatLng{52, 4})
+	al := makeAlignment(opt.Route, ellipsoidal)
+	for d := 0.0; d <= al.Length; d += 5 {
+		if inNoGoZone([]NoGoZone{zone}, proj, al.PointAt(d)) != nil {
+			t.Fatalf("the route enters the zone at %v m", d)
+		}
+	}
+}
+
+func TestOptimiseHandlerTargetSpeed(t *testing.T) {
+
+	for _, v := range []string{"0", "-100", "NaN", "Inf", "fast"} {
+		w := httptest.NewRecorder()
+		optimiseHandler(w, httptest.NewRequest("POST", "/routes/1/optimise?target_speed="+v, nil), testOptimiseRoute())
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("target_speed=%s: status %d, want %d", v, w.Code, http.StatusBadRequest)
+		}
+	}
+	w := httptest.NewRecorder()
+	optimiseHandler(w, httptest.NewRequest("POST", "/routes/1/optimise?target_speed=300", nil), testOptimiseRoute())
+	if w.Code != http.StatusOK {
+		t.Errorf("target_speed=300: status %d %s", w.Code, w.Body)
+	}
+}
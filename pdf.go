@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+)
+
+// A4 in points
+const pdfPageWidth float64 = 595
+const pdfPageHeight float64 = 842
+
+// A minimal PDF writer with the base 14 Helvetica fonts, lines and filled
+// rectangles, which is all the reports need. Coordinates are points from the
+// bottom left of the page
+type pdfDoc struct {
+	pages []*pdfPage
+}
+
+type pdfPage struct {
+	content bytes.Buffer
+}
+
+func (d *pdfDoc) AddPage() *pdfPage {
+	p := &pdfPage{}
+	d.pages = append(d.pages, p)
+	return p
+}
+
+// Text at x, y. bold picks Helvetica-Bold
+func (p *pdfPage) Text(x float64, y float64, size float64, bold bool, s string) {
+	font := "F1"
+	if bold {
+		font = "F2"
+	}
+	fmt.Fprintf(&p.content, "BT /%s %.1f Tf %.2f %.2f Td (%s) Tj ET\n", font, size, x, y, pdfString(s))
+}
+
+// Text ending at x
+func (p *pdfPage) TextRight(x float64, y float64, size float64, bold bool, s string) {
+	p.Text(x-pdfTextWidth(s, size), y, size, bold, s)
+}
+
+func (p *pdfPage) Stroke(r float64, g float64, b float64, width float64) {
+	fmt.Fprintf(&p.content, "%.3f %.3f %.3f RG %.2f w\n", r, g, b, width)
+}
+
+func (p *pdfPage) Fill(r float64, g float64, b float64) {
+	fmt.Fprintf(&p.content, "%.3f %.3f %.3f rg\n", r, g, b)
+}
+
+func (p *pdfPage) Line(x1 float64, y1 float64, x2 float64, y2 float64) {
+	fmt.Fprintf(&p.content, "%.2f %.2f m %.2f %.2f l S\n", x1, y1, x2, y2)
+}
+
+func (p *pdfPage) Polyline(xs []float64, ys []float64) {
+	for i := range xs {
+		op := "l"
+		if i == 0 {
+			op = "m"
+		}
+		fmt.Fprintf(&p.content, "%.2f %.2f %s\n", xs[i], ys[i], op)
+	}
+	if len(xs) > 0 {
+		p.content.WriteString("S\n")
+	}
+}
+
+func (p *pdfPage) Rect(x float64, y float64, w float64, h float64, fill bool) {
+	op := "S"
+	if fill {
+		op = "f"
+	}
+	fmt.Fprintf(&p.content, "%.2f %.2f %.2f %.2f re %s\n", x, y, w, h, op)
+}
+
+func (d *pdfDoc) Write(w io.Writer) error {
+
+	var out bytes.Buffer
+	var offsets []int
+
+	obj := func(body string) {
+		offsets = append(offsets, out.Len())
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	out.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+
+	// 1 catalog, 2 page tree, 3 and 4 fonts, then a page and its content for each page
+	var kids []string
+	for i := range d.pages {
+		kids = append(kids, fmt.Sprintf("%d 0 R", 5+2*i))
+	}
+	obj("<< /Type /Catalog /Pages 2 0 R >>")
+	obj(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(d.pages)))
+	obj("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	obj("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+
+	for i, p := range d.pages {
+		obj(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.0f %.0f] /Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>",
+			pdfPageWidth, pdfPageHeight, 6+2*i))
+		obj(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", p.content.Len(), p.content.String()))
+	}
+
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+
+	_, err := w.Write(out.Bytes())
+	return err
+}
+
+// Escapes a string for a PDF literal in WinAnsiEncoding
+func pdfString(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r == '€':
+			b.WriteString(`\200`)
+		case r < 0x80:
+			b.WriteRune(r)
+		case r < 0x100:
+			fmt.Fprintf(&b, `\%03o`, r)
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
+
+// Rough Helvetica width, good enough to right align numbers
+func pdfTextWidth(s string, size float64) float64 {
+	w := 0.0
+	for _, r := range s {
+		switch {
+		case r >= '0' && r <= '9', r == '€', r == '$':
+			w += 0.556
+		case r == '.' || r == ',' || r == ' ' || r == ':' || r == ';' || r == 'i' || r == 'l' || r == 'j':
+			w += 0.278
+		case r >= 'A' && r <= 'Z', r == 'm', r == 'w':
+			w += 0.72
+		default:
+			w += 0.52
+		}
+	}
+	return w * size
+}
+
+// Line chart of one series in the box x, y, w, h
+func (p *pdfPage) Chart(x float64, y float64, w float64, h float64, title string, xLabel string, yLabel string, xs []float64, ys []float64) {
+
+	p.Text(x, y+h+8, 10, true, title)
+	p.Stroke(0, 0, 0, 0.5)
+	p.Rect(x, y, w, h, false)
+
+	if len(xs) < 2 {
+		p.Text(x+10, y+h/2, 9, false, "No data")
+		return
+	}
+
+	minX, maxX := minMax(xs)
+	minY, maxY := minMax(ys)
+	if minY > 0 && minY < (maxY-minY) {
+		minY = 0
+	}
+	if maxY == minY {
+		maxY = minY + 1
+	}
+	if maxX == minX {
+		maxX = minX + 1
+	}
+	stepY := niceStep((maxY - minY) / 5)
+	minY = math.Floor(minY/stepY) * stepY
+	maxY = math.Ceil(maxY/stepY) * stepY
+	stepX := niceStep((maxX - minX) / 6)
+
+	px := func(v float64) float64 { return x + (v-minX)/(maxX-minX)*w }
+	py := func(v float64) float64 { return y + (v-minY)/(maxY-minY)*h }
+
+	p.Stroke(0.85, 0.85, 0.85, 0.3)
+	for v := minY; v <= maxY+stepY/2; v += stepY {
+		p.Line(x, py(v), x+w, py(v))
+		p.TextRight(x-3, py(v)-3, 7, false, formatNumber(v))
+	}
+	for v := math.Ceil(minX/stepX) * stepX; v <= maxX; v += stepX {
+		p.Line(px(v), y, px(v), y+h)
+		p.Text(px(v)-6, y-10, 7, false, formatNumber(v))
+	}
+
+	p.Text(x+w/2-pdfTextWidth(xLabel, 8)/2, y-22, 8, false, xLabel)
+	p.Text(x, y+h+1.5, 7, false, yLabel)
+
+	cx := make([]float64, len(xs))
+	cy := make([]float64, len(ys))
+	for i := range xs {
+		cx[i] = px(xs[i])
+		cy[i] = py(ys[i])
+	}
+	p.Stroke(0.8, 0.1, 0.1, 1)
+	p.Polyline(cx, cy)
+	p.Stroke(0, 0, 0, 0.5)
+}
+
+func minMax(vs []float64) (float64, float64) {
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for _, v := range vs {
+		lo = math.Min(lo, v)
+		hi = math.Max(hi, v)
+	}
+	return lo, hi
+}
+
+// 1, 2 or 5 times a power of ten
+func niceStep(raw float64) float64 {
+	if raw <= 0 {
+		return 1
+	}
+	mag := math.Pow(10, math.Floor(math.Log10(raw)))
+	for _, m := range []float64{1, 2, 5, 10} {
+		if raw <= m*mag {
+			return m * mag
+		}
+	}
+	return 10 * mag
+}
+
+// Number with thousands separators and no more decimals than it needs
+func formatNumber(v float64) string {
+
+	decimals := 0
+	switch a := math.Abs(v); {
+	case a == 0 || a >= 100:
+		decimals = 0
+	case a >= 1:
+		decimals = 1
+	default:
+		decimals = 3
+	}
+
+	s := fmt.Sprintf("%.*f", decimals, math.Abs(v))
+	intPart, frac := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		intPart, frac = s[:i], s[i:]
+		frac = strings.TrimRight(strings.TrimRight(frac, "0"), ".")
+	}
+	for i := len(intPart) - 3; i > 0; i -= 3 {
+		intPart = intPart[:i] + "," + intPart[i:]
+	}
+	if v < 0 && intPart+frac != "0" {
+		intPart = "-" + intPart
+	}
+	return intPart + frac
+}
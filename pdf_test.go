@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormatNumber(t *testing.T) {
+
+	tests := []struct {
+		v    float64
+		want string
+	}{
+		{0, "0"},
+		{12, "12"},
+		{1.26, "1.3"},
+		{0.1234, "0.123"},
+		{0.5, "0.5"},
+		{1234567, "1,234,567"},
+		{-98765.4, "-98,765"},
+		{-0.0001, "0"},
+	}
+	for _, tt := range tests {
+		if got := formatNumber(tt.v); got != tt.want {
+			t.Errorf("formatNumber(%v) = %q, want %q", tt.v, got, tt.want)
+		}
+	}
+}
+
+func TestNiceStep(t *testing.T) {
+	for raw, want := range map[float64]float64{0: 1, 0.3: 0.5, 1: 1, 1.5: 2, 3: 5, 7: 10, 4200: 5000} {
+		if got := niceStep(raw); got != want {
+			t.Errorf("niceStep(%v) = %v, want %v", raw, got, want)
+		}
+	}
+}
+
+func TestPDFString(t *testing.T) {
+	if got := pdfString(`a (b) \ € é ł`); got != `a \(b\) \\ \200 \351 ?` {
+		t.Errorf("escaped as %s", got)
+	}
+}
+
+// The cross reference table points at each object
+func TestReportPDF(t *testing.T) {
+
+	ev := testEvaluation()
+	doc := makeReport(Route{Name: ev.Route, Segments: []Segment{{Lat: 52, Lng: 4}, {Lat: 52.1, Lng: 4, Rad: 2000}, {Lat: 52.1, Lng: 4.15}}},
+		ev, reportSeries{}, time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC))
+	var b bytes.Buffer
+	if err := doc.Write(&b); err != nil {
+		t.Fatal(err)
+	}
+	out := b.Bytes()
+
+	if !bytes.HasPrefix(out, []byte("%PDF-1.4\n")) || !bytes.HasSuffix(out, []byte("%%EOF\n")) {
+		t.Fatalf("not a PDF: %q ... %q", out[:10], out[len(out)-10:])
+	}
+	if bytes.Contains(out, []byte("NaN")) || bytes.Contains(out, []byte("Inf")) {
+		t.Error("NaN or Inf in the page content")
+	}
+
+	m := regexp.MustCompile(`startxref\n(\d+)\n`).FindSubmatch(out)
+	if m == nil {
+		t.Fatal("no startxref")
+	}
+	xref, _ := strconv.Atoi(string(m[1]))
+	lines := strings.Split(string(out[xref:]), "\n")
+	if lines[0] != "xref" {
+		t.Fatalf("startxref points at %q", lines[0])
+	}
+	n, _ := strconv.Atoi(strings.Fields(lines[1])[1])
+	if want := 5 + 2*len(doc.pages); n != want {
+		t.Errorf("%d objects, want %d", n, want)
+	}
+	for i := 1; i < n; i++ {
+		off, _ := strconv.Atoi(lines[2+i][:10])
+		if want := fmt.Sprintf("%d 0 obj\n", i); !bytes.HasPrefix(out[off:], []byte(want)) {
+			t.Errorf("object %d is not at %d", i, off)
+		}
+	}
+}
+
+// The station table goes on to more pages and stays above the bottom margin
+func TestReportPagesRunOn(t *testing.T) {
+
+	ev := testEvaluation()
+	st := ev.Stations[0]
+	st.Unlimited = true
+	ev.Stations = nil
+	for i := 0; i < 80; i++ {
+		ev.Stations = append(ev.Stations, st)
+	}
+	doc := makeReport(Route{Name: ev.Route, Segments: []Segment{{Lat: 52, Lng: 4}, {Lat: 52.1, Lng: 4.15}}},
+		ev, reportSeries{}, time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC))
+
+	if len(doc.pages) < 4 {
+		t.Fatalf("%d pages for %d stations", len(doc.pages), len(ev.Stations))
+	}
+	text := regexp.MustCompile(`Tf [\d.]+ ([\d.]+) Td`)
+	for i, p := range doc.pages[2:] {
+		if c := p.content.String(); strings.Contains(c, "NaN") || strings.Contains(c, "Inf") {
+			t.Errorf("page %d: NaN or Inf", i+3)
+		}
+		for _, m := range text.FindAllStringSubmatch(p.content.String(), -1) {
+			if y, _ := strconv.ParseFloat(m[1], 64); y < reportBottom {
+				t.Errorf("page %d: text at %v", i+3, y)
+			}
+		}
+	}
+	last := doc.pages[len(doc.pages)-1].content.String()
+	if !strings.Contains(last, "(unlimited)") {
+		t.Errorf("last page %q", last)
+	}
+}
+
+// The construction shares of a route with no length are left out
+func TestReportZeroLength(t *testing.T) {
+
+	ev := testEvaluation()
+	ev.Length = 0
+	doc := makeReport(Route{Name: ev.Route, Segments: []Segment{{Lat: 52, Lng: 4}, {Lat: 52.1, Lng: 4.15}}},
+		ev, reportSeries{}, time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC))
+	for i, p := range doc.pages {
+		if c := p.content.String(); strings.Contains(c, "NaN") || strings.Contains(c, "Inf") {
+			t.Errorf("page %d: NaN or Inf", i+1)
+		}
+	}
+}
+
+// A long table goes on to more pages and stays above the bottom margin
+func TestReportFlow(t *testing.T) {
+
+	doc := &pdfDoc{}
+	flow := &reportFlow{doc: doc, name: "Route", now: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)}
+	flow.NewPage()
+	var rows [][]string
+	for i := 0; i < 120; i++ {
+		rows = append(rows, []string{fmt.Sprint(i), "row"})
+	}
+	flow.Table("One", []float64{100}, rows[:10])
+	flow.Table("Two", []float64{100}, rows)
+	flow.Warning("Warning: last")
+
+	if len(doc.pages) != 3 {
+		t.Fatalf("%d pages for %d rows", len(doc.pages), 10+len(rows))
+	}
+	text := regexp.MustCompile(`Tf [\d.]+ ([\d.]+) Td`)
+	for i, p := range doc.pages {
+		for _, m := range text.FindAllStringSubmatch(p.content.String(), -1) {
+			if y, _ := strconv.ParseFloat(m[1], 64); y < reportBottom {
+				t.Errorf("page %d: text at %v", i+1, y)
+			}
+		}
+	}
+	if last := doc.pages[2].content.String(); !strings.Contains(last, "(119)") || !strings.Contains(last, "(Warning: last)") {
+		t.Errorf("last page %q", last)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+const gravity float64 = 9.81
+
+// Pod characteristics, the same presets as Pod[] in route.js
+type Pod struct {
+	Name         string  `json:"name"`
+	MaxSpeed     float64 `json:"max_speed"`      // m/s
+	MaxCornerMss float64 `json:"max_corner_mss"` // m/s2
+	MaxVertMss   float64 `json:"max_vert_mss"`   // m/s2 over crests and through sags
+	MaxAccelMss  float64 `json:"max_accel_mss"`  // m/s2
+	EmergencyMss float64 `json:"emergency_mss"`  // m/s2 emergency braking, which sets the safe headway
+	Mass         float64 `json:"mass"`           // kg
+	MaxPower     float64 `json:"max_power"`      // total kW for the 4 motors
+	MotorEff     float64 `json:"motor_eff"`      // increases used power on accel, reduces regeneration
+	TireLiftDrag float64 `json:"tire_lift_drag"` // lift to drag ratio of the tyres or levitation
+	AeroDrag     float64 `json:"aero_drag"`      // N at max speed
+	NumPax       int     `json:"num_pax"`
+	PayloadTEU   float64 `json:"payload_teu"`
+	PayloadT     float64 `json:"payload_t"` // tonnes
+}
+
+var podPresets = []Pod{
+	{
+		Name:         "Container Freight Carrier",
+		MaxSpeed:     500 / 3.6,
+		MaxCornerMss: gravity * 0.5,
+		MaxVertMss:   gravity * 0.25,
+		MaxAccelMss:  gravity * 0.25,
+		EmergencyMss: gravity * 0.5,
+		Mass:         20000,
+		MaxPower:     3500,
+		MotorEff:     .85,
+		TireLiftDrag: 150,
+		AeroDrag:     500,
+		NumPax:       1,
+		PayloadTEU:   1,
+		PayloadT:     24,
+	},
+	{
+		Name:         "Cheetah 1,000kmh 3,500kW",
+		MaxSpeed:     1000 / 3.6,
+		MaxCornerMss: gravity * 0.5,
+		MaxVertMss:   gravity * 0.1,
+		MaxAccelMss:  gravity * 0.3,
+		EmergencyMss: gravity * 0.5,
+		Mass:         10000,
+		MaxPower:     3500,
+		MotorEff:     .85,
+		TireLiftDrag: 150,
+		AeroDrag:     500,
+		NumPax:       27,
+	},
+	{
+		Name:         "Cheetah 600kmh 2,000kW",
+		MaxSpeed:     600 / 3.6,
+		MaxCornerMss: gravity * 0.3,
+		MaxVertMss:   gravity * 0.1,
+		MaxAccelMss:  gravity * 0.2,
+		EmergencyMss: gravity * 0.5,
+		Mass:         10000,
+		MaxPower:     2000,
+		MotorEff:     .85,
+		TireLiftDrag: 150,
+		AeroDrag:     500,
+		NumPax:       27,
+	},
+	{
+		Name:         "High speed rail",
+		MaxSpeed:     200 / 3.6,
+		MaxCornerMss: gravity * 0.05,
+		MaxVertMss:   gravity * 0.05,
+		MaxAccelMss:  gravity * 0.05,
+		EmergencyMss: gravity * 0.1,
+		Mass:         10000,
+		MaxPower:     2000,
+		MotorEff:     .85,
+		TireLiftDrag: 150,
+		AeroDrag:     500,
+		NumPax:       27,
+	},
+	{
+		Name:         "Maglev Shanghai Transrapid",
+		MaxSpeed:     400 / 3.6,
+		MaxCornerMss: gravity * 0.05,
+		MaxVertMss:   gravity * 0.05,
+		MaxAccelMss:  gravity * 0.1,
+		EmergencyMss: gravity * 0.12,
+		Mass:         10000,
+		MaxPower:     1000,
+		MotorEff:     .85,
+		TireLiftDrag: 150,
+		AeroDrag:     500,
+		NumPax:       27,
+	},
+}
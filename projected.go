@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+)
+
+// Spacing of the samples used to measure distortion along a route
+const distortionSampleM float64 = 1000.0
+
+type ProjectedPoint struct {
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Rad    float64 `json:"rad"` // ground radius in metres, as in Segment
+	Spiral float64 `json:"spiral,omitempty"`
+	Cant   float64 `json:"cant,omitempty"`
+	Type   string  `json:"type,omitempty"` // construction of the leg to the next point
+}
+
+// A route on a projected grid
+type ProjectedRoute struct {
+	Name       string           `json:"name"`
+	CRS        string           `json:"crs"`
+	Points     []ProjectedPoint `json:"points"`
+	Stations   []Station        `json:"stations,omitempty"`
+	MaxJerk    float64          `json:"max_jerk,omitempty"`
+	AutoCant   bool             `json:"auto_cant,omitempty"`
+	Distortion *Distortion      `json:"distortion,omitempty"`
+}
+
+// How far measurements on the grid stray from the ground along a route
+type Distortion struct {
+	GroundLength   float64 `json:"ground_length"`
+	GridLength     float64 `json:"grid_length"`
+	LengthErrorPpm float64 `json:"length_error_ppm"`
+	MinScale       float64 `json:"min_scale"`
+	MaxScale       float64 `json:"max_scale"`
+	MaxAngular     float64 `json:"max_angular"` // degrees
+}
+
+func projectRoute(route Route, proj Projection, geo Geodesic) ProjectedRoute {
+
+	pr := ProjectedRoute{Name: route.Name, CRS: crsCode(proj), Stations: route.Stations, MaxJerk: route.MaxJerk, AutoCant: route.AutoCant}
+	for _, s := range route.Segments {
+		x, y := proj.Forward(s.LatLng())
+		pr.Points = append(pr.Points, ProjectedPoint{x, y, s.Rad, s.Spiral, s.Cant, s.Type})
+	}
+
+	d := measureDistortion(makeAlignment(route, geo), proj)
+	pr.Distortion = &d
+
+	return pr
+}
+
+func unprojectRoute(pr ProjectedRoute, proj Projection) Route {
+
+	route := Route{Name: pr.Name, Stations: pr.Stations, MaxJerk: pr.MaxJerk, AutoCant: pr.AutoCant}
+	for _, p := range pr.Points {
+		ll := proj.Inverse(p.X, p.Y)
+		route.Segments = append(route.Segments, Segment{Lat: ll.Lat, Lng: ll.Lng, Rad: p.Rad, Spiral: p.Spiral, Cant: p.Cant, Type: p.Type})
+	}
+	return route
+}
+
+func crsCode(proj Projection) string {
+	return fmt.Sprintf("EPSG:%d", proj.EPSG())
+}
+
+// Compares grid and ground lengths element by element and samples the point scale
+func measureDistortion(al Alignment, proj Projection) Distortion {
+
+	d := Distortion{GroundLength: al.Length, MinScale: math.Inf(1), MaxScale: math.Inf(-1)}
+
+	for _, e := range al.Elements {
+		d.GridLength += gridLength(e, proj)
+	}
+
+	samples := int(math.Ceil(al.Length / distortionSampleM))
+	if samples < 1 {
+		samples = 1
+	}
+	for i := 0; i <= samples; i++ {
+		ps := pointScale(proj, al.PointAt(al.Length*float64(i)/float64(samples)))
+		d.MinScale = math.Min(d.MinScale, ps.Min)
+		d.MaxScale = math.Max(d.MaxScale, ps.Max)
+		d.MaxAngular = math.Max(d.MaxAngular, ps.Angular)
+	}
+
+	if d.GroundLength > 0 {
+		d.LengthErrorPpm = (d.GridLength - d.GroundLength) / d.GroundLength * 1e6
+	}
+	return d
+}
+
+// Length of an element measured on the grid. Curves are summed as chords of at most one degree
+func gridLength(e Element, proj Projection) float64 {
+
+	steps := 1
+	if e.Kind != "line" && e.Angle != 0 {
+		steps = int(math.Ceil(math.Abs(e.Angle)))
+	}
+
+	length := 0.0
+	px, py := proj.Forward(e.Start)
+	for i := 1; i <= steps; i++ {
+		x, y := proj.Forward(e.PointAt(e.Length * float64(i) / float64(steps)))
+		length += math.Hypot(x-px, y-py)
+		px, py = x, y
+	}
+	return length
+}
+
+// GET /routes/{id}/projected?crs=EPSG:3035&geodesic=ellipsoidal
+func projectedRouteHandler(w http.ResponseWriter, r *http.Request, route Route) {
+
+	if len(route.Segments) == 0 {
+		http.Error(w, "route has no points", http.StatusBadRequest)
+		return
+	}
+
+	proj, err := parseCRS(r.URL.Query().Get("crs"), route.Segments[0].LatLng())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	geo, err := requestGeodesic(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, projectRoute(route, proj, geo))
+}
+
+// POST /projectroute?crs=EPSG:3035 with a Route, returns a ProjectedRoute
+func projectRouteHandler(w http.ResponseWriter, r *http.Request) {
+
+	var route Route
+
+	body, _ := ioutil.ReadAll(r.Body)
+	if err := json.Unmarshal(body, &route); err != nil || len(route.Segments) == 0 {
+		http.Error(w, "expected a route with coords", http.StatusBadRequest)
+		return
+	}
+	if err := checkRoute(route); err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	proj, err := parseCRS(r.URL.Query().Get("crs"), route.Segments[0].LatLng())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	geo, err := requestGeodesic(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, projectRoute(route, proj, geo))
+}
+
+// POST /unprojectroute with a ProjectedRoute, returns a Route in lat/lng
+func unprojectRouteHandler(w http.ResponseWriter, r *http.Request) {
+
+	var pr ProjectedRoute
+
+	body, _ := ioutil.ReadAll(r.Body)
+	if err := json.Unmarshal(body, &pr); err != nil || pr.CRS == "" {
+		http.Error(w, "expected a projected route with a crs", http.StatusBadRequest)
+		return
+	}
+
+	proj, err := parseCRS(pr.CRS, LatLng{})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	route := unprojectRoute(pr, proj)
+	if err := checkRoute(route); err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	writeJSON(w, route)
+}
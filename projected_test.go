@@ -0,0 +1,60 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestProjectRouteHandlerChecksStations(t *testing.T) {
+
+	for _, vertex := range []string{"5", "-1"} {
+		body := `{"coords": [{"lat": 52, "lng": 4}, {"lat": 52.1, "lng": 4.1}], "stations": [{"name": "x", "vertex": ` + vertex + `, "berths": 1}]}`
+		w := httptest.NewRecorder()
+		projectRouteHandler(w, httptest.NewRequest("POST", "/projectroute", strings.NewReader(body)))
+		if w.Code != http.StatusUnprocessableEntity {
+			t.Errorf("station at vertex %s: status %d, want %d", vertex, w.Code, http.StatusUnprocessableEntity)
+		}
+	}
+}
+
+func TestProjectedRoundTrip(t *testing.T) {
+
+	route := Route{Name: "trip", Stations: []Station{{Name: "A", Vertex: 0, Dwell: 240, Berths: 4}, {Name: "B", Vertex: 2, Dwell: 300, Berths: 2, Cost: 5e6}}, MaxJerk: 0.5, AutoCant: true, Segments: []Segment{
+		{Lat: 52, Lng: 4, Type: constructAtGrade},
+		{Lat: 52.1, Lng: 4, Rad: 2000, Spiral: 100, Cant: 3, Type: constructBored},
+		{Lat: 52.1, Lng: 4.15},
+	}}
+	for _, proj := range []Projection{utmZoneFor(route.Segments[0].LatLng()), etrsLAEA} {
+		back := unprojectRoute(projectRoute(route, proj, ellipsoidal), proj)
+		if !reflect.DeepEqual(back.Stations, route.Stations) {
+			t.Errorf("%s: stations %+v come back as %+v", proj.Name(), route.Stations, back.Stations)
+		}
+		if back.Name != route.Name || back.MaxJerk != route.MaxJerk || back.AutoCant != route.AutoCant || len(back.Segments) != len(route.Segments) {
+			t.Fatalf("%s: %+v comes back as %+v", proj.Name(), route, back)
+		}
+		for i, s := range route.Segments {
+			b := back.Segments[i]
+			if math.Abs(b.Lat-s.Lat) > 1e-7 || math.Abs(b.Lng-s.Lng) > 1e-7 {
+				t.Errorf("%s: vertex %d at %v, %v, want %v, %v", proj.Name(), i, b.Lat, b.Lng, s.Lat, s.Lng)
+			}
+			b.Lat, b.Lng = s.Lat, s.Lng
+			if b != s {
+				t.Errorf("%s: vertex %d comes back as %+v, want %+v", proj.Name(), i, b, s)
+			}
+		}
+	}
+}
+
+func TestUnprojectRouteHandlerChecksStations(t *testing.T) {
+
+	body := `{"crs": "EPSG:32631", "points": [{"x": 600000, "y": 5760000}, {"x": 610000, "y": 5770000}], "stations": [{"name": "x", "vertex": 2, "berths": 1}]}`
+	w := httptest.NewRecorder()
+	unprojectRouteHandler(w, httptest.NewRequest("POST", "/unprojectroute", strings.NewReader(body)))
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("station off the route: status %d, want %d", w.Code, http.StatusUnprocessableEntity)
+	}
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+type ellipsoid struct {
+	A float64 // semi-major axis in metres
+	F float64 // flattening
+}
+
+var wgs84 = ellipsoid{6378137.0, 1 / 298.257223563}
+var grs80 = ellipsoid{6378137.0, 1 / 298.257222101}
+
+func (el ellipsoid) E2() float64 {
+	return el.F * (2 - el.F)
+}
+
+// Converts between lat/lng and a projected grid in metres
+type Projection interface {
+	EPSG() int
+	Name() string
+	Forward(p LatLng) (float64, float64)
+	Inverse(x float64, y float64) LatLng
+}
+
+// Parses "EPSG:32633" style codes. An empty code picks the UTM zone of the fallback point
+func parseCRS(code string, fallback LatLng) (Projection, error) {
+
+	if code == "" {
+		return utmZoneFor(fallback), nil
+	}
+
+	epsg, err := strconv.Atoi(strings.TrimPrefix(strings.ToUpper(code), "EPSG:"))
+	if err != nil {
+		return nil, fmt.Errorf("unknown crs %q", code)
+	}
+
+	switch {
+	case epsg == 3035:
+		return etrsLAEA, nil
+	case epsg > 32600 && epsg <= 32660:
+		return utmProjection{epsg - 32600, true}, nil
+	case epsg > 32700 && epsg <= 32760:
+		return utmProjection{epsg - 32700, false}, nil
+	}
+	return nil, fmt.Errorf("unsupported crs %q, expected EPSG:3035 or a WGS 84 / UTM zone", code)
+}
+
+// ---------------------------------------------------------------------------
+// UTM
+
+const utmK0 float64 = 0.9996
+const utmFalseEasting float64 = 500000.0
+const utmFalseNorthingSouth float64 = 10000000.0
+
+// A WGS84 / UTM zone, EPSG:326zz in the north and EPSG:327zz in the south
+type utmProjection struct {
+	Zone  int
+	North bool
+}
+
+func (c utmProjection) EPSG() int {
+	if c.North {
+		return 32600 + c.Zone
+	}
+	return 32700 + c.Zone
+}
+
+func (c utmProjection) Name() string {
+	if c.North {
+		return fmt.Sprintf("WGS 84 / UTM zone %dN", c.Zone)
+	}
+	return fmt.Sprintf("WGS 84 / UTM zone %dS", c.Zone)
+}
+
+// UTM zone a point falls in
+func utmZoneFor(p LatLng) utmProjection {
+	zone := int(math.Floor((p.Lng+180)/6)) + 1
+	if zone > 60 {
+		zone = 60
+	}
+	return utmProjection{zone, p.Lat >= 0}
+}
+
+func (c utmProjection) centralMeridian() float64 {
+	return float64(c.Zone-1)*6 - 180 + 3
+}
+
+// Krüger series coefficients to sixth order in n
+func krugerSeries() (float64, [6]float64, [6]float64) {
+
+	n := wgs84.F / (2 - wgs84.F)
+	n2, n3, n4, n5, n6 := n*n, n*n*n, n*n*n*n, n*n*n*n*n, n*n*n*n*n*n
+
+	A := wgs84.A / (1 + n) * (1 + n2/4 + n4/64 + n6/256)
+	alpha := [6]float64{
+		n/2 - 2*n2/3 + 5*n3/16 + 41*n4/180 - 127*n5/288 + 7891*n6/37800,
+		13*n2/48 - 3*n3/5 + 557*n4/1440 + 281*n5/630 - 1983433*n6/1935360,
+		61*n3/240 - 103*n4/140 + 15061*n5/26880 + 167603*n6/181440,
+		49561*n4/161280 - 179*n5/168 + 6601661*n6/7257600,
+		34729*n5/80640 - 3418889*n6/1995840,
+		212378941 * n6 / 319334400,
+	}
+	beta := [6]float64{
+		n/2 - 2*n2/3 + 37*n3/96 - n4/360 - 81*n5/512 + 96199*n6/604800,
+		n2/48 + n3/15 - 437*n4/1440 + 46*n5/105 - 1118711*n6/3870720,
+		17*n3/480 - 37*n4/840 - 209*n5/4480 + 5569*n6/90720,
+		4397*n4/161280 - 11*n5/504 - 830251*n6/7257600,
+		4583*n5/161280 - 108847*n6/3991680,
+		20648693 * n6 / 638668800,
+	}
+	return A, alpha, beta
+}
+
+// Transverse Mercator forward projection to easting and northing in metres
+func (c utmProjection) Forward(p LatLng) (float64, float64) {
+
+	e := math.Sqrt(wgs84.E2())
+	A, alpha, _ := krugerSeries()
+
+	phi := degRad(p.Lat)
+	lam := degRad(p.Lng - c.centralMeridian())
+
+	tau := math.Tan(phi)
+	sigma := math.Sinh(e * math.Atanh(e*tau/math.Sqrt(1+tau*tau)))
+	tauP := tau*math.Sqrt(1+sigma*sigma) - sigma*math.Sqrt(1+tau*tau)
+
+	xiP := math.Atan2(tauP, math.Cos(lam))
+	etaP := math.Asinh(math.Sin(lam) / math.Sqrt(tauP*tauP+math.Cos(lam)*math.Cos(lam)))
+
+	xi, eta := xiP, etaP
+	for j := 1; j <= 6; j++ {
+		k := float64(2 * j)
+		xi += alpha[j-1] * math.Sin(k*xiP) * math.Cosh(k*etaP)
+		eta += alpha[j-1] * math.Cos(k*xiP) * math.Sinh(k*etaP)
+	}
+
+	x := utmK0*A*eta + utmFalseEasting
+	y := utmK0 * A * xi
+	if !c.North {
+		y += utmFalseNorthingSouth
+	}
+	return x, y
+}
+
+func (c utmProjection) Inverse(x float64, y float64) LatLng {
+
+	e := math.Sqrt(wgs84.E2())
+	A, _, beta := krugerSeries()
+
+	if !c.North {
+		y -= utmFalseNorthingSouth
+	}
+	xi := y / (utmK0 * A)
+	eta := (x - utmFalseEasting) / (utmK0 * A)
+
+	xiP, etaP := xi, eta
+	for j := 1; j <= 6; j++ {
+		k := float64(2 * j)
+		xiP -= beta[j-1] * math.Sin(k*xi) * math.Cosh(k*eta)
+		etaP -= beta[j-1] * math.Cos(k*xi) * math.Sinh(k*eta)
+	}
+
+	sinhEta := math.Sinh(etaP)
+	tauP := math.Sin(xiP) / math.Sqrt(sinhEta*sinhEta+math.Cos(xiP)*math.Cos(xiP))
+	lam := math.Atan2(sinhEta, math.Cos(xiP))
+
+	// Newton iteration from the conformal latitude back to the geodetic one
+	tau := tauP
+	for i := 0; i < 10; i++ {
+		sigma := math.Sinh(e * math.Atanh(e*tau/math.Sqrt(1+tau*tau)))
+		tauI := tau*math.Sqrt(1+sigma*sigma) - sigma*math.Sqrt(1+tau*tau)
+		d := (tauP - tauI) / math.Sqrt(1+tauI*tauI) * (1 + (1-wgs84.E2())*tau*tau) / ((1 - wgs84.E2()) * math.Sqrt(1+tau*tau))
+		tau += d
+		if math.Abs(d) < 1e-12 {
+			break
+		}
+	}
+
+	return LatLng{radDeg(math.Atan(tau)), wrapAngle(radDeg(lam) + c.centralMeridian())}
+}
+
+// ---------------------------------------------------------------------------
+// Lambert azimuthal equal area
+
+// ETRS89-extended / LAEA Europe
+var etrsLAEA = laeaProjection{
+	Code:          3035,
+	Title:         "ETRS89-extended / LAEA Europe",
+	Ellipsoid:     grs80,
+	Lat0:          52,
+	Lng0:          10,
+	FalseEasting:  4321000,
+	FalseNorthing: 3210000,
+}
+
+// Oblique Lambert azimuthal equal area on the ellipsoid (EPSG method 9820)
+type laeaProjection struct {
+	Code          int
+	Title         string
+	Ellipsoid     ellipsoid
+	Lat0          float64
+	Lng0          float64
+	FalseEasting  float64
+	FalseNorthing float64
+}
+
+func (c laeaProjection) EPSG() int {
+	return c.Code
+}
+
+func (c laeaProjection) Name() string {
+	return c.Title
+}
+
+func (c laeaProjection) q(phi float64) float64 {
+	e2 := c.Ellipsoid.E2()
+	e := math.Sqrt(e2)
+	s := math.Sin(phi)
+	return (1 - e2) * (s/(1-e2*s*s) - 1/(2*e)*math.Log((1-e*s)/(1+e*s)))
+}
+
+// Authalic radius, origin authalic latitude and the D scaling term
+func (c laeaProjection) constants() (float64, float64, float64) {
+
+	e2 := c.Ellipsoid.E2()
+	phi0 := degRad(c.Lat0)
+	qP := c.q(math.Pi / 2)
+	beta0 := math.Asin(c.q(phi0) / qP)
+	rq := c.Ellipsoid.A * math.Sqrt(qP/2)
+	d := c.Ellipsoid.A * (math.Cos(phi0) / math.Sqrt(1-e2*math.Pow(math.Sin(phi0), 2))) / (rq * math.Cos(beta0))
+
+	return rq, beta0, d
+}
+
+func (c laeaProjection) Forward(p LatLng) (float64, float64) {
+
+	rq, beta0, d := c.constants()
+	beta := math.Asin(c.q(degRad(p.Lat)) / c.q(math.Pi/2))
+	dLam := degRad(p.Lng - c.Lng0)
+
+	b := rq * math.Sqrt(2/(1+math.Sin(beta0)*math.Sin(beta)+math.Cos(beta0)*math.Cos(beta)*math.Cos(dLam)))
+
+	x := c.FalseEasting + b*d*math.Cos(beta)*math.Sin(dLam)
+	y := c.FalseNorthing + (b/d)*(math.Cos(beta0)*math.Sin(beta)-math.Sin(beta0)*math.Cos(beta)*math.Cos(dLam))
+	return x, y
+}
+
+func (c laeaProjection) Inverse(x float64, y float64) LatLng {
+
+	rq, beta0, d := c.constants()
+	dx := x - c.FalseEasting
+	dy := y - c.FalseNorthing
+
+	rho := math.Hypot(dx/d, d*dy)
+	if rho == 0 {
+		return LatLng{c.Lat0, c.Lng0}
+	}
+	cc := 2 * math.Asin(rho/(2*rq))
+
+	betaP := math.Asin(math.Cos(cc)*math.Sin(beta0) + d*dy*math.Sin(cc)*math.Cos(beta0)/rho)
+	lam := math.Atan2(dx*math.Sin(cc), d*rho*math.Cos(beta0)*math.Cos(cc)-d*d*dy*math.Sin(beta0)*math.Sin(cc))
+
+	e2 := c.Ellipsoid.E2()
+	e4, e6 := e2*e2, e2*e2*e2
+	phi := betaP +
+		(e2/3+31*e4/180+517*e6/5040)*math.Sin(2*betaP) +
+		(23*e4/360+251*e6/3780)*math.Sin(4*betaP) +
+		(761*e6/45360)*math.Sin(6*betaP)
+
+	return LatLng{radDeg(phi), wrapAngle(c.Lng0 + radDeg(lam))}
+}
+
+// ---------------------------------------------------------------------------
+// Distortion
+
+// Tissot indicatrix of a projection at a point
+type PointScale struct {
+	Meridian float64 `json:"meridian"` // h, scale along the meridian
+	Parallel float64 `json:"parallel"` // k, scale along the parallel
+	Max      float64 `json:"max"`
+	Min      float64 `json:"min"`
+	Area     float64 `json:"area"`
+	Angular  float64 `json:"angular"` // maximum angular deformation in degrees
+}
+
+// Works out the point scale numerically, so it holds for any Projection
+func pointScale(proj Projection, p LatLng) PointScale {
+
+	const step = 1e-5 // degrees
+
+	e2 := wgs84.E2()
+	phi := degRad(p.Lat)
+	w := math.Sqrt(1 - e2*math.Pow(math.Sin(phi), 2))
+	m := wgs84.A * (1 - e2) / (w * w * w) // meridian radius of curvature
+	n := wgs84.A / w                      // prime vertical radius of curvature
+
+	x0, y0 := proj.Forward(LatLng{p.Lat - step, p.Lng})
+	x1, y1 := proj.Forward(LatLng{p.Lat + step, p.Lng})
+	x2, y2 := proj.Forward(LatLng{p.Lat, p.Lng - step})
+	x3, y3 := proj.Forward(LatLng{p.Lat, p.Lng + step})
+
+	dxPhi, dyPhi := (x1-x0)/(2*step), (y1-y0)/(2*step)
+	dxLam, dyLam := (x3-x2)/(2*step), (y3-y2)/(2*step)
+
+	h := math.Hypot(dxPhi, dyPhi) / degRad(m)
+	k := math.Hypot(dxLam, dyLam) / degRad(n*math.Cos(phi))
+
+	// angle between the projected meridian and parallel
+	sinTheta := math.Abs(dxPhi*dyLam-dyPhi*dxLam) / (math.Hypot(dxPhi, dyPhi) * math.Hypot(dxLam, dyLam))
+
+	aPlusB := math.Sqrt(h*h + k*k + 2*h*k*sinTheta)
+	aMinusB := math.Sqrt(math.Max(0, h*h+k*k-2*h*k*sinTheta))
+
+	return PointScale{
+		Meridian: h,
+		Parallel: k,
+		Max:      (aPlusB + aMinusB) / 2,
+		Min:      (aPlusB - aMinusB) / 2,
+		Area:     h * k * sinTheta,
+		Angular:  radDeg(2 * math.Asin(aMinusB/aPlusB)),
+	}
+}
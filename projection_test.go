@@ -0,0 +1,81 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestProjectionRoundTrip(t *testing.T) {
+
+	points := []LatLng{{52, 4}, {48.85, 2.35}, {60.2, 24.9}, {38.7, -9.1}, {-33.9, 18.4}}
+	for _, p := range points {
+		for _, proj := range []Projection{utmZoneFor(p), etrsLAEA} {
+			x, y := proj.Forward(p)
+			q := proj.Inverse(x, y)
+			// 1e-7 degrees is about a centimetre, within the series for the
+			// authalic latitude
+			if math.Abs(q.Lat-p.Lat) > 1e-7 || math.Abs(q.Lng-p.Lng) > 1e-7 {
+				t.Errorf("%s: %v comes back as %v", proj.Name(), p, q)
+			}
+		}
+	}
+}
+
+func TestProjectionKnownPoints(t *testing.T) {
+
+	tests := []struct {
+		proj Projection
+		p    LatLng
+		x, y float64
+		tol  float64
+	}{
+		// EPSG Guidance Note 7-2, the example for method 9820
+		{etrsLAEA, LatLng{50, 5}, 3962799.45, 2999718.85, 0.01},
+		{utmProjection{31, true}, LatLng{0, 3}, 500000, 0, 1e-6},
+		{utmProjection{31, false}, LatLng{0, 3}, 500000, 10000000, 1e-6},
+	}
+	for _, tt := range tests {
+		x, y := tt.proj.Forward(tt.p)
+		if math.Abs(x-tt.x) > tt.tol || math.Abs(y-tt.y) > tt.tol {
+			t.Errorf("%s: %v at %.3f %.3f, want %.3f %.3f", tt.proj.Name(), tt.p, x, y, tt.x, tt.y)
+		}
+	}
+
+	// the zone is symmetric about its central meridian, scaled by k0 on it
+	proj := utmProjection{31, true}
+	x1, y1 := proj.Forward(LatLng{52, 1})
+	x2, y2 := proj.Forward(LatLng{52, 5})
+	if math.Abs(x1+x2-2*utmFalseEasting) > 1e-6 || math.Abs(y1-y2) > 1e-6 {
+		t.Errorf("%v %v and %v %v are not mirror images", x1, y1, x2, y2)
+	}
+	if s := pointScale(proj, LatLng{52, 3}); math.Abs(s.Max-utmK0) > 1e-6 || s.Angular > 1e-4 {
+		t.Errorf("scale %v and angular distortion %v on the central meridian", s.Max, s.Angular)
+	}
+}
+
+func TestParseCRS(t *testing.T) {
+
+	tests := []struct {
+		code string
+		epsg int
+	}{
+		{"", 32631},
+		{"EPSG:3035", 3035},
+		{"epsg:32633", 32633},
+		{"32734", 32734},
+		{"EPSG:4326", 0},
+		{"UTM", 0},
+	}
+	for _, tt := range tests {
+		proj, err := parseCRS(tt.code, LatLng{52, 4})
+		if tt.epsg == 0 {
+			if err == nil {
+				t.Errorf("%q parsed as %s", tt.code, proj.Name())
+			}
+			continue
+		}
+		if err != nil || proj.EPSG() != tt.epsg {
+			t.Errorf("%q: %v %v, want EPSG:%d", tt.code, proj, err, tt.epsg)
+		}
+	}
+}
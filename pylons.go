@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+)
+
+// Pylons up to MaxHeight m cost Cost each
+type PylonBand struct {
+	MaxHeight float64 `json:"max_height"`
+	Cost      float64 `json:"cost"`
+}
+
+// Pylon cost bands, lowest first. Pylons taller than the last band are costed
+// at its price
+var pylonCostBands = []PylonBand{
+	{10, 16800},
+	{20, 29500},
+	{35, 51000},
+	{50, 84000},
+}
+
+var minPylonHeight float64 = 5.0     // clearance of the tube over the ground, m
+var bridgePylonHeight float64 = 50.0 // pylons taller than this need a bridge, m
+
+// The pylon and grade line settings as read from the file in PYLON_COSTS
+type pylonConfig struct {
+	MinHeight    float64     `json:"min_height"`
+	BridgeHeight float64     `json:"bridge_height"`
+	MaxGrade     float64     `json:"max_grade"`
+	CrestRadius  float64     `json:"min_crest_radius"`
+	SagRadius    float64     `json:"min_sag_radius"`
+	Bands        []PylonBand `json:"bands"`
+}
+
+// Replaces the pylon settings with those in a JSON file. Settings left out keep
+// their defaults
+func loadPylonConfig(path string) error {
+
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	cfg := pylonConfig{minPylonHeight, bridgePylonHeight, maxTubeGrade, minCrestRadius, minSagRadius, pylonCostBands}
+	if err := json.Unmarshal(raw, &cfg); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	if len(cfg.Bands) == 0 || cfg.MinHeight < 0 || cfg.BridgeHeight <= 0 || cfg.MaxGrade <= 0 || cfg.CrestRadius <= 0 || cfg.SagRadius <= 0 {
+		return fmt.Errorf("%s: need bands, and a positive bridge height, grade and radii", path)
+	}
+
+	sort.Slice(cfg.Bands, func(i, j int) bool { return cfg.Bands[i].MaxHeight < cfg.Bands[j].MaxHeight })
+
+	minPylonHeight = cfg.MinHeight
+	bridgePylonHeight = cfg.BridgeHeight
+	maxTubeGrade = cfg.MaxGrade
+	minCrestRadius = cfg.CrestRadius
+	minSagRadius = cfg.SagRadius
+	pylonCostBands = cfg.Bands
+	return nil
+}
+
+// Pylons falling in a cost band
+type PylonBandCount struct {
+	MaxHeight float64 `json:"max_height"`
+	Cost      float64 `json:"cost"` // each
+	Count     int     `json:"count"`
+	Total     float64 `json:"total"`
+}
+
+// A run of pylons too tall to build, or a stretch built as a bridge
+type Bridge struct {
+	Start     float64 `json:"start"` // m from the start of the route
+	End       float64 `json:"end"`
+	MaxHeight float64 `json:"max_height"`
+}
+
+// Cost band of a pylon of height h
+func pylonBand(h float64) int {
+	band := sort.Search(len(pylonCostBands), func(j int) bool { return h <= pylonCostBands[j].MaxHeight })
+	if band == len(pylonCostBands) {
+		band--
+	}
+	return band
+}
+
+// The capex lines for pylons, one per band, or a single line on flat ground
+func pylonLines(c CapexBreakdown) ([]string, []PylonBandCount) {
+
+	if len(c.PylonBands) == 0 {
+		return []string{"Pylons"}, []PylonBandCount{{minPylonHeight, pylonCost, c.Pylons, c.PylonCost}}
+	}
+
+	labels := make([]string, len(c.PylonBands))
+	for i, b := range c.PylonBands {
+		labels[i] = fmt.Sprintf("Pylons up to %g m", b.MaxHeight)
+		if i == len(c.PylonBands)-1 && i > 0 {
+			labels[i] = fmt.Sprintf("Pylons over %g m", c.PylonBands[i-1].MaxHeight)
+		}
+	}
+	return labels, c.PylonBands
+}
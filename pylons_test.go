@@ -0,0 +1,47 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestFlatGroundCapex(t *testing.T) {
+
+	for _, length := range []float64{0, 19, 20, 1234.5, 100000} {
+		tubeSegments := math.Ceil(length/tubeSegmentLength) * 2
+		pylons := math.Ceil(length / pylonSpacingM)
+		baseline := int(tubeSegments*(tubeSegmentCost+tubeJointCost) + pylonCost*pylons)
+
+		if got := calcCapex(length); got != baseline {
+			t.Errorf("%v m: capex %d, want %d", length, got, baseline)
+		}
+
+		c := calcCapexBreakdown(length, nil, nil, nil)
+		if c.PylonCost != pylonCost*float64(c.Pylons) || float64(c.Pylons) != pylons {
+			t.Errorf("%v m: %d pylons costing %v, want %v at %v", length, c.Pylons, c.PylonCost, pylons, pylonCost)
+		}
+		if c.CivilCost != 0 || len(c.Bridges) > 0 || c.PylonBands != nil {
+			t.Errorf("%v m: civil cost %v, %d bridges and bands %v on flat ground", length, c.CivilCost, len(c.Bridges), c.PylonBands)
+		}
+	}
+}
+
+func TestPylonBand(t *testing.T) {
+
+	tests := []struct {
+		h    float64
+		band int
+	}{
+		{0, 0},
+		{10, 0},
+		{10.1, 1},
+		{35, 2},
+		{50, 3},
+		{120, 3},
+	}
+	for _, tt := range tests {
+		if got := pylonBand(tt.h); got != tt.band {
+			t.Errorf("pylon of %v m in band %d, want %d", tt.h, got, tt.band)
+		}
+	}
+}
@@ -0,0 +1,382 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"time"
+)
+
+// Where the tables on the later pages start and how far down they may run
+const reportTop float64 = 760
+const reportBottom float64 = 50
+
+// A profile to chart, distance in m against a value
+type reportSeries struct {
+	X []float64
+	Y []float64
+}
+
+// GET /routes/{id}/report.pdf with the settings panel values as query parameters
+func reportHandler(w http.ResponseWriter, r *http.Request, route Route) {
+
+	if len(route.Segments) < 2 {
+		http.Error(w, "route needs at least two points", http.StatusBadRequest)
+		return
+	}
+
+	params, err := evalParamsFromQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ev := evaluateRoute(route, params)
+
+	// the report still goes out without a profile where there is no DEM coverage
+	var elevation reportSeries
+	if ev.Elevation != nil {
+		for _, s := range ev.Elevation.Samples {
+			elevation.X = append(elevation.X, s.Distance)
+			elevation.Y = append(elevation.Y, s.Elevation)
+		}
+	}
+
+	doc := makeReport(route, ev, elevation, time.Now())
+
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", exportFileName(route, "pdf")))
+	if err := doc.Write(w); err != nil {
+		log.Print("writing report: ", err)
+	}
+}
+
+// Lays out the feasibility report: map and assumptions, then the profiles, then the costs
+func makeReport(route Route, ev Evaluation, elevation reportSeries, now time.Time) *pdfDoc {
+
+	doc := &pdfDoc{}
+	name := route.Name
+	if name == "" {
+		name = "Route"
+	}
+
+	// Page 1: map, key results and assumptions
+	p := doc.AddPage()
+	reportHeader(p, name, now)
+
+	geo, err := parseGeodesic(ev.Params.Geodesic)
+	if err != nil {
+		geo = ellipsoidal
+	}
+	reportMap(p, makeAlignment(route, geo), 50, 420, 495, 340)
+
+	pod := ev.Params.Pod
+	y := reportTable(p, 50, 390, "Key results", []float64{150}, [][]string{
+		{"Length", formatNumber(ev.Length/1000) + " km"},
+		{"Travel time", formatNumber(ev.Speed.Time/60) + " min, " + formatNumber(ev.Return.Time/60) + " min back"},
+		{"Average speed", formatNumber(ev.Speed.AvgSpeed*3.6) + " km/h"},
+		{"Pods", fmt.Sprint(ev.Fleet.Pods)},
+		{"Capex", "€ " + formatNumber(ev.Capex.Total/1e6) + " m"},
+		{"Opex", "€ " + formatNumber(ev.Opex.Total/1e6) + " m / year"},
+		{"Energy per trip", formatNumber(ev.Speed.EnergyKwh) + " kWh, " + formatNumber(ev.Return.EnergyKwh) + " kWh back"},
+		{"Energy per " + ev.Params.Service.single(), formatNumber(ev.Opex.EnergyKwhPerUnit) + " kWh"},
+		{"Battery", formatNumber(math.Max(ev.Speed.MaxBattery, ev.Return.MaxBattery)) + " kWh"},
+		{"Pylon height", formatNumber(ev.Capex.MeanPylonHeight) + " m mean, " + formatNumber(ev.Capex.MaxPylonHeight) + " m max"},
+		{"Tunnels", tunnelSummary(ev.Vertical)},
+	})
+
+	reportTable(p, 50, y-20, "Cost parameters", []float64{150}, [][]string{
+		{"Tube segment", "€ " + formatNumber(tubeSegmentCost) + " per " + formatNumber(tubeSegmentLength) + " m"},
+		{"Tube joint", "€ " + formatNumber(tubeJointCost)},
+		{"Pylon", "€ " + formatNumber(pylonCost) + " every " + formatNumber(pylonSpacingM) + " m on flat ground"},
+		{"Pylon height", formatNumber(minPylonHeight) + " m clearance"},
+		{"Grade line", formatNumber(ev.Params.Vertical.MaxGrade*100) + " % max grade, crest " + formatNumber(ev.Params.Vertical.MinCrestRadius/1000) +
+			" km, sag " + formatNumber(ev.Params.Vertical.MinSagRadius/1000) + " km"},
+		{"Energy", "€ " + formatNumber(energyCostPerKWh) + " per kWh"},
+		{"Maintenance", formatNumber(maintenanceRate*100) + " % of capex a year"},
+	})
+
+	reportTable(p, 310, 390, "Input assumptions", []float64{130}, [][]string{
+		{"Pod", pod.Name},
+		{"Max velocity", formatNumber(pod.MaxSpeed*3.6) + " km/h"},
+		{"Acceleration", formatNumber(pod.MaxAccelMss/gravity) + " g"},
+		{"Emergency braking", formatNumber(pod.EmergencyMss/gravity) + " g"},
+		{"Max cornering", formatNumber(pod.MaxCornerMss/gravity) + " g"},
+		{"Max vertical", formatNumber(pod.MaxVertMss/gravity) + " g"},
+		{"Pod weight", formatNumber(pod.Mass/1000) + " t"},
+		{"Pod motor power", formatNumber(pod.MaxPower) + " kW"},
+		{"Throughput", formatNumber(ev.Params.Throughput) + " " + ev.Params.Service.label() + " / day"},
+		{"Load factor", formatNumber(ev.Params.Service.LoadFactor*100) + " % of " + formatNumber(ev.Params.Service.payload(pod))},
+		{"Loading time", formatNumber(ev.Params.LoadingTime) + " min"},
+		{"Tube diameter", formatNumber(ev.Params.Diameter) + " m"},
+		{"Geodesic", ev.Params.Geodesic},
+	})
+
+	// Page 2: speed and elevation profiles
+	p = doc.AddPage()
+	reportHeader(p, name, now)
+
+	var speed reportSeries
+	speed.X = append(speed.X, 0)
+	speed.Y = append(speed.Y, 0)
+	for _, s := range ev.Speed.Segments {
+		speed.X = append(speed.X, s.Distance/1000)
+		speed.Y = append(speed.Y, s.Speed*3.6)
+	}
+	p.Chart(80, 470, 460, 260, "Speed profile", "Distance from start (km)", "km/h", speed.X, speed.Y)
+
+	elevKm := make([]float64, len(elevation.X))
+	for i, x := range elevation.X {
+		elevKm[i] = x / 1000
+	}
+	p.Chart(80, 110, 460, 260, "Elevation profile", "Distance from start (km)", "m", elevKm, elevation.Y)
+
+	// Page 3 on: costs and fleet
+	flow := &reportFlow{doc: doc, name: name, now: now}
+	flow.NewPage()
+
+	capex := [][]string{
+		{"Item", "Quantity", "Unit cost (€)", "Cost (€)"},
+		{"Tube segments", fmt.Sprint(ev.Capex.TubeSegments), formatNumber(tubeSegmentCost), formatNumber(ev.Capex.TubeSegmentCost)},
+		{"Tube joints", fmt.Sprint(ev.Capex.TubeSegments), formatNumber(tubeJointCost), formatNumber(ev.Capex.TubeJointCost)},
+	}
+	labels, bands := pylonLines(ev.Capex)
+	for i, b := range bands {
+		capex = append(capex, []string{labels[i], fmt.Sprint(b.Count), formatNumber(b.Cost), formatNumber(b.Total)})
+	}
+	for _, cc := range ev.Capex.Construction {
+		if cc.CostPerM > 0 {
+			capex = append(capex, []string{constructionLabel(cc.Type) + " (m)", formatNumber(cc.Length), formatNumber(cc.CostPerM), formatNumber(cc.Cost)})
+		}
+	}
+	if ev.Capex.Stations > 0 {
+		capex = append(capex, []string{"Stations", fmt.Sprint(ev.Capex.Stations), "", formatNumber(ev.Capex.StationCost)})
+	}
+	capex = append(capex, []string{"Total", "", "", formatNumber(ev.Capex.Total)})
+
+	split := [][]string{{"Construction", "Length (km)", "Share"}}
+	for _, cc := range ev.Capex.Construction {
+		share := ""
+		if ev.Length > 0 {
+			share = formatNumber(100*cc.Length/ev.Length) + " %"
+		}
+		split = append(split, []string{constructionLabel(cc.Type), formatNumber(cc.Length / 1000), share})
+	}
+	flow.Table("Capex", []float64{150, 260, 380}, capex)
+	flow.Table("Construction", []float64{150, 260}, split)
+
+	if len(ev.Capex.Bridges) > 0 {
+		bridges := [][]string{{"From (km)", "To (km)", "Tallest pylon (m)"}}
+		for _, b := range ev.Capex.Bridges {
+			bridges = append(bridges, []string{formatNumber(b.Start / 1000), formatNumber(b.End / 1000), formatNumber(b.MaxHeight)})
+		}
+		flow.Table(fmt.Sprintf("Pylons over %g m, needing a bridge", bridgePylonHeight), []float64{110, 220}, bridges)
+	}
+
+	flow.Table("Opex", []float64{150, 260, 380}, [][]string{
+		{"Item", "Quantity", "Unit cost (€)", "Cost (€ / year)"},
+		{"Energy (kWh)", formatNumber(ev.Opex.EnergyKwhPerYear), formatNumber(energyCostPerKWh), formatNumber(ev.Opex.EnergyCost)},
+		{"Maintenance", formatNumber(maintenanceRate*100) + " %", formatNumber(ev.Capex.Total), formatNumber(ev.Opex.Maintenance)},
+		{"Total", "", "", formatNumber(ev.Opex.Total)},
+	})
+
+	flow.Table("Pod fleet", []float64{200}, [][]string{
+		{"Travel time one way", formatNumber(ev.Fleet.TravelTime) + " s"},
+		{"Round trip with loading", formatNumber(ev.Fleet.RoundTripTime) + " min"},
+		{"Pod trips per day", formatNumber(ev.Fleet.PodTripsPerDay)},
+		{"Pods", fmt.Sprint(ev.Fleet.Pods)},
+		{"Peak hour", fmt.Sprintf("%02d:00, %s pod trips", ev.Fleet.Demand.PeakHour, formatNumber(ev.Fleet.Demand.PeakPodTripsPerHour))},
+		{"Average pods in use", formatNumber(ev.Fleet.Demand.AveragePods)},
+		{"Idle pods on average", formatNumber(ev.Fleet.Demand.IdlePods)},
+		{"Line capacity", formatNumber(math.Floor(ev.Capacity.MaxThroughput)) + " " + ev.Capacity.Unit + " / day"},
+		{"Bottleneck headway", formatNumber(ev.Capacity.Bottleneck.Headway) + " s"},
+	})
+	if w := ev.Capacity.Warning(); w != "" {
+		flow.Warning("Warning: " + w)
+	}
+
+	if len(ev.Stations) > 0 {
+		stations := [][]string{{"Station", "km", "Dwell (s)", "Berths", "Capex (€)", "Utilisation"}}
+		for _, st := range ev.Stations {
+			utilisation := formatNumber(st.Utilisation*100) + " %"
+			if st.Unlimited {
+				utilisation = "unlimited"
+			}
+			stations = append(stations, []string{st.Name, formatNumber(st.Distance / 1000), formatNumber(st.Dwell), fmt.Sprint(st.Berths),
+				formatNumber(st.capex()), utilisation})
+		}
+		flow.Table("Stations", []float64{150, 210, 270, 320, 420}, stations)
+	}
+
+	return doc
+}
+
+func tunnelSummary(vp *VerticalProfile) string {
+	if vp == nil {
+		return "no terrain data"
+	}
+	return fmt.Sprintf("%d, %s km", len(vp.Tunnels), formatNumber(vp.TunnelLength/1000))
+}
+
+func reportHeader(p *pdfPage, name string, now time.Time) {
+	p.Text(50, 800, 18, true, "Route feasibility report")
+	p.Text(50, 782, 10, false, name+" - "+now.Format("2 January 2006"))
+	p.Stroke(0, 0, 0, 0.5)
+	p.Line(50, 775, 545, 775)
+}
+
+// Rows of text under a title, with columns starting at the given offsets from x.
+// Returns the y below the last row
+func reportTable(p *pdfPage, x float64, y float64, title string, cols []float64, rows [][]string) float64 {
+
+	p.Text(x, y, 11, true, title)
+	y -= 16
+	for _, row := range rows {
+		reportRow(p, x, y, cols, row)
+		y -= 13
+	}
+	return y
+}
+
+func reportRow(p *pdfPage, x float64, y float64, cols []float64, row []string) {
+	for i, cell := range row {
+		cx := x
+		if i > 0 {
+			cx += cols[i-1]
+		}
+		p.Text(cx, y, 9, false, cell)
+	}
+}
+
+// Tables one under another at the left margin, going on to a new page with
+// the header whenever a row would fall below reportBottom
+type reportFlow struct {
+	doc  *pdfDoc
+	name string
+	now  time.Time
+	p    *pdfPage
+	y    float64
+}
+
+func (f *reportFlow) NewPage() {
+	f.p = f.doc.AddPage()
+	reportHeader(f.p, f.name, f.now)
+	f.y = reportTop
+}
+
+func (f *reportFlow) Table(title string, cols []float64, rows [][]string) {
+
+	if f.y < reportTop {
+		f.y -= 20
+	}
+	// a title is not left at the foot of a page without its first row
+	if f.y-16 < reportBottom {
+		f.NewPage()
+	}
+	f.p.Text(50, f.y, 11, true, title)
+	f.y -= 16
+	for _, row := range rows {
+		if f.y < reportBottom {
+			f.NewPage()
+		}
+		reportRow(f.p, 50, f.y, cols, row)
+		f.y -= 13
+	}
+}
+
+// A line of red text under the last table
+func (f *reportFlow) Warning(s string) {
+
+	f.y -= 6
+	if f.y < reportBottom {
+		f.NewPage()
+	}
+	f.p.Fill(0.8, 0.1, 0.1)
+	f.p.Text(50, f.y, 9, true, s)
+	f.p.Fill(0, 0, 0)
+	f.y -= 13
+}
+
+// Static map of the alignment on the UTM grid of its first point, with the vertices,
+// a scale bar and a north arrow
+func reportMap(p *pdfPage, al Alignment, x float64, y float64, w float64, h float64) {
+
+	p.Stroke(0, 0, 0, 0.5)
+	p.Rect(x, y, w, h, false)
+	if len(al.Corners) == 0 {
+		return
+	}
+
+	proj := utmZoneFor(al.Corners[0].Pt)
+
+	var xs, ys []float64
+	add := func(ll LatLng) {
+		e, n := proj.Forward(ll)
+		xs = append(xs, e)
+		ys = append(ys, n)
+	}
+	for _, e := range al.Elements {
+		steps := 1
+		if e.Kind != "line" && e.Angle != 0 {
+			steps = int(math.Ceil(math.Abs(e.Angle) / 5))
+		}
+		for i := 0; i <= steps; i++ {
+			add(e.PointAt(e.Length * float64(i) / float64(steps)))
+		}
+	}
+	if len(xs) == 0 {
+		add(al.Corners[0].Pt)
+	}
+
+	minX, maxX := minMax(xs)
+	minY, maxY := minMax(ys)
+	for _, c := range al.Corners {
+		e, n := proj.Forward(c.Pt)
+		minX, maxX = math.Min(minX, e), math.Max(maxX, e)
+		minY, maxY = math.Min(minY, n), math.Max(maxY, n)
+	}
+
+	const margin = 20
+	scale := math.Min((w-2*margin)/math.Max(maxX-minX, 1), (h-2*margin)/math.Max(maxY-minY, 1))
+	ox := x + w/2 - (minX+maxX)/2*scale
+	oy := y + h/2 - (minY+maxY)/2*scale
+
+	// vertex polygon in grey, then the route in red
+	p.Stroke(0.6, 0.6, 0.6, 0.5)
+	var cx, cy []float64
+	for _, c := range al.Corners {
+		e, n := proj.Forward(c.Pt)
+		cx = append(cx, ox+e*scale)
+		cy = append(cy, oy+n*scale)
+	}
+	p.Polyline(cx, cy)
+	p.Fill(0.6, 0.6, 0.6)
+	for i := range cx {
+		p.Rect(cx[i]-1.5, cy[i]-1.5, 3, 3, true)
+	}
+
+	for i := range xs {
+		xs[i] = ox + xs[i]*scale
+		ys[i] = oy + ys[i]*scale
+	}
+	p.Stroke(0.8, 0.1, 0.1, 1.5)
+	p.Polyline(xs, ys)
+
+	p.Fill(0, 0, 0)
+	p.Text(cx[0]+4, cy[0]+4, 8, true, "Start")
+	p.Text(cx[len(cx)-1]+4, cy[len(cy)-1]+4, 8, true, "End")
+
+	// scale bar of a round length about a fifth of the map
+	bar := niceStep((w / 5) / scale)
+	p.Stroke(0, 0, 0, 1.5)
+	p.Line(x+10, y+10, x+10+bar*scale, y+10)
+	p.Text(x+10, y+14, 7, false, formatNumber(bar/1000)+" km")
+
+	p.Stroke(0, 0, 0, 1)
+	p.Line(x+w-15, y+h-35, x+w-15, y+h-15)
+	p.Line(x+w-15, y+h-15, x+w-19, y+h-22)
+	p.Line(x+w-15, y+h-15, x+w-11, y+h-22)
+	p.Text(x+w-18, y+h-45, 8, true, "N")
+	p.Text(x+5, y+h-12, 7, false, proj.Name())
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Actions on a saved route, served as /routes/{id}/{action}
+var routeActions = map[string]func(http.ResponseWriter, *http.Request, Route){
+	"landxml":   landXMLHandler,
+	"projected": projectedRouteHandler,
+	"length":    routeLengthHandler,
+
+	"evaluation":      evaluationHandler,
+	"evaluation.csv":  evaluationExportHandler,
+	"evaluation.xlsx": evaluationExportHandler,
+	"report.pdf":      reportHandler,
+	"elevation":       elevationHandler,
+	"vertical":        verticalHandler,
+	"fleet":           fleetHandler,
+	"comfort":         comfortHandler,
+	"validate":        validateHandler,
+	"optimise":        optimiseHandler,
+	"assignment":      routeAssignmentHandler,
+}
+
+func routesHandler(w http.ResponseWriter, r *http.Request) {
+
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/routes/"), "/"), "/")
+	if len(parts) != 2 {
+		http.NotFound(w, r)
+		return
+	}
+
+	id, err := strconv.Atoi(parts[0])
+	if err != nil {
+		http.Error(w, "invalid route id", http.StatusBadRequest)
+		return
+	}
+
+	action, ok := routeActions[parts[1]]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	route, err := fetchRoute(id)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		log.Print("loading route ", id, ": ", err)
+		http.Error(w, "could not load route", http.StatusInternalServerError)
+		return
+	}
+	// validate reports what checkRoute finds along with the rest
+	if err := checkRoute(route); err != nil && parts[1] != "validate" {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	// clothoids and cant can be tried out without saving the route again
+	if v := r.URL.Query().Get("max_jerk"); v != "" {
+		jerk, err := strconv.ParseFloat(v, 64)
+		if err != nil || jerk < 0 || math.IsNaN(jerk) || math.IsInf(jerk, 0) {
+			http.Error(w, fmt.Sprintf("invalid max_jerk %q", v), http.StatusBadRequest)
+			return
+		}
+		route.MaxJerk = jerk
+	}
+	if v := r.URL.Query().Get("auto_cant"); v != "" {
+		auto, err := strconv.ParseBool(v)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid auto_cant %q", v), http.StatusBadRequest)
+			return
+		}
+		route.AutoCant = auto
+	}
+
+	action(w, r, route)
+}
+
+// Loads a route saved by saveRoute
+func fetchRoute(id int) (Route, error) {
+
+	var route Route
+	var name sql.NullString
+	var segments, stations []byte
+	var jerk sql.NullFloat64
+	var autoCant sql.NullBool
+
+	err := db.QueryRow("SELECT doc->>'name', doc->'segments', doc->'stations', (doc->>'max_jerk')::float, (doc->>'auto_cant')::boolean FROM routes WHERE id = ($1)", id).Scan(&name, &segments, &stations, &jerk, &autoCant)
+	if err != nil {
+		return route, err
+	}
+
+	route.ID = id
+	route.Name = name.String
+	route.MaxJerk = jerk.Float64
+	route.AutoCant = autoCant.Bool
+	if err := json.Unmarshal(segments, &route.Segments); err != nil {
+		return route, err
+	}
+	if stations != nil {
+		err = json.Unmarshal(stations, &route.Stations)
+	}
+
+	return route, err
+}
+
+// File name for a download of the route, e.g. "Gdansk_Torun.xml"
+func exportFileName(route Route, ext string) string {
+	return routeFileBase(route) + "." + ext
+}
+
+func routeFileBase(route Route) string {
+
+	name := strings.Map(func(r rune) rune {
+		if r == ' ' || r == '/' || r == '\\' || r == '"' {
+			return '_'
+		}
+		return r
+	}, strings.TrimSpace(route.Name))
+
+	if name == "" {
+		name = "route"
+	}
+	return name
+}
+
+// Geodesic model asked for with ?geodesic=spherical, ellipsoidal by default
+func requestGeodesic(r *http.Request) (Geodesic, error) {
+	return parseGeodesic(r.URL.Query().Get("geodesic"))
+}
+
+type lengthComparison struct {
+	Ellipsoidal      float64 `json:"ellipsoidal"`
+	Spherical        float64 `json:"spherical"`
+	Difference       float64 `json:"difference"`
+	EllipsoidalCapex int     `json:"ellipsoidal_capex"`
+	SphericalCapex   int     `json:"spherical_capex"`
+}
+
+// GET /routes/{id}/length
+// Route length and capex on the WGS84 ellipsoid and on the browser's sphere
+func routeLengthHandler(w http.ResponseWriter, r *http.Request, route Route) {
+
+	ell := makeAlignment(route, ellipsoidal).Length
+	sph := makeAlignment(route, spherical).Length
+
+	writeJSON(w, lengthComparison{ell, sph, ell - sph, calcCapex(ell), calcCapex(sph)})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
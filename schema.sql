@@ -0,0 +1,3 @@
+-- Tables the server reads and writes, each row one JSON document as posted
+CREATE TABLE routes (id serial primary key, doc jsonb);
+CREATE TABLE networks (id serial primary key, doc jsonb);
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	serviceFreight   = "freight"
+	servicePassenger = "passenger"
+
+	unitTEU        = "TEU"
+	unitTonnes     = "t"
+	unitPassengers = "passengers"
+)
+
+// What the line carries. Throughput is counted in Unit, and each pod trip
+// carries the pod's payload in that unit times the load factor
+type ServiceMode struct {
+	Mode       string  `json:"mode"` // "freight" or "passenger"
+	Unit       string  `json:"unit"` // "TEU" or "t" for freight, "passengers"
+	LoadFactor float64 `json:"load_factor"`
+}
+
+func defaultServiceMode() ServiceMode {
+	return ServiceMode{serviceFreight, unitTEU, 1}
+}
+
+// Units a pod has room for
+func (s ServiceMode) payload(pod Pod) float64 {
+	switch s.Unit {
+	case unitTonnes:
+		return pod.PayloadT
+	case unitPassengers:
+		return float64(pod.NumPax)
+	}
+	return pod.PayloadTEU
+}
+
+// Units a pod trip carries on average
+func (s ServiceMode) perPod(pod Pod) float64 {
+	return s.payload(pod) * s.LoadFactor
+}
+
+// Loaded pod trips needed for throughput units
+func (s ServiceMode) podTrips(throughput float64, pod Pod) float64 {
+	return throughput / s.perPod(pod)
+}
+
+func (s ServiceMode) label() string {
+	if s.Unit == unitTonnes {
+		return "tonnes"
+	}
+	return s.Unit
+}
+
+func (s ServiceMode) single() string {
+	switch s.Unit {
+	case unitTonnes:
+		return "tonne"
+	case unitPassengers:
+		return "passenger"
+	}
+	return s.Unit
+}
+
+// Reads mode, unit and load_factor
+func serviceModeFromQuery(q url.Values, pod Pod) (ServiceMode, error) {
+
+	s := ServiceMode{Mode: q.Get("mode"), Unit: q.Get("unit")}
+	if v := q.Get("load_factor"); v != "" {
+		x, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return s, fmt.Errorf("invalid load_factor %q", v)
+		}
+		s.LoadFactor = x
+	}
+	return s.complete(pod)
+}
+
+// Fills in what was left out and checks the rest. The mode is freight for pods
+// that carry containers and passenger for the others. Freight is in TEU unless
+// the unit is tonnes and fills the pod, passenger service fills 80 % of the seats
+func (s ServiceMode) complete(pod Pod) (ServiceMode, error) {
+
+	if s.Mode == "" && pod.PayloadTEU == 0 && pod.NumPax > 0 {
+		s.Mode = servicePassenger
+	}
+
+	switch s.Mode {
+	case "", serviceFreight:
+		s.Mode = serviceFreight
+		switch strings.ToLower(s.Unit) {
+		case "", "teu":
+			s.Unit = unitTEU
+		case unitTonnes, "tonnes":
+			s.Unit = unitTonnes
+		default:
+			return s, fmt.Errorf("freight unit must be teu or tonnes")
+		}
+		if s.LoadFactor == 0 {
+			s.LoadFactor = 1
+		}
+	case servicePassenger:
+		if s.Unit != "" && s.Unit != unitPassengers {
+			return s, fmt.Errorf("passenger service is counted in passengers")
+		}
+		s.Unit = unitPassengers
+		if s.LoadFactor == 0 {
+			s.LoadFactor = 0.8
+		}
+	default:
+		return s, fmt.Errorf("mode must be freight or passenger")
+	}
+
+	if s.LoadFactor <= 0 || s.LoadFactor > 1 || math.IsNaN(s.LoadFactor) {
+		return s, fmt.Errorf("load factor must be over 0 and at most 1")
+	}
+	if s.payload(pod) <= 0 {
+		return s, fmt.Errorf("pod %s carries no %s", pod.Name, s.label())
+	}
+	return s, nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestServiceModeFromQuery(t *testing.T) {
+
+	freight, cheetah := podPresets[0], podPresets[1]
+	tests := []struct {
+		query      string
+		pod        Pod
+		mode, unit string
+		load       float64
+		perPod     float64
+		ok         bool
+	}{
+		{"", freight, serviceFreight, unitTEU, 1, 1, true},
+		{"", cheetah, servicePassenger, unitPassengers, 0.8, 27 * 0.8, true},
+		{"unit=tonnes&load_factor=0.5", freight, serviceFreight, unitTonnes, 0.5, 12, true},
+		{"mode=freight&unit=TEU", freight, serviceFreight, unitTEU, 1, 1, true},
+		{"mode=passenger", freight, servicePassenger, unitPassengers, 0.8, 0.8, true},
+		{"mode=freight", cheetah, "", "", 0, 0, false}, // no payload
+		{"mode=passenger&unit=teu", freight, "", "", 0, 0, false},
+		{"unit=pallets", freight, "", "", 0, 0, false},
+		{"mode=mail", freight, "", "", 0, 0, false},
+		{"load_factor=1.2", freight, "", "", 0, 0, false},
+		{"load_factor=-0.1", freight, "", "", 0, 0, false},
+		{"load_factor=full", freight, "", "", 0, 0, false},
+		{"load_factor=NaN", freight, "", "", 0, 0, false},
+		{"load_factor=Inf", freight, "", "", 0, 0, false},
+	}
+	for _, tt := range tests {
+		q, _ := url.ParseQuery(tt.query)
+		s, err := serviceModeFromQuery(q, tt.pod)
+		if (err == nil) != tt.ok {
+			t.Errorf("%q with %s: error %v, want ok %v", tt.query, tt.pod.Name, err, tt.ok)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if s.Mode != tt.mode || s.Unit != tt.unit || s.LoadFactor != tt.load || s.perPod(tt.pod) != tt.perPod {
+			t.Errorf("%q with %s: %+v carrying %v, want %s %s %v carrying %v",
+				tt.query, tt.pod.Name, s, s.perPod(tt.pod), tt.mode, tt.unit, tt.load, tt.perPod)
+		}
+	}
+}
+
+func TestServicePodTrips(t *testing.T) {
+
+	s := ServiceMode{servicePassenger, unitPassengers, 0.5}
+	pod := podPresets[1]
+	if got := s.podTrips(2700, pod); got != 200 {
+		t.Errorf("%v pod trips for 2700 passengers at half load, want 200", got)
+	}
+	if s.label() != "passengers" || s.single() != "passenger" {
+		t.Errorf("labels %q and %q", s.label(), s.single())
+	}
+	if tonnes := (ServiceMode{serviceFreight, unitTonnes, 1}); tonnes.label() != "tonnes" || tonnes.single() != "tonne" {
+		t.Errorf("labels %q and %q", tonnes.label(), tonnes.single())
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import "math"
+
+// Distance apart of the speed points, as MinSpeedLineLgth in route.js
+const minSpeedLineLgth float64 = 200.0
+
+// Pause at a stop in seconds
+const stopPause float64 = 10.0
+
+// A short piece of the route the speed is worked out for
+type SpeedSegment struct {
+	Distance   float64 `json:"distance"`          // from the start to the end of the segment, m
+	Length     float64 `json:"length"`            // m
+	Radius     float64 `json:"radius"`            // -1 on a straight, 0 at a stop
+	Cant       float64 `json:"cant"`              // degrees at the tight end of the segment
+	Grade      float64 `json:"grade"`             // rise over run in the direction of travel
+	VertRadius float64 `json:"vert_radius"`       // tightest vertical curve, 0 on a straight grade
+	SpeedLimit float64 `json:"speed_limit"`       // m/s
+	Speed      float64 `json:"speed"`             // m/s at the end of the segment
+	Time       float64 `json:"time"`              // s
+	RouteTime  float64 `json:"route_time"`        // s from the start to the end of the segment
+	Energy     float64 `json:"energy"`            // kJ
+	Battery    float64 `json:"battery"`           // kWh used from the start
+	Station    string  `json:"station,omitempty"` // where the segment ends at a station
+
+	dwell     float64 // s stopped at the end, stopPause when 0
+	vertex    int     // of the curve the segment is on
+	cantShare float64 // of the curve's cant, 0 off curves
+}
+
+type SpeedProfile struct {
+	Pod        Pod            `json:"pod"`
+	Segments   []SpeedSegment `json:"segments"`
+	Distance   float64        `json:"distance"`    // m
+	Time       float64        `json:"time"`        // s
+	EnergyKwh  float64        `json:"energy_kwh"`  // net energy for the trip
+	MaxBattery float64        `json:"max_battery"` // kWh
+	AvgSpeed   float64        `json:"avg_speed"`   // m/s
+}
+
+// Splits the alignment into short segments, each with the radius that limits
+// its speed (MakeSectionsFromRoute and MakeSegmentArray in route.js)
+func makeSpeedSegments(al Alignment) []SpeedSegment {
+
+	var segs []SpeedSegment
+	dist := 0.0
+
+	last := len(al.Corners) - 1
+
+	for _, e := range al.Elements {
+		rad := e.Radius
+		if e.Kind == "line" {
+			rad = -1
+			if e.Stop {
+				rad = 0
+			}
+		}
+
+		numSegs := 1 + int(math.Round(e.Length/minSpeedLineLgth))
+		step := e.Length / float64(numSegs)
+		for j := 1; j <= numSegs; j++ {
+			dist += step
+			seg := SpeedSegment{Distance: dist, Length: step, Radius: rad}
+			if e.Kind == "arc" {
+				seg.vertex, seg.cantShare = e.Vertex, 1
+			}
+			if e.Kind == "spiral" {
+				// the radius at the tight end of the step, the cant builds up with the curvature
+				tight := float64(j) * step
+				if !e.Entry {
+					tight = e.Length - float64(j-1)*step
+				}
+				seg.Radius = e.Radius * e.Length / tight
+				seg.vertex, seg.cantShare = e.Vertex, tight/e.Length
+			}
+			if rad == 0 && j != numSegs {
+				seg.Radius = -1 // only the end of the line is the stop
+			}
+			if rad == 0 && j == numSegs {
+				seg.Station = al.Corners[e.Vertex].Station
+				if e.Vertex != last {
+					seg.dwell = al.Corners[e.Vertex].Dwell // terminal dwell is loading, not travel
+				}
+			}
+			segs = append(segs, seg)
+		}
+	}
+	return segs
+}
+
+// Works out the speed, time and energy along the route for a pod. Braking is found
+// by running from the finish, and the slower of the two runs is kept (CalcSpeedArray).
+// The speed limit is the lower of that from the horizontal radius, less what the
+// cant takes out, and that from the vertical curves. vp is the tube grade line, nil to take the route as flat
+func simulateSpeed(al Alignment, pod Pod, vp *VerticalProfile) SpeedProfile {
+
+	segs := makeSpeedSegments(al)
+	n := len(segs)
+
+	for i := range segs {
+		segs[i].SpeedLimit = pod.MaxSpeed
+		if segs[i].Radius != -1 {
+			if segs[i].cantShare > 0 {
+				segs[i].Cant = al.CantAt(segs[i].vertex, pod) * segs[i].cantShare
+			}
+			segs[i].SpeedLimit = math.Min(pod.MaxSpeed, cornerSpeed(segs[i].Radius, segs[i].Cant, pod))
+		}
+
+		if vp == nil || segs[i].Length == 0 {
+			continue
+		}
+		start := segs[i].Distance - segs[i].Length
+		segs[i].Grade = (vp.TubeAt(segs[i].Distance) - vp.TubeAt(start)) / segs[i].Length
+		segs[i].VertRadius = vp.RadiusIn(start, segs[i].Distance)
+		if segs[i].VertRadius > 0 {
+			segs[i].SpeedLimit = math.Min(segs[i].SpeedLimit, math.Sqrt(segs[i].VertRadius*pod.MaxVertMss))
+		}
+	}
+
+	type run struct{ speed, time, energy float64 }
+	rev := make([]run, n)
+	fwd := make([]run, n)
+
+	initSpeed := 0.0
+	for i := n - 1; i >= 0; i-- {
+		speed, time, energy := speedComputation(initSpeed, segs[i].SpeedLimit, segs[i].Length, segs[i].Grade, pod, false)
+		rev[i] = run{speed, time, energy}
+		initSpeed = speed
+	}
+
+	initSpeed = 0.0
+	for i := 0; i < n; i++ {
+		speed, time, energy := speedComputation(initSpeed, segs[i].SpeedLimit, segs[i].Length, segs[i].Grade, pod, true)
+		fwd[i] = run{speed, time, energy}
+		initSpeed = speed
+	}
+
+	prof := SpeedProfile{Pod: pod}
+	energyKj := 0.0
+	battery := 0.0
+
+	for i := range segs {
+		final := fwd[i]
+		if rev[i].speed <= fwd[i].speed {
+			final = rev[i]
+		}
+		if segs[i].SpeedLimit == 0 {
+			final.time = stopPause
+			if segs[i].dwell > 0 {
+				final.time = segs[i].dwell
+			}
+		}
+
+		prof.Time += final.time
+		energyKj += final.energy
+		if i > 0 {
+			battery += final.energy / 3600
+		}
+
+		segs[i].Speed = final.speed
+		segs[i].Time = final.time
+		segs[i].RouteTime = prof.Time
+		segs[i].Energy = final.energy
+		segs[i].Battery = battery
+		prof.MaxBattery = math.Max(prof.MaxBattery, battery)
+	}
+
+	prof.Segments = segs
+	prof.Distance = al.Length
+	prof.EnergyKwh = energyKj / 3600
+	if prof.Time > 0 {
+		prof.AvgSpeed = prof.Distance / prof.Time
+	}
+	return prof
+}
+
+// Speed at the end of a segment, the time taken and the energy used in kJ.
+// Braking is worked out as acceleration from the finish, so drag adds to it and
+// the energy is regenerated (SpeedComputation in route.js). The weight along a
+// grade counts as drag, so a climb slows the pod and helps it brake and a descent
+// does the opposite
+func speedComputation(initSpeed float64, targetSpeed float64, segDist float64, grade float64, pod Pod, accel bool) (float64, float64, float64) {
+
+	if initSpeed == 0 {
+		initSpeed = 5 // avoid div by zero later
+	}
+
+	aeroDrag := pod.AeroDrag * math.Pow(initSpeed/pod.MaxSpeed, 2)
+	tireDrag := pod.Mass / pod.TireLiftDrag * gravity
+	gradeDrag := pod.Mass * gravity * grade / math.Sqrt(1+grade*grade)
+	totDrag := aeroDrag + tireDrag + gradeDrag
+
+	if initSpeed == targetSpeed { // just cruising
+		time := segDist / initSpeed
+		power := totDrag * initSpeed / 1000
+		return initSpeed, time, motorEnergy(power, time, pod)
+	}
+
+	thrustLimAccel := totDrag + pod.MaxAccelMss*pod.Mass
+	maxMotorPwr := pod.MaxPower
+	if !accel {
+		thrustLimAccel = totDrag - pod.MaxAccelMss*pod.Mass
+		maxMotorPwr = -pod.MaxPower // regen braking
+	}
+	motorPwrLimAccel := thrustLimAccel * initSpeed / 1000
+
+	// accel rate if limited by max motor power. Up a steep grade this can be
+	// negative, in which case the pod slows down at full power
+	maxMotorThrust := maxMotorPwr * 1000 / initSpeed
+	maxThrust := maxMotorThrust - totDrag
+	accelRateMaxPwr := maxThrust / pod.Mass
+	if !accel {
+		accelRateMaxPwr = -accelRateMaxPwr
+	}
+
+	accelRateUsed := accelRateMaxPwr
+	power := maxMotorPwr
+	if accelRateMaxPwr > pod.MaxAccelMss {
+		accelRateUsed = pod.MaxAccelMss
+		power = motorPwrLimAccel
+	}
+
+	speedAtEnd := math.Sqrt(math.Max(initSpeed*initSpeed+2*accelRateUsed*segDist, 1))
+	time := 2 * segDist / (initSpeed + speedAtEnd)
+
+	energy := motorEnergy(power, time, pod)
+
+	if speedAtEnd > targetSpeed {
+		speedAtEnd = targetSpeed
+	}
+	return speedAtEnd, time, energy
+}
+
+// Energy in kJ drawn by the motor at power kW, or given back when power is negative
+func motorEnergy(power float64, time float64, pod Pod) float64 {
+	if power < 0 {
+		return power * time * pod.MotorEff // less energy on decel
+	}
+	return power * time / pod.MotorEff // more energy on accel
+}
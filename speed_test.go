@@ -0,0 +1,61 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSpeedComputationGrade(t *testing.T) {
+
+	pod := podPresets[0]
+
+	// accelerating at full power, a climb leaves the pod slower and a descent faster
+	flat, _, flatEnergy := speedComputation(100, pod.MaxSpeed, 1000, 0, pod, true)
+	up, _, _ := speedComputation(100, pod.MaxSpeed, 1000, 0.05, pod, true)
+	down, _, _ := speedComputation(100, pod.MaxSpeed, 1000, -0.05, pod, true)
+	if !(up < flat && flat < down) {
+		t.Errorf("speeds %v up, %v flat, %v down", up, flat, down)
+	}
+
+	// cruising, the weight along the grade is paid for on the way up
+	_, _, cruise := speedComputation(100, 100, 1000, 0, pod, true)
+	_, _, cruiseUp := speedComputation(100, 100, 1000, 0.02, pod, true)
+	wantUp := cruise + pod.Mass*gravity*0.02/math.Sqrt(1+0.02*0.02)*1000/1000/pod.MotorEff
+	if math.Abs(cruiseUp-wantUp) > 1e-6 || flatEnergy <= 0 {
+		t.Errorf("cruising up takes %v kJ, want %v", cruiseUp, wantUp)
+	}
+
+	// braking is run from the finish, so a climb helps and a descent works against it
+	brakeFlat, _, _ := speedComputation(100, pod.MaxSpeed, 1000, 0, pod, false)
+	brakeUp, _, _ := speedComputation(100, pod.MaxSpeed, 1000, 0.05, pod, false)
+	brakeDown, _, _ := speedComputation(100, pod.MaxSpeed, 1000, -0.05, pod, false)
+	if !(brakeDown < brakeFlat && brakeFlat <= brakeUp) {
+		t.Errorf("braking speeds %v down, %v flat, %v up", brakeDown, brakeFlat, brakeUp)
+	}
+
+	// too steep to climb at full power, the pod slows down
+	if slow, _, _ := speedComputation(100, pod.MaxSpeed, 1000, 0.5, pod, true); slow >= 100 {
+		t.Errorf("speed %v up a 50%% grade", slow)
+	}
+}
+
+func TestSimulateSpeedFlat(t *testing.T) {
+
+	route := Route{Segments: []Segment{{Lat: 52, Lng: 4}, {Lat: 52.3, Lng: 4, Rad: 3000}, {Lat: 52.3, Lng: 4.4}}}
+	pod := podPresets[0]
+	out := simulateSpeed(makeAlignment(route, ellipsoidal), pod, nil)
+	back := simulateSpeed(makeAlignment(reverseRoute(route), ellipsoidal), pod, nil)
+
+	// the same curve either way, the ends of its legs differ by the geodesics
+	if math.Abs(out.Time-back.Time) > 1e-3*out.Time || math.Abs(out.EnergyKwh-back.EnergyKwh) > 1e-2*out.EnergyKwh {
+		t.Errorf("out %v s %v kWh, back %v s %v kWh on flat ground", out.Time, out.EnergyKwh, back.Time, back.EnergyKwh)
+	}
+	for i, s := range out.Segments {
+		if s.Speed > s.SpeedLimit+1e-9 || s.Grade != 0 {
+			t.Errorf("segment %d at %v m/s over its %v limit, grade %v", i, s.Speed, s.SpeedLimit, s.Grade)
+		}
+	}
+	if last := out.Segments[len(out.Segments)-1]; last.SpeedLimit != 0 || math.Abs(last.Distance-out.Distance) > 1e-6 {
+		t.Errorf("the run ends at %v m with a limit of %v, want a stop at %v m", last.Distance, last.SpeedLimit, out.Distance)
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import "fmt"
+
+var stationCost float64 = 25000000.0 // € for a station with no berths
+var berthCost float64 = 4000000.0    // € per berth
+
+// A named stop at one of the route vertices. Pods always stop at a station,
+// whatever the radius of its vertex
+type Station struct {
+	Name   string  `json:"name"`
+	Vertex int     `json:"vertex"`         // index into Route.Segments
+	Dwell  float64 `json:"dwell"`          // s, at the ends this is the loading time
+	Berths int     `json:"berths"`         // pods that can be at the platforms at once
+	Cost   float64 `json:"cost,omitempty"` // €, worked out from the berths when 0
+}
+
+// A station as listed in an evaluation
+type StationReport struct {
+	Station
+	Distance    float64 `json:"distance"`      // m from the start of the route
+	PodsPerHour float64 `json:"pods_per_hour"` // the berths can handle, 0 when Unlimited
+	Demand      float64 `json:"demand"`        // pods per hour calling
+	Utilisation float64 `json:"utilisation"`
+	Unlimited   bool    `json:"unlimited,omitempty"` // no dwell, so the berths never hold pods up
+}
+
+func (s Station) capex() float64 {
+	if s.Cost > 0 {
+		return s.Cost
+	}
+	return stationCost + berthCost*float64(s.Berths)
+}
+
+func checkStations(route Route) error {
+
+	seen := make(map[int]bool)
+	for i, s := range route.Stations {
+		if s.Vertex < 0 || s.Vertex >= len(route.Segments) {
+			return fmt.Errorf("station %d (%s) is at vertex %d, the route has %d", i, s.Name, s.Vertex, len(route.Segments))
+		}
+		if seen[s.Vertex] {
+			return fmt.Errorf("more than one station at vertex %d", s.Vertex)
+		}
+		if s.Dwell < 0 || s.Berths < 1 || s.Cost < 0 {
+			return fmt.Errorf("station %d (%s) needs at least one berth and a dwell and cost that are not negative", i, s.Name)
+		}
+		seen[s.Vertex] = true
+	}
+	return nil
+}
+
+// Checks what the route brings with it beyond its coordinates
+func checkRoute(route Route) error {
+	if err := checkConstructionTypes(route); err != nil {
+		return err
+	}
+	for i, s := range route.Segments {
+		if s.Spiral < 0 {
+			return fmt.Errorf("segment %d has a negative spiral", i)
+		}
+		if s.Cant < 0 || s.Cant > maxCant {
+			return fmt.Errorf("segment %d has a cant of %g degrees, it must be 0 to %g", i, s.Cant, maxCant)
+		}
+	}
+	if route.MaxJerk < 0 {
+		return fmt.Errorf("max_jerk may not be negative")
+	}
+	return checkStations(route)
+}
+
+func (r Route) stationAt(vertex int) (Station, bool) {
+	for _, s := range r.Stations {
+		if s.Vertex == vertex {
+			return s, true
+		}
+	}
+	return Station{}, false
+}
+
+// Loading time in minutes at each end of the route, the terminal dwell where
+// there is a station and loadingTime where there is not
+func terminalLoading(route Route, loadingTime float64) (float64, float64) {
+
+	start, end := loadingTime, loadingTime
+	if s, ok := route.stationAt(0); ok {
+		start = s.Dwell / 60
+	}
+	if s, ok := route.stationAt(len(route.Segments) - 1); ok {
+		end = s.Dwell / 60
+	}
+	return start, end
+}
+
+// Where the stations are and how busy their berths get. Pods call at the
+// terminals once a round trip and at the others once each way
+func stationReports(route Route, al Alignment, params EvalParams) []StationReport {
+
+	st := al.VertexStations()
+	podsPerHour := params.Service.podTrips(params.Throughput, params.Pod) / 24
+	start, end := terminalLoading(route, params.LoadingTime)
+
+	var reps []StationReport
+	for _, s := range route.Stations {
+		rep := StationReport{Station: s, Distance: st[s.Vertex], Demand: 2 * podsPerHour}
+
+		dwell := s.Dwell
+		switch s.Vertex {
+		case 0:
+			dwell, rep.Demand = start*60, podsPerHour
+		case len(route.Segments) - 1:
+			dwell, rep.Demand = end*60, podsPerHour
+		}
+
+		if dwell > 0 {
+			rep.PodsPerHour = float64(s.Berths) * 3600 / dwell
+			rep.Utilisation = rep.Demand / rep.PodsPerHour
+		} else {
+			rep.Unlimited = true
+		}
+		reps = append(reps, rep)
+	}
+	return reps
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestStationReportsZeroDwell(t *testing.T) {
+
+	route := Route{
+		Segments: []Segment{{Lat: 52, Lng: 4}, {Lat: 52.1, Lng: 4.1}, {Lat: 52.2, Lng: 4.3}},
+		Stations: []Station{{Name: "middle", Vertex: 1, Berths: 2}},
+	}
+	if err := checkRoute(route); err != nil {
+		t.Fatal(err)
+	}
+	ev := evaluateRoute(route, defaultEvalParams())
+	if len(ev.Stations) != 1 || !ev.Stations[0].Unlimited || ev.Stations[0].PodsPerHour != 0 {
+		t.Errorf("station with no dwell: %+v", ev.Stations)
+	}
+	if _, err := json.Marshal(ev); err != nil {
+		t.Errorf("evaluation does not marshal: %v", err)
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+)
+
+const minRadius float64 = 5.0 // m, MinRadius in route.js
+
+// How close two vertices may be before they count as one, m
+var duplicateVertexM float64 = 1.0
+
+// Speed limits under this share of the pod's top speed are reported, unless
+// min_speed is given
+var minSpeedShare float64 = 0.5
+
+const (
+	severityError   = "error"   // the route is not built as drawn
+	severityWarning = "warning" // it is, but slower or with smaller curves than asked for
+)
+
+type ValidationIssue struct {
+	Vertex   int    `json:"vertex"` // -1 for the route as a whole
+	Severity string `json:"severity"`
+	Check    string `json:"check"`
+	Message  string `json:"message"`
+}
+
+type RouteValidation struct {
+	ID     int               `json:"id,omitempty"`
+	Route  string            `json:"route"`
+	Valid  bool              `json:"valid"` // no errors, warnings allowed
+	Issues []ValidationIssue `json:"issues"`
+}
+
+func (v *RouteValidation) add(vertex int, severity string, check string, format string, a ...interface{}) {
+	v.Issues = append(v.Issues, ValidationIssue{vertex, severity, check, fmt.Sprintf(format, a...)})
+	if severity == severityError {
+		v.Valid = false
+	}
+}
+
+// Reports what makeAlignment and route.js would quietly fix or clamp. The speed
+// limits are only checked once the geometry has no errors. minSpeed is in m/s
+func validateRoute(route Route, params EvalParams, minSpeed float64) RouteValidation {
+
+	v := RouteValidation{ID: route.ID, Route: route.Name, Valid: true, Issues: []ValidationIssue{}}
+
+	geo, err := parseGeodesic(params.Geodesic)
+	if err != nil {
+		geo = ellipsoidal
+	}
+
+	n := len(route.Segments)
+	if n < 2 {
+		v.add(-1, severityError, "vertices", "the route needs at least two vertices, it has %d", n)
+		return v
+	}
+	if err := checkRoute(route); err != nil {
+		v.add(-1, severityError, "route", "%v", err)
+		return v
+	}
+
+	// any two vertices, not only neighbours, as a route that comes back to a
+	// vertex touches itself where selfIntersections sees no crossing
+	legs := make([]float64, n-1)
+	for j := 1; j < n; j++ {
+		for i := 0; i < j; i++ {
+			d := geo.Distance(route.Segments[i].LatLng(), route.Segments[j].LatLng())
+			if i == j-1 {
+				legs[i] = d
+			}
+			if d < duplicateVertexM {
+				v.add(j, severityError, "duplicate", "vertex %d is %.2f m from vertex %d", j, d, i)
+			}
+		}
+	}
+	if !v.Valid {
+		return v
+	}
+
+	// tangent lengths of the curves as asked for, before any clamping
+	al := makeAlignment(route, geo)
+	tangents := make([]float64, n)
+	for a := 1; a < n-1; a++ {
+		cnr := al.Corners[a]
+		rad := route.Segments[a].Rad
+		if rad <= 0 || cnr.Station != "" {
+			continue
+		}
+		if cnr.AngChange == -180 {
+			v.add(a, severityError, "reversal", "the route turns back on itself at vertex %d", a)
+			continue
+		}
+		if rad < minRadius {
+			v.add(a, severityError, "radius", "radius %.1f m is under the %.0f m minimum", rad, minRadius)
+			continue
+		}
+		spiral := route.Segments[a].Spiral
+		if spiral == 0 && route.MaxJerk > 0 {
+			spiral = jerkSpiral(rad, route.MaxJerk)
+		}
+		delta := degRad(math.Abs(cnr.AngChange))
+		tangents[a] = clothoidTangent(rad, math.Min(spiral, rad*delta), delta)
+
+		cant, design := al.CantAt(a, params.Pod), params.Pod.MaxSpeed
+		if cornerSpeed(rad, cant, params.Pod) < design {
+			v.add(a, severityWarning, "design_speed", "radius %.0f m is under the %.0f m needed for %.0f km/h",
+				rad, minCurveRadius(design, cant, params.Pod), design*3.6)
+		}
+		if cnr.Rad < rad {
+			v.add(a, severityWarning, "short_leg", "the legs are too short for radius %.0f m, it is cut to %.0f m",
+				rad, cnr.Rad)
+		}
+	}
+	// makeAlignment shrinks both curves to fit, so the route is still built
+	for i, l := range legs {
+		if t := tangents[i] + tangents[i+1]; t > l {
+			v.add(i+1, severityWarning, "overlap", "the curves at vertices %d and %d need %.0f m of the %.0f m leg between them",
+				i, i+1, t, l)
+		}
+	}
+	for _, x := range selfIntersections(route) {
+		v.add(x[1], severityError, "intersection", "the leg from vertex %d crosses the leg from vertex %d", x[1], x[0])
+	}
+	if !v.Valid {
+		return v
+	}
+
+	lowSpeedLimits(&v, evaluateRoute(route, params).Speed, al, minSpeed)
+	return v
+}
+
+// Pairs of legs that cross, each given by the vertex it starts from, the
+// earlier first. Legs are compared on the UTM grid of the first vertex
+func selfIntersections(route Route) [][2]int {
+
+	proj := utmZoneFor(route.Segments[0].LatLng())
+	pts := make([][2]float64, len(route.Segments))
+	for i, s := range route.Segments {
+		x, y := proj.Forward(s.LatLng())
+		pts[i] = [2]float64{x, y}
+	}
+
+	cross := func(o, a, b [2]float64) float64 {
+		return (a[0]-o[0])*(b[1]-o[1]) - (a[1]-o[1])*(b[0]-o[0])
+	}
+
+	var found [][2]int
+	for j := 2; j < len(pts)-1; j++ {
+		for i := 0; i < j-1; i++ {
+			p1, p2, q1, q2 := pts[i], pts[i+1], pts[j], pts[j+1]
+			d1, d2 := cross(q1, q2, p1), cross(q1, q2, p2)
+			d3, d4 := cross(p1, p2, q1), cross(p1, p2, q2)
+			if d1*d2 < 0 && d3*d4 < 0 {
+				found = append(found, [2]int{i, j})
+			}
+		}
+	}
+	return found
+}
+
+// One warning for each run of speed segments limited under minSpeed by a curve,
+// horizontal or vertical
+func lowSpeedLimits(v *RouteValidation, prof SpeedProfile, al Alignment, minSpeed float64) {
+
+	vertexAt := func(d float64) int {
+		for _, e := range al.Elements {
+			if d <= e.Station+e.Length {
+				return e.Vertex
+			}
+		}
+		return len(al.Corners) - 1
+	}
+
+	var low *ValidationIssue
+	var from, to, lowest float64
+	flush := func() {
+		if low != nil {
+			low.Message = fmt.Sprintf("speed limit down to %.0f km/h from %.2f to %.2f km, under %.0f km/h",
+				lowest*3.6, from/1000, to/1000, minSpeed*3.6)
+			v.Issues = append(v.Issues, *low)
+			low = nil
+		}
+	}
+
+	for _, seg := range prof.Segments {
+		start := seg.Distance - seg.Length
+		vertex := vertexAt(start + seg.Length/2)
+		limited := seg.SpeedLimit > 0 && seg.SpeedLimit < minSpeed && seg.SpeedLimit < prof.Pod.MaxSpeed
+		if !limited || (low != nil && low.Vertex != vertex) {
+			flush()
+		}
+		if !limited {
+			continue
+		}
+		if low == nil {
+			low = &ValidationIssue{Vertex: vertex, Severity: severityWarning, Check: "speed_limit"}
+			from, lowest = start, seg.SpeedLimit
+		}
+		to = seg.Distance
+		lowest = math.Min(lowest, seg.SpeedLimit)
+	}
+	flush()
+}
+
+// GET /routes/{id}/validate with the settings panel values as query parameters
+// and min_speed in km/h
+func validateHandler(w http.ResponseWriter, r *http.Request, route Route) {
+
+	params, err := evalParamsFromQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	minSpeed := params.Pod.MaxSpeed * minSpeedShare
+	if v := r.URL.Query().Get("min_speed"); v != "" {
+		x, err := strconv.ParseFloat(v, 64)
+		if err != nil || x < 0 || math.IsNaN(x) || math.IsInf(x, 0) {
+			http.Error(w, fmt.Sprintf("invalid min_speed %q", v), http.StatusBadRequest)
+			return
+		}
+		minSpeed = x / 3.6
+	}
+
+	writeJSON(w, validateRoute(route, params, minSpeed))
+}
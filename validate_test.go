@@ -0,0 +1,89 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestValidateRoute(t *testing.T) {
+
+	params := defaultEvalParams()
+	a, b, c, d := Segment{Lat: 52, Lng: 4}, Segment{Lat: 52.05, Lng: 4}, Segment{Lat: 52.05, Lng: 4.08}, Segment{Lat: 52.1, Lng: 4.08}
+	corner := func(rad float64) Route {
+		b := b
+		b.Rad = rad
+		return Route{Segments: []Segment{a, b, c}}
+	}
+
+	tests := []struct {
+		name     string
+		route    Route
+		minSpeed float64
+		check    string
+		severity string
+		vertex   int
+		valid    bool
+	}{
+		{"one vertex", Route{Segments: []Segment{a}}, 0, "vertices", severityError, -1, false},
+		{"station off the route", Route{Segments: []Segment{a, b}, Stations: []Station{{Name: "X", Vertex: 5}}}, 0,
+			"route", severityError, -1, false},
+		{"adjacent repeat", Route{Segments: []Segment{a, a, b}}, 0, "duplicate", severityError, 1, false},
+		{"repeat further on", Route{Segments: []Segment{a, b, c, a}}, 0, "duplicate", severityError, 3, false},
+		{"turns back", Route{Segments: []Segment{a, {Lat: 52.05, Lng: 4, Rad: 1000}, {Lat: 52.02, Lng: 4}}}, 0,
+			"reversal", severityError, 1, false},
+		{"tiny radius", corner(3), 0, "radius", severityError, 1, false},
+		{"slow curve", corner(500), 0, "design_speed", severityWarning, 1, true},
+		{"curve too big for the legs", corner(20000), 0, "short_leg", severityWarning, 1, true},
+		{"curves meet on a leg", Route{Segments: []Segment{a, {Lat: 52.05, Lng: 4, Rad: 3000}, {Lat: 52.05, Lng: 4.08, Rad: 3000}, d}}, 0,
+			"overlap", severityWarning, 2, true},
+		{"legs cross", Route{Segments: []Segment{a, b, c, {Lat: 52.02, Lng: 3.95}}}, 0, "intersection", severityError, 2, false},
+		{"speed limit", corner(500), params.Pod.MaxSpeed, "speed_limit", severityWarning, 1, true},
+	}
+	for _, tt := range tests {
+		minSpeed := tt.minSpeed
+		if minSpeed == 0 {
+			minSpeed = params.Pod.MaxSpeed * minSpeedShare
+		}
+		v := validateRoute(tt.route, params, minSpeed)
+		if v.Valid != tt.valid {
+			t.Errorf("%s: valid %v, want %v: %v", tt.name, v.Valid, tt.valid, v.Issues)
+		}
+		found := false
+		for _, is := range v.Issues {
+			if is.Check == tt.check && is.Severity == tt.severity && is.Vertex == tt.vertex {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("%s: no %s %s at vertex %d in %v", tt.name, tt.severity, tt.check, tt.vertex, v.Issues)
+		}
+	}
+}
+
+func TestValidateRouteClean(t *testing.T) {
+
+	route := Route{Segments: []Segment{{Lat: 52, Lng: 4}, {Lat: 52.5, Lng: 4, Rad: 20000}, {Lat: 52.5, Lng: 4.8}}}
+	params := defaultEvalParams()
+	if v := validateRoute(route, params, 0); !v.Valid || len(v.Issues) > 0 {
+		t.Errorf("issues on a clean route: %v", v.Issues)
+	}
+}
+
+func TestValidateHandlerMinSpeed(t *testing.T) {
+
+	route := Route{Segments: []Segment{{Lat: 52, Lng: 4}, {Lat: 52.05, Lng: 4, Rad: 500}, {Lat: 52.05, Lng: 4.08}}}
+	for _, v := range []string{"-1", "NaN", "Inf", "slow"} {
+		w := httptest.NewRecorder()
+		validateHandler(w, httptest.NewRequest("GET", "/routes/1/validate?min_speed="+v, nil), route)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("min_speed=%s: status %d, want %d", v, w.Code, http.StatusBadRequest)
+		}
+	}
+	w := httptest.NewRecorder()
+	validateHandler(w, httptest.NewRequest("GET", "/routes/1/validate?min_speed=400", nil), route)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"speed_limit"`) {
+		t.Errorf("min_speed=400: status %d %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"sort"
+)
+
+var maxTubeGrade float64 = 0.04      // steepest grade of the tube
+var minCrestRadius float64 = 50000.0 // m
+var minSagRadius float64 = 30000.0   // m
+var pviTolerance float64 = 10.0      // how far the first cut of the grade line may stray from its target, m
+
+// Limits on the vertical alignment of the tube
+type VerticalLimits struct {
+	MaxGrade       float64 `json:"max_grade"`        // rise over run
+	MinCrestRadius float64 `json:"min_crest_radius"` // m
+	MinSagRadius   float64 `json:"min_sag_radius"`   // m
+}
+
+func defaultVerticalLimits() VerticalLimits {
+	return VerticalLimits{maxTubeGrade, minCrestRadius, minSagRadius}
+}
+
+// A point of vertical intersection of two grades, rounded off by a parabolic
+// vertical curve of length CurveLength centred on it
+type PVI struct {
+	Distance    float64 `json:"distance"` // m from the start of the route
+	Elevation   float64 `json:"elevation"`
+	GradeIn     float64 `json:"grade_in"`
+	GradeOut    float64 `json:"grade_out"`
+	Kind        string  `json:"kind,omitempty"` // "crest" or "sag"
+	Radius      float64 `json:"radius,omitempty"`
+	CurveLength float64 `json:"curve_length,omitempty"`
+}
+
+type VerticalSample struct {
+	Distance float64 `json:"distance"`
+	Ground   float64 `json:"ground"`
+	Tube     float64 `json:"tube"`
+	Height   float64 `json:"height"` // of the tube over the ground, -ve in a tunnel
+}
+
+// A stretch where the tube runs below the ground
+type Tunnel struct {
+	Start    float64 `json:"start"` // m from the start of the route
+	End      float64 `json:"end"`
+	MaxDepth float64 `json:"max_depth"`
+}
+
+type VerticalProfile struct {
+	Limits       VerticalLimits   `json:"limits"`
+	PVIs         []PVI            `json:"pvis"`
+	Samples      []VerticalSample `json:"samples"`
+	Tunnels      []Tunnel         `json:"tunnels"`
+	TunnelLength float64          `json:"tunnel_length"`
+	MaxHeight    float64          `json:"max_height"`
+
+	ground *ElevationProfile
+}
+
+// Works out a grade line for the tube over the ground profile. The target is
+// minPylonHeight over the smoothed ground. It is first cut down to a few
+// PVIs, which are fitted to the target, the grades between them are held to the
+// limit, and then PVIs are dropped one at a time until every vertical curve fits within its tangents
+// (halfLineRatio, as for the horizontal fillets). Where the line passes under
+// the ground it is in tunnel
+func designVertical(ground *ElevationProfile, lim VerticalLimits) VerticalProfile {
+
+	vp := VerticalProfile{Limits: lim, ground: ground}
+
+	s := ground.Samples
+	if len(s) < 2 {
+		return vp
+	}
+
+	// the line cannot follow features shorter than a vertical curve between
+	// opposite maximum grades, so the target is averaged over that length
+	window := 2 * lim.MaxGrade * math.Min(lim.MinCrestRadius, lim.MinSagRadius)
+	target := make([]PVI, len(s))
+	a, b, sum := 0, 0, 0.0
+	for i, p := range s {
+		for b < len(s) && s[b].Distance <= p.Distance+window/2 {
+			sum += s[b].Elevation
+			b++
+		}
+		for s[a].Distance < p.Distance-window/2 {
+			sum -= s[a].Elevation
+			a++
+		}
+		target[i] = PVI{Distance: p.Distance, Elevation: sum/float64(b-a) + minPylonHeight}
+	}
+	keep := simplifyProfile(target, pviTolerance)
+
+	for {
+		pvis := make([]PVI, len(keep))
+		for i, k := range keep {
+			pvis[i] = target[k]
+		}
+		fitElevations(pvis, target)
+		clampGrades(pvis, lim.MaxGrade)
+
+		worst, worstRatio := -1, 1.0
+		for i := 1; i < len(pvis)-1; i++ {
+			fitVerticalCurve(&pvis[i], pvis[i-1], pvis[i+1], lim)
+			avail := halfLineRatio * math.Min(pvis[i].Distance-pvis[i-1].Distance, pvis[i+1].Distance-pvis[i].Distance)
+			if r := pvis[i].CurveLength / 2 / avail; r > worstRatio {
+				worst, worstRatio = i, r
+			}
+		}
+		if worst < 0 {
+			vp.PVIs = pvis
+			break
+		}
+		keep = append(keep[:worst], keep[worst+1:]...)
+	}
+
+	var tunnel *Tunnel
+	for _, p := range s {
+		tube := vp.TubeAt(p.Distance)
+		h := tube - p.Elevation
+		vp.Samples = append(vp.Samples, VerticalSample{p.Distance, p.Elevation, tube, h})
+		vp.MaxHeight = math.Max(vp.MaxHeight, h)
+
+		if h >= 0 {
+			tunnel = nil
+			continue
+		}
+		if tunnel == nil {
+			vp.Tunnels = append(vp.Tunnels, Tunnel{Start: p.Distance, End: p.Distance})
+			tunnel = &vp.Tunnels[len(vp.Tunnels)-1]
+		}
+		tunnel.End = p.Distance
+		tunnel.MaxDepth = math.Max(tunnel.MaxDepth, -h)
+	}
+	for _, t := range vp.Tunnels {
+		vp.TunnelLength += t.End - t.Start
+	}
+
+	return vp
+}
+
+// Douglas-Peucker on the profile. Returns the indices of the points kept
+func simplifyProfile(pts []PVI, tol float64) []int {
+
+	keep := []int{0, len(pts) - 1}
+
+	var split func(a int, b int)
+	split = func(a int, b int) {
+		worst, worstOff := -1, tol
+		for i := a + 1; i < b; i++ {
+			t := (pts[i].Distance - pts[a].Distance) / (pts[b].Distance - pts[a].Distance)
+			off := math.Abs(pts[i].Elevation - (pts[a].Elevation + t*(pts[b].Elevation-pts[a].Elevation)))
+			if off > worstOff {
+				worst, worstOff = i, off
+			}
+		}
+		if worst < 0 {
+			return
+		}
+		keep = append(keep, worst)
+		split(a, worst)
+		split(worst, b)
+	}
+	split(0, len(pts)-1)
+
+	sort.Ints(keep)
+	return keep
+}
+
+// Least squares fit of the PVI elevations so the straight grades between them
+// follow the target as closely as they can. Each PVI is a hat function reaching
+// to its neighbours, which gives a tridiagonal system
+func fitElevations(pvis []PVI, target []PVI) {
+
+	n := len(pvis)
+	lower := make([]float64, n)
+	diag := make([]float64, n)
+	upper := make([]float64, n)
+	rhs := make([]float64, n)
+
+	k := 0
+	for _, t := range target {
+		for k < n-2 && t.Distance > pvis[k+1].Distance {
+			k++
+		}
+		u := (t.Distance - pvis[k].Distance) / (pvis[k+1].Distance - pvis[k].Distance)
+		u = math.Max(0, math.Min(1, u))
+		w0, w1 := 1-u, u
+		diag[k] += w0 * w0
+		diag[k+1] += w1 * w1
+		upper[k] += w0 * w1
+		lower[k+1] += w0 * w1
+		rhs[k] += w0 * t.Elevation
+		rhs[k+1] += w1 * t.Elevation
+	}
+
+	// Thomas algorithm
+	for i := 1; i < n; i++ {
+		m := lower[i] / diag[i-1]
+		diag[i] -= m * upper[i-1]
+		rhs[i] -= m * rhs[i-1]
+	}
+	pvis[n-1].Elevation = rhs[n-1] / diag[n-1]
+	for i := n - 2; i >= 0; i-- {
+		pvis[i].Elevation = (rhs[i] - upper[i]*pvis[i+1].Elevation) / diag[i]
+	}
+}
+
+// Moves PVIs up or down so no grade between them is steeper than maxGrade,
+// then sets the grades in and out of each
+func clampGrades(pvis []PVI, maxGrade float64) {
+
+	for i := 1; i < len(pvis); i++ {
+		rise := maxGrade * (pvis[i].Distance - pvis[i-1].Distance)
+		pvis[i].Elevation = math.Max(pvis[i-1].Elevation-rise, math.Min(pvis[i-1].Elevation+rise, pvis[i].Elevation))
+	}
+	for i := len(pvis) - 2; i >= 0; i-- {
+		rise := maxGrade * (pvis[i+1].Distance - pvis[i].Distance)
+		pvis[i].Elevation = math.Max(pvis[i+1].Elevation-rise, math.Min(pvis[i+1].Elevation+rise, pvis[i].Elevation))
+	}
+
+	for i := range pvis {
+		if i > 0 {
+			pvis[i].GradeIn = (pvis[i].Elevation - pvis[i-1].Elevation) / (pvis[i].Distance - pvis[i-1].Distance)
+		}
+		if i < len(pvis)-1 {
+			pvis[i].GradeOut = (pvis[i+1].Elevation - pvis[i].Elevation) / (pvis[i+1].Distance - pvis[i].Distance)
+		}
+	}
+}
+
+// Length of the vertical curve at a PVI for the minimum radius of its kind
+func fitVerticalCurve(p *PVI, prev PVI, next PVI, lim VerticalLimits) {
+
+	p.Kind, p.Radius, p.CurveLength = "", 0, 0
+
+	change := p.GradeOut - p.GradeIn
+	if math.Abs(change) < 1e-9 {
+		return
+	}
+
+	p.Kind, p.Radius = "sag", lim.MinSagRadius
+	if change < 0 {
+		p.Kind, p.Radius = "crest", lim.MinCrestRadius
+	}
+	p.CurveLength = p.Radius * math.Abs(change)
+}
+
+// Elevation of the tube at a distance along the route
+func (vp *VerticalProfile) TubeAt(d float64) float64 {
+
+	pvis := vp.PVIs
+	if len(pvis) == 0 {
+		return 0
+	}
+	if d <= pvis[0].Distance {
+		return pvis[0].Elevation
+	}
+
+	for i, p := range pvis {
+		half := p.CurveLength / 2
+		if d < p.Distance-half {
+			// on the tangent from the previous PVI
+			return pvis[i-1].Elevation + pvis[i-1].GradeOut*(d-pvis[i-1].Distance)
+		}
+		if d <= p.Distance+half && half > 0 {
+			x := d - (p.Distance - half)
+			return p.Elevation - p.GradeIn*half + p.GradeIn*x + (p.GradeOut-p.GradeIn)/(2*p.CurveLength)*x*x
+		}
+	}
+
+	last := pvis[len(pvis)-1]
+	return last.Elevation
+}
+
+// Tube elevation less the ground at a distance along the route
+func (vp *VerticalProfile) HeightAt(d float64) float64 {
+	return vp.TubeAt(d) - vp.ground.At(d)
+}
+
+// Smallest radius of the vertical curves between distances a and b, 0 when the
+// stretch is all straight grades
+func (vp *VerticalProfile) RadiusIn(a float64, b float64) float64 {
+
+	rad := 0.0
+	for _, p := range vp.PVIs {
+		half := p.CurveLength / 2
+		if half == 0 || p.Distance+half < a || p.Distance-half > b {
+			continue
+		}
+		if rad == 0 || p.Radius < rad {
+			rad = p.Radius
+		}
+	}
+	return rad
+}
+
+// The same profile seen from the other end of the route. Crests and sags stay
+// what they are, the grades change sign
+func (vp *VerticalProfile) Reverse() *VerticalProfile {
+
+	rev := *vp
+	rev.ground = vp.ground.Reverse()
+
+	length := 0.0
+	if n := len(vp.Samples); n > 0 {
+		length = vp.Samples[n-1].Distance
+	}
+
+	rev.PVIs = make([]PVI, len(vp.PVIs))
+	for i, p := range vp.PVIs {
+		p.Distance = length - p.Distance
+		p.GradeIn, p.GradeOut = -p.GradeOut, -p.GradeIn
+		rev.PVIs[len(vp.PVIs)-1-i] = p
+	}
+
+	rev.Samples = make([]VerticalSample, len(vp.Samples))
+	for i, s := range vp.Samples {
+		s.Distance = length - s.Distance
+		rev.Samples[len(vp.Samples)-1-i] = s
+	}
+
+	rev.Tunnels = make([]Tunnel, len(vp.Tunnels))
+	for i, t := range vp.Tunnels {
+		t.Start, t.End = length-t.End, length-t.Start
+		rev.Tunnels[len(vp.Tunnels)-1-i] = t
+	}
+	return &rev
+}
+
+// GET /routes/{id}/vertical?max_grade=0.04&crest_radius=50000&sag_radius=30000
+func verticalHandler(w http.ResponseWriter, r *http.Request, route Route) {
+
+	if len(route.Segments) < 2 {
+		http.Error(w, "route needs at least two points", http.StatusBadRequest)
+		return
+	}
+
+	params, err := evalParamsFromQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	spacing, err := requestSpacing(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	geo, _ := parseGeodesic(params.Geodesic)
+	ground, err := sampleElevation(makeAlignment(route, geo), dem, spacing)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	writeJSON(w, designVertical(&ground, params.Vertical))
+}
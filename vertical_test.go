@@ -0,0 +1,110 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// Flat ground with a ridge 600 m high in the middle, 40 km long
+func testRidge() *ElevationProfile {
+	prof := &ElevationProfile{Spacing: 100}
+	for d := 0.0; d <= 40000; d += 100 {
+		elev := math.Max(0, 600-math.Abs(d-20000)*0.06)
+		prof.Samples = append(prof.Samples, ElevationSample{Distance: d, Elevation: elev})
+	}
+	return prof
+}
+
+func TestDesignVertical(t *testing.T) {
+
+	lim := defaultVerticalLimits()
+	vp := designVertical(testRidge(), lim)
+
+	if len(vp.PVIs) < 3 {
+		t.Fatalf("%d PVIs over a ridge", len(vp.PVIs))
+	}
+	crests := 0
+	for i, p := range vp.PVIs {
+		if math.Abs(p.GradeIn) > lim.MaxGrade+1e-9 || math.Abs(p.GradeOut) > lim.MaxGrade+1e-9 {
+			t.Errorf("PVI %d: grades %v and %v over %v", i, p.GradeIn, p.GradeOut, lim.MaxGrade)
+		}
+		if i == 0 || i == len(vp.PVIs)-1 {
+			continue
+		}
+		avail := halfLineRatio * math.Min(p.Distance-vp.PVIs[i-1].Distance, vp.PVIs[i+1].Distance-p.Distance)
+		if p.CurveLength/2 > avail+1e-6 {
+			t.Errorf("PVI %d: curve of %v m does not fit in %v m", i, p.CurveLength, 2*avail)
+		}
+		if p.Kind == "crest" {
+			crests++
+			if p.Radius != lim.MinCrestRadius {
+				t.Errorf("PVI %d: crest radius %v", i, p.Radius)
+			}
+		}
+	}
+	if crests == 0 {
+		t.Error("no crest over the ridge")
+	}
+
+	// the ridge is steeper than the tube may climb, so it goes under the top
+	if len(vp.Tunnels) != 1 || vp.Tunnels[0].Start > 20000 || vp.Tunnels[0].End < 20000 {
+		t.Errorf("tunnels %v, want one under the top of the ridge", vp.Tunnels)
+	}
+	if math.Abs(vp.HeightAt(0)-minPylonHeight) > pviTolerance {
+		t.Errorf("tube %v m over flat ground, want about %v", vp.HeightAt(0), minPylonHeight)
+	}
+
+	// the grade line has no steps
+	for d := 0.0; d < 40000; d += 10 {
+		if step := math.Abs(vp.TubeAt(d+10) - vp.TubeAt(d)); step > 10*lim.MaxGrade+1e-6 {
+			t.Fatalf("tube steps %v m at %v m", step, d)
+		}
+	}
+}
+
+func TestVerticalReverse(t *testing.T) {
+
+	vp := designVertical(testRidge(), defaultVerticalLimits())
+	rev := vp.Reverse()
+	for _, d := range []float64{0, 5000, 19000, 20000, 33333, 40000} {
+		if a, b := vp.TubeAt(d), rev.TubeAt(40000-d); math.Abs(a-b) > 1e-6 {
+			t.Errorf("tube at %v m is %v, reversed %v", d, a, b)
+		}
+		if a, b := vp.RadiusIn(d-100, d+100), rev.RadiusIn(40000-d-100, 40000-d+100); a != b {
+			t.Errorf("radius at %v m is %v, reversed %v", d, a, b)
+		}
+	}
+	if rev.TunnelLength != vp.TunnelLength || rev.Tunnels[0].Start != 40000-vp.Tunnels[0].End {
+		t.Errorf("reversed tunnels %v, want %v", rev.Tunnels, vp.Tunnels)
+	}
+}
+
+func TestVerticalSpeedLimit(t *testing.T) {
+
+	route := Route{Segments: []Segment{{Lat: 52, Lng: 4}, {Lat: 52.36, Lng: 4}}}
+	al := makeAlignment(route, ellipsoidal)
+	vp := designVertical(testRidge(), defaultVerticalLimits())
+	pod := podPresets[1]
+
+	flat := simulateSpeed(al, pod, nil)
+	over := simulateSpeed(al, pod, &vp)
+
+	limited := 0
+	for i, s := range over.Segments {
+		if s.VertRadius == 0 {
+			continue
+		}
+		if vmax := math.Sqrt(s.VertRadius * pod.MaxVertMss); s.SpeedLimit > vmax+1e-9 {
+			t.Errorf("segment %d: limit %v m/s over a %v m vertical curve, want at most %v", i, s.SpeedLimit, s.VertRadius, vmax)
+		}
+		if s.SpeedLimit < pod.MaxSpeed {
+			limited++
+		}
+	}
+	if limited == 0 {
+		t.Error("no segment limited by the vertical curves")
+	}
+	if over.Time <= flat.Time {
+		t.Errorf("%v s over the ridge, %v s on the flat", over.Time, flat.Time)
+	}
+}